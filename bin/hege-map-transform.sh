@@ -11,9 +11,12 @@ DST=$1 ; shift
 [[ -n "$SRC" ]]
 [[ -n "$DST" ]]
 
+# The pipeline of steps applied to each seed
+PIPELINE=${PIPELINE:-$SRC/pipeline.yml}
+
 for S in "$SRC"/*.seed.json ; do
 	D=$(basename "$S")
 	D=${D/.seed.json/.final.json}
-	hege tools map init < "$S" | hege tools map normalize > "$DST/$D"
+	hege tools map build "$S" "$PIPELINE" -o "$DST/$D"
 done
 
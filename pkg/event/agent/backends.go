@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtagent
+
+import (
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	evtbackbolt "github.com/jfsmig/hegemonie/pkg/event/backend-bolt"
+	evtbackmem "github.com/jfsmig/hegemonie/pkg/event/backend-mem"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type backendOpener func(cfg Config) (back.Backend, error)
+
+const (
+	defaultBackend     = "bolt"
+	defaultDedupWindow = 10 * time.Minute
+)
+
+// options translates the configuration into the tunables of the backend
+func (cfg Config) options() back.Options {
+	opts := back.Options{DedupWindow: cfg.DedupWindow}
+	if opts.DedupWindow == 0 {
+		opts.DedupWindow = defaultDedupWindow
+	} else if opts.DedupWindow < 0 {
+		opts.DedupWindow = 0
+	}
+	return opts
+}
+
+// backends is the registry of the storage implementations known by the service,
+// indexed by the value expected in the 'backend' field of the configuration.
+// Implementations depending on cgo register themselves when their build tag is set.
+var backends = map[string]backendOpener{
+	"bolt": func(cfg Config) (back.Backend, error) {
+		if cfg.PathBase == "" {
+			return nil, errors.New("missing path to the event data directory")
+		}
+		if rocksdbBase(cfg.PathBase) {
+			return nil, errors.NotValidf("rocksdb base in %s, cf. TECH.md", cfg.PathBase)
+		}
+		return evtbackbolt.Open(cfg.PathBase, cfg.options())
+	},
+	"memory": func(cfg Config) (back.Backend, error) {
+		return evtbackmem.Open(cfg.options()), nil
+	},
+}
+
+// rocksdbBase tells if the directory holds a RocksDB base, e.g. the base of
+// a former version of the service, where the bolt backend would silently
+// start an empty history.
+func rocksdbBase(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "CURRENT"))
+	return err == nil
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build rocksdb
+// +build rocksdb
+
+package evtagent
+
+import (
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	evtbacklocal "github.com/jfsmig/hegemonie/pkg/event/backend-local"
+	"github.com/juju/errors"
+)
+
+func init() {
+	backends["rocksdb"] = func(cfg Config) (back.Backend, error) {
+		if cfg.PathBase == "" {
+			return nil, errors.New("missing path to the event data directory")
+		}
+		return evtbacklocal.Open(cfg.PathBase, cfg.options())
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtagent
+
+import (
+	"github.com/juju/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBoltOnRocksDB checks that the bolt backend refuses to start an empty
+// history in the directory of a RocksDB base.
+func TestBoltOnRocksDB(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{Backend: "bolt", PathBase: dir}
+
+	b, err := backends["bolt"](cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if err = os.Remove(filepath.Join(dir, "events.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "CURRENT"), []byte("MANIFEST-000001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backends["bolt"](cfg); !errors.IsNotValid(err) {
+		t.Fatal("unexpected error", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "events.db")); !os.IsNotExist(err) {
+		t.Fatal("bolt base created", err)
+	}
+}
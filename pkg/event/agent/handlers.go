@@ -8,41 +8,62 @@ package evtagent
 import (
 	"context"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
-	back "github.com/jfsmig/hegemonie/pkg/event/backend-local"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
 	"github.com/jfsmig/hegemonie/pkg/event/proto"
 	"github.com/jfsmig/hegemonie/pkg/utils"
 	"github.com/juju/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
-	"math"
+	"google.golang.org/grpc/status"
+	"time"
 )
 
 // Config gathers the configuration fields required to start a gRPC Event API service.
 type Config struct {
+	// Backend names the storage implementation, see the 'backends' registry.
+	// An empty value selects the default one.
+	Backend  string `yaml:"backend" json:"backend"`
 	PathBase string `yaml:"base" json:"base"`
+
+	// DedupWindow is the period during which the replay of a push is ignored.
+	// Zero selects a default value, a negative value disables the deduplication.
+	DedupWindow time.Duration `yaml:"dedup_window" json:"dedup_window"`
+
+	Retention RetentionConfig `yaml:"retention" json:"retention"`
 }
 
 type eventService struct {
 	proto.UnimplementedConsumerServer
 	proto.UnimplementedProducerServer
+	proto.UnimplementedAdminServer
 
 	cfg     Config
-	backend *back.Backend
+	backend back.Backend
 }
 
 // Application implements the expectations of the application backend
-func (cfg Config) Application(_ context.Context) (utils.RegisterableMonitorable, error) {
-	if cfg.PathBase == "" {
-		return nil, errors.New("missing path to the event data directory")
+func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorable, error) {
+	if cfg.Backend == "" {
+		cfg.Backend = defaultBackend
+	}
+	open, ok := backends[cfg.Backend]
+	if !ok {
+		return nil, errors.NotSupportedf("event backend [%s]", cfg.Backend)
 	}
 
 	var err error
 	app := eventService{cfg: cfg}
-	app.backend, err = back.Open(app.cfg.PathBase)
+	app.backend, err = open(app.cfg)
 	if err != nil {
 		return nil, errors.NewNotValid(err, "backend error")
 	}
 
+	// The compaction also forgets the expired deduplication entries, that
+	// would otherwise accumulate without a retention policy.
+	if cfg.Retention.enabled() || app.cfg.options().DedupWindow > 0 {
+		go app.runCompaction(ctx)
+	}
 	return &app, nil
 }
 
@@ -50,8 +71,10 @@ func (cfg Config) Application(_ context.Context) (utils.RegisterableMonitorable,
 func (es *eventService) Register(grpcSrv *grpc.Server) error {
 	proto.RegisterProducerServer(grpcSrv, es)
 	proto.RegisterConsumerServer(grpcSrv, es)
+	proto.RegisterAdminServer(grpcSrv, es)
 	grpc_prometheus.Register(grpcSrv)
 	utils.Logger.Info().
+		Str("backend", es.cfg.Backend).
 		Str("base", es.cfg.PathBase).
 		Msg("ready")
 	return nil
@@ -63,11 +86,50 @@ func (es *eventService) Ack1(ctx context.Context, req *proto.Ack1Req) (*proto.No
 	return &proto.None{}, err
 }
 
+// AckMany marks a set of events as read so that they won't be listed again.
+func (es *eventService) AckMany(ctx context.Context, req *proto.AckManyReq) (*proto.AckRep, error) {
+	refs := make([]back.Ref, 0, len(req.Items))
+	for _, x := range req.Items {
+		refs = append(refs, back.Ref{When: x.When, ID: x.EvtId})
+	}
+	count, err := es.backend.AckMany(req.CharId, refs)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.AckRep{Count: count}, nil
+}
+
+// AckUpTo marks as read all the events not more recent than the marker.
+func (es *eventService) AckUpTo(ctx context.Context, req *proto.AckUpToReq) (*proto.AckRep, error) {
+	count, err := es.backend.AckUpTo(req.CharId, req.Marker)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.AckRep{Count: count}, nil
+}
+
+// Count returns the number of events not acknowledged yet.
+func (es *eventService) Count(ctx context.Context, req *proto.CountReq) (*proto.CountRep, error) {
+	count, err := es.backend.Count(req.CharId)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.CountRep{Count: count}, nil
+}
+
 // List streams event objects belonging to the user with the given ID. The objects are sorted by
 // decreasing timestamp then by increasing UUID. The events are served as they are stored, the
 // messages are not rendered.
 func (es *eventService) List(ctx context.Context, req *proto.ListReq) (*proto.ListRep, error) {
-	items, err := es.backend.List(req.CharId, req.Marker, req.Max)
+	categories := make([]back.Category, 0, len(req.Categories))
+	for _, c := range req.Categories {
+		if !back.Category(c).Valid() {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid category %d", c)
+		}
+		categories = append(categories, back.Category(c))
+	}
+
+	items, err := es.backend.List(req.CharId, req.Marker, req.Max, categories)
 	if err != nil {
 		return nil, err
 	}
@@ -75,22 +137,42 @@ func (es *eventService) List(ctx context.Context, req *proto.ListReq) (*proto.Li
 	rep := proto.ListRep{}
 	for _, x := range items {
 		rep.Items = append(rep.Items, &proto.ListItem{
-			CharId:  x.CharID,
-			When:    math.MaxUint64 - x.When,
-			EvtId:   x.ID,
-			Payload: x.Payload,
+			CharId:   x.CharID,
+			When:     x.When,
+			EvtId:    x.ID,
+			Payload:  x.Payload,
+			Category: proto.Category(x.Category),
+			Severity: proto.Severity(x.Severity),
 		})
 	}
 	return &rep, nil
 }
 
 // Push1 inserts an event in the log of the Character with the given ID.
-// The current timestamp will be used. An UUID will be generated.
+// The current timestamp will be used. The event ID is chosen by the caller,
+// and the replays of a push with the same ID are ignored by the backend.
 func (es *eventService) Push1(ctx context.Context, req *proto.Push1Req) (*proto.None, error) {
-	err := es.backend.Push1(req.CharId, req.EvtId, req.Payload)
+	category, severity := back.Category(req.Category), back.Severity(req.Severity)
+	if !category.Valid() {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid category %d", req.Category)
+	}
+	if !severity.Valid() {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid severity %d", req.Severity)
+	}
+	err := es.backend.Push1(req.CharId, req.EvtId, category, severity, req.Payload)
 	return &proto.None{}, err
 }
 
+// Purge removes the whole log of the Character with the given ID.
+func (es *eventService) Purge(ctx context.Context, req *proto.PurgeReq) (*proto.PurgeRep, error) {
+	count, err := es.backend.Purge(req.CharId)
+	if err != nil {
+		return nil, err
+	}
+	metricReclaimed.WithLabelValues("purge").Add(float64(count))
+	return &proto.PurgeRep{Count: count}, nil
+}
+
 // Check implements the one-shot health-check of the gRPC service
 func (es *eventService) Check(ctx context.Context) grpc_health_v1.HealthCheckResponse_ServingStatus {
 	return grpc_health_v1.HealthCheckResponse_SERVING
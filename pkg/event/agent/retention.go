@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtagent
+
+import (
+	"context"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+// RetentionConfig describes which events are reclaimed by the background
+// compaction job. No event is reclaimed when neither MaxAge nor MaxCount is
+// set, and the job then only runs to forget the expired deduplication entries.
+type RetentionConfig struct {
+	// MaxAge is the age beyond which an event is reclaimed, acknowledged or not.
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+	// MaxCount is the maximum number of events kept per Character.
+	MaxCount uint32 `yaml:"max_count" json:"max_count"`
+	// Period is the delay between two compactions.
+	Period time.Duration `yaml:"period" json:"period"`
+}
+
+const defaultCompactionPeriod = time.Hour
+
+var (
+	metricReclaimed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hege",
+		Subsystem: "evt",
+		Name:      "reclaimed_total",
+		Help:      "Number of event records removed, by reason",
+	}, []string{"reason"})
+
+	metricCompactions = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "hege",
+		Subsystem: "evt",
+		Name:      "compaction_seconds",
+		Help:      "Duration of the compactions of the event backend",
+	})
+)
+
+func (rc RetentionConfig) enabled() bool {
+	return rc.MaxAge > 0 || rc.MaxCount > 0
+}
+
+func (rc RetentionConfig) policy(now time.Time) back.Retention {
+	policy := back.Retention{MaxCount: rc.MaxCount}
+	if rc.MaxAge > 0 {
+		policy.OlderThan = uint64(now.Add(-rc.MaxAge).UnixNano())
+	}
+	return policy
+}
+
+// compact runs one compaction and accounts the reclaimed records
+func (es *eventService) compact() {
+	pre := time.Now()
+	stats, err := es.backend.Compact(es.cfg.Retention.policy(pre))
+	metricCompactions.Observe(time.Since(pre).Seconds())
+	metricReclaimed.WithLabelValues("expired").Add(float64(stats.Expired))
+	metricReclaimed.WithLabelValues("quota").Add(float64(stats.OverQuota))
+	if err != nil {
+		utils.Logger.Warn().Err(err).Msg("compaction error")
+	} else {
+		utils.Logger.Info().
+			Uint64("expired", stats.Expired).
+			Uint64("quota", stats.OverQuota).
+			Dur("t", time.Since(pre)).
+			Msg("compaction")
+	}
+}
+
+// runCompaction periodically compacts the backend until the context is done.
+func (es *eventService) runCompaction(ctx context.Context) {
+	period := es.cfg.Retention.Period
+	if period <= 0 {
+		period = defaultCompactionPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			es.compact()
+		}
+	}
+}
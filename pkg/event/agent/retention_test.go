@@ -0,0 +1,53 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtagent
+
+import (
+	"context"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	evtbackmem "github.com/jfsmig/hegemonie/pkg/event/backend-mem"
+	"testing"
+	"time"
+)
+
+// compactSpy reports the compactions of the in-memory backend it wraps
+type compactSpy struct {
+	back.Backend
+	compactions chan back.Retention
+}
+
+func (b *compactSpy) Compact(policy back.Retention) (back.Reclaimed, error) {
+	stats, err := b.Backend.Compact(policy)
+	select {
+	case b.compactions <- policy:
+	default:
+	}
+	return stats, err
+}
+
+func TestDedupSweepWithoutRetention(t *testing.T) {
+	spy := &compactSpy{compactions: make(chan back.Retention, 1)}
+	backends["spy"] = func(cfg Config) (back.Backend, error) {
+		spy.Backend = evtbackmem.Open(cfg.options())
+		return spy, nil
+	}
+	defer delete(backends, "spy")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := Config{Backend: "spy", DedupWindow: time.Millisecond, Retention: RetentionConfig{Period: time.Millisecond}}
+	if _, err := cfg.Application(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case policy := <-spy.compactions:
+		if policy != (back.Retention{}) {
+			t.Fatal("unexpected policy", policy)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dedup entries are never swept")
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtbackbolt
+
+import (
+	"bytes"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backend implements an evtback.Backend on top of bbolt, a pure-Go embedded
+// KV store. All the records are stored in a single file.
+// The event records are stored in one bucket, the number of unacknowledged
+// events of each Character is maintained in another bucket, and a third
+// bucket remembers the pushed event IDs for the sake of the deduplication.
+type Backend struct {
+	db   *bolt.DB
+	opts back.Options
+}
+
+var (
+	bucketEvents   = []byte("events")
+	bucketCounters = []byte("counters")
+	bucketDedup    = []byte("dedup")
+)
+
+// compactBatch is the maximum number of deletions per write transaction
+// during a compaction.
+const compactBatch = 1000
+
+// Open returns a Backend that's ready to work or an error.
+// The path designates a directory that is created if it doesn't exist.
+func Open(path string, opts back.Options) (*Backend, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, errors.Annotate(err, "mkdir")
+	}
+
+	db, err := bolt.Open(filepath.Join(path, "events.db"), 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Annotate(err, "open")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketEvents, bucketCounters, bucketDedup} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "bucket")
+	}
+
+	return &Backend{db: db, opts: opts}, nil
+}
+
+// Push1 inserts an event record in the current backend.
+// The timestamp is determined by the current backend itself.
+func (b *Backend) Push1(charID string, id string, category back.Category, severity back.Severity, payload []byte) error {
+	now := back.Now()
+	k := back.EncodeKey(charID, now, category, severity, id)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if b.opts.DedupWindow > 0 {
+			bucket := tx.Bucket(bucketDedup)
+			dk := dedupKey(charID, id)
+			if v := bucket.Get(dk); v != nil && b.opts.Duplicate(back.DecodeCounter(v), now) {
+				return nil
+			}
+			if err := bucket.Put(dk, back.EncodeCounter(now)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketEvents).Put(k, payload); err != nil {
+			return err
+		}
+		return addCounter(tx, charID, 1)
+	})
+}
+
+// Ack1 makes the event record cannot be listed anymore.
+// The current Backend implementation simply deletes the Item.
+func (b *Backend) Ack1(charID string, when uint64, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		_, err := deleteKeys(tx, charID, refKeys(tx, charID, []back.Ref{{When: when, ID: id}}))
+		return err
+	})
+}
+
+// AckMany deletes the referenced event records.
+func (b *Backend) AckMany(charID string, refs []back.Ref) (uint64, error) {
+	var count uint64
+	err := b.db.Update(func(tx *bolt.Tx) (err error) {
+		count, err = deleteKeys(tx, charID, refKeys(tx, charID, refs))
+		return err
+	})
+	return count, err
+}
+
+// AckUpTo deletes all the event records of the Character not more recent than the marker.
+func (b *Backend) AckUpTo(charID string, marker uint64) (uint64, error) {
+	var count uint64
+	err := b.db.Update(func(tx *bolt.Tx) (err error) {
+		needle := back.KeyPrefix(charID)
+		if marker < ^uint64(0) {
+			needle = back.SeekKey(charID, marker+1)
+		}
+		count, err = deleteKeys(tx, charID, collectKeys(tx, back.KeyPrefix(charID), needle))
+		return err
+	})
+	return count, err
+}
+
+// Count reads the counter of the Character
+func (b *Backend) Count(charID string) (uint64, error) {
+	var count uint64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = back.DecodeCounter(tx.Bucket(bucketCounters).Get([]byte(charID)))
+		return nil
+	})
+	return count, err
+}
+
+// List returns a sorted array of event records strictly older than the marker,
+// all belonging to the Character whose ID is given and to the given categories.
+func (b *Backend) List(charID string, marker uint64, max uint32, categories []back.Category) ([]back.Item, error) {
+	max = back.ListMax(max)
+	prefix := back.KeyPrefix(charID)
+	needle := back.SeekKey(charID, marker)
+
+	out := make([]back.Item, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketEvents).Cursor()
+		for k, v := c.Seek(needle); k != nil && uint32(len(out)) < max; k, v = c.Next() {
+			if !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			item, err := back.DecodeKey(charID, k)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !back.Match(categories, item.Category) {
+				continue
+			}
+			item.Payload = append([]byte{}, v...)
+			out = append(out, item)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Purge removes all the event records of the given Character.
+func (b *Backend) Purge(charID string) (uint64, error) {
+	var count uint64
+	prefix := back.KeyPrefix(charID)
+	err := b.db.Update(func(tx *bolt.Tx) (err error) {
+		count, err = deleteKeys(tx, charID, collectKeys(tx, prefix, prefix))
+		return err
+	})
+	return count, err
+}
+
+// Compact removes the event records that violate the retention policy,
+// and the deduplication entries out of the window.
+// The keys to be reclaimed are collected in a read-only transaction, then
+// deleted by batches to keep the write transactions short.
+func (b *Backend) Compact(policy back.Retention) (back.Reclaimed, error) {
+	var stats back.Reclaimed
+	keys := make([][]byte, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var current string
+		var rank uint32
+		c := tx.Bucket(bucketEvents).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			item, err := back.SplitKey(k)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if item.CharID != current {
+				current, rank = item.CharID, 0
+			}
+			if policy.Reclaim(rank, item.When, &stats) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			rank++
+		}
+		return nil
+	})
+	if err != nil {
+		return back.Reclaimed{}, err
+	}
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > compactBatch {
+			batch = batch[:compactBatch]
+		}
+		keys = keys[len(batch):]
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			// The batch may span several Characters
+			for len(batch) > 0 {
+				item, _ := back.SplitKey(batch[0])
+				charID := item.CharID
+				prefix := back.KeyPrefix(charID)
+				i := 1
+				for i < len(batch) && bytes.HasPrefix(batch[i], prefix) {
+					i++
+				}
+				if _, err := deleteKeys(tx, charID, batch[:i]); err != nil {
+					return err
+				}
+				batch = batch[i:]
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, b.compactDedup()
+}
+
+// compactDedup forgets the deduplication entries older than the window.
+func (b *Backend) compactDedup() error {
+	now := back.Now()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketDedup)
+		keys := make([][]byte, 0)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !b.opts.Duplicate(back.DecodeCounter(v), now) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close flushes and closes the underlying database file.
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+func dedupKey(charID, id string) []byte {
+	return []byte(charID + "/" + id)
+}
+
+// refKeys locates the keys of the referenced events. The references that
+// match no event are ignored.
+func refKeys(tx *bolt.Tx, charID string, refs []back.Ref) [][]byte {
+	keys := make([][]byte, 0, len(refs))
+	c := tx.Bucket(bucketEvents).Cursor()
+	for _, ref := range refs {
+		prefix := back.TimePrefix(charID, ref.When)
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if item, err := back.DecodeKey(charID, k); err == nil && item.ID == ref.ID {
+				keys = append(keys, append([]byte{}, k...))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// collectKeys returns a copy of all the keys with the given prefix, starting at the needle.
+func collectKeys(tx *bolt.Tx, prefix, needle []byte) [][]byte {
+	keys := make([][]byte, 0)
+	c := tx.Bucket(bucketEvents).Cursor()
+	for k, _ := c.Seek(needle); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	return keys
+}
+
+// deleteKeys removes the given event records of a Character, then updates
+// the counter of the Character accordingly. Missing records are ignored.
+func deleteKeys(tx *bolt.Tx, charID string, keys [][]byte) (uint64, error) {
+	var count uint64
+	bucket := tx.Bucket(bucketEvents)
+	for _, k := range keys {
+		if bucket.Get(k) == nil {
+			continue
+		}
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if count > 0 {
+		return count, addCounter(tx, charID, -int64(count))
+	}
+	return 0, nil
+}
+
+// addCounter alters the counter of the unacknowledged events of a Character.
+// The counter never goes below zero, and a zero counter is removed.
+func addCounter(tx *bolt.Tx, charID string, delta int64) error {
+	bucket := tx.Bucket(bucketCounters)
+	k := []byte(charID)
+	v := int64(back.DecodeCounter(bucket.Get(k))) + delta
+	if v <= 0 {
+		return bucket.Delete(k)
+	}
+	return bucket.Put(k, back.EncodeCounter(uint64(v)))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtbackbolt
+
+import (
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"github.com/jfsmig/hegemonie/pkg/event/backend/backendtest"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T, opts back.Options) back.Backend {
+		path, err := ioutil.TempDir("", "hege-evt-bolt-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(path) })
+		b, err := Open(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestDedupSweep(t *testing.T) {
+	path, err := ioutil.TempDir("", "hege-evt-bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	b, err := Open(path, back.Options{DedupWindow: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, id := range []string{"e0", "e1"} {
+		if err = b.Push1("c0", id, back.CategorySystem, back.SeverityInfo, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err = b.Compact(back.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(bucketDedup).Stats().KeyN; n != 0 {
+			t.Fatal(n, "dedup entries left")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,38 +3,38 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 
+//go:build rocksdb
+// +build rocksdb
+
 package evtbacklocal
 
 import (
 	"bytes"
-	"fmt"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
 	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
 	"github.com/tecbot/gorocksdb"
-	"math"
-	"strconv"
-	"strings"
-	"time"
+	"sync"
 )
 
-// Backend implements a
+// Backend implements an evtback.Backend on top of RocksDB.
+// It requires cgo, so that it is only built with the "rocksdb" build tag.
+// The counters of unacknowledged events share the keyspace of the events,
+// their read-modify-write cycles are serialized by a mutex. So are the
+// deduplication entries.
 type Backend struct {
-	db *gorocksdb.DB
-}
+	db   *gorocksdb.DB
+	opts back.Options
 
-// Item is an Event record.
-type Item struct {
-	// CharID the unique ID of the character the event belongs to
-	CharID string
-	// When the timestamp at which the Event occured
-	When uint64
-	// ID the unique ID of the event.
-	ID string
-	// Payload the actual data of the encoded parameters to render it.
-	Payload []byte
+	counters sync.Mutex
 }
 
+// compactBatch is the maximum number of deletions per write during a
+// compaction.
+const compactBatch = 1000
+
 // Open returns a Backend that's ready to work or an error
-func Open(path string) (*Backend, error) {
+func Open(path string, opts back.Options) (*Backend, error) {
 	options := gorocksdb.NewDefaultOptions()
 	options.SetCreateIfMissing(true)
 
@@ -42,81 +42,368 @@ func Open(path string) (*Backend, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = checkFormat(db); err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, path)
+	}
 
-	return &Backend{db: db}, nil
+	return &Backend{db: db, opts: opts}, nil
+}
+
+// checkFormat refuses the bases written by the former versions of the
+// service, whose keys carry neither the category nor the severity of the
+// events. Only the first event key is checked, a base isn't expected to mix
+// both formats.
+func checkFormat(db *gorocksdb.DB) error {
+	ropts := gorocksdb.NewDefaultReadOptions()
+	defer ropts.Destroy()
+	ropts.SetFillCache(false)
+	iterator := db.NewIterator(ropts)
+	defer iterator.Close()
+
+	metaPrefix := []byte(back.MetaPrefix)
+	iterator.SeekToFirst()
+	if iterator.Valid() && bytes.HasPrefix(iterator.Key().Data(), metaPrefix) {
+		// The metadata keys are contiguous, skip them at once
+		next := append([]byte{}, metaPrefix...)
+		next[len(next)-1]++
+		iterator.Seek(next)
+	}
+	if !iterator.Valid() {
+		return errors.Trace(iterator.Err())
+	}
+	if _, err := back.SplitKey(iterator.Key().Data()); err != nil {
+		return errors.NotValidf("events stored in a former format, cf. TECH.md")
+	}
+	return nil
 }
 
 // Push1 inserts an event record in the current backend.
 // The timestamps is determined by the current backend itself.
-func (b *Backend) Push1(charID string, id string, payload []byte) error {
-	opts := gorocksdb.NewDefaultWriteOptions()
-	opts.SetSync(false)
-	defer opts.Destroy()
+func (b *Backend) Push1(charID string, id string, category back.Category, severity back.Severity, payload []byte) error {
+	now := back.Now()
+	k := back.EncodeKey(charID, now, category, severity, id)
+	utils.Logger.Debug().Bytes("key", k).Msg("PUSH")
 
-	when := math.MaxUint64 - uint64(time.Now().UnixNano())
-	k := fmt.Sprintf("%s/%16X/%s", charID, when, id)
-	utils.Logger.Warn().Bytes("key", []byte(k)).Msg("PUSH")
-	return b.db.Put(opts, []byte(k), payload)
+	b.counters.Lock()
+	defer b.counters.Unlock()
+
+	batch := gorocksdb.NewWriteBatch()
+	defer batch.Destroy()
+	if b.opts.DedupWindow > 0 {
+		dk := back.DedupKey(charID, id)
+		ropts := gorocksdb.NewDefaultReadOptions()
+		defer ropts.Destroy()
+		v, err := b.db.GetBytes(ropts, dk)
+		if err != nil {
+			return err
+		}
+		if v != nil && b.opts.Duplicate(back.DecodeCounter(v), now) {
+			return nil
+		}
+		batch.Put(dk, back.EncodeCounter(now))
+	}
+	batch.Put(k, payload)
+	if err := b.addCounter(batch, charID, 1); err != nil {
+		return err
+	}
+	return b.write(batch)
 }
 
 // Ack1 removes makes the event record cannot be listed anymore.
 // The current Backend implementation simply deletes the Item.
 func (b *Backend) Ack1(charID string, when uint64, id string) error {
-	opts := gorocksdb.NewDefaultWriteOptions()
-	opts.SetSync(false)
-	defer opts.Destroy()
+	utils.Logger.Debug().Str("char", charID).Uint64("when", when).Str("id", id).Msg("DEL")
+	_, err := b.deleteKeys(charID, b.refKeys(charID, []back.Ref{{When: when, ID: id}}))
+	return err
+}
 
-	w := math.MaxUint64 - when
-	k := fmt.Sprintf("%s/%16X/%s", charID, w, id)
-	utils.Logger.Warn().Bytes("key", []byte(k)).Msg("DEL")
-	return b.db.Delete(opts, []byte(k))
+// AckMany deletes the referenced event records.
+func (b *Backend) AckMany(charID string, refs []back.Ref) (uint64, error) {
+	return b.deleteKeys(charID, b.refKeys(charID, refs))
 }
 
-// List returns a sorted array of event records started at the given timestamp
-// and unique ID, ut all belongng to the Character whose ID is given.
-func (b *Backend) List(charID string, when uint64, max uint32) ([]Item, error) {
-	if max <= 0 {
-		max = 100
-	} else if max > 1000 {
-		max = 1000
+// AckUpTo deletes all the event records of the Character not more recent than the marker.
+func (b *Backend) AckUpTo(charID string, marker uint64) (uint64, error) {
+	needle := back.KeyPrefix(charID)
+	if marker < ^uint64(0) {
+		needle = back.SeekKey(charID, marker+1)
 	}
+	return b.deleteKeys(charID, b.collectKeys(back.KeyPrefix(charID), needle))
+}
 
-	var w uint64
-	if when == 0 {
-		w = 0
-	} else {
-		w = math.MaxUint64 - when
+// Count reads the counter of the Character
+func (b *Backend) Count(charID string) (uint64, error) {
+	opts := gorocksdb.NewDefaultReadOptions()
+	defer opts.Destroy()
+	v, err := b.db.GetBytes(opts, back.CounterKey(charID))
+	if err != nil {
+		return 0, err
 	}
+	return back.DecodeCounter(v), nil
+}
 
-	prefix := []byte(fmt.Sprintf("%s/", charID))
-	needle := []byte(fmt.Sprintf("%s/%016X/", charID, w))
+// List returns a sorted array of event records strictly older than the marker,
+// all belonging to the Character whose ID is given and to the given categories.
+func (b *Backend) List(charID string, marker uint64, max uint32, categories []back.Category) ([]back.Item, error) {
+	max = back.ListMax(max)
+	prefix := back.KeyPrefix(charID)
+	needle := back.SeekKey(charID, marker)
 
 	opts := gorocksdb.NewDefaultReadOptions()
 	defer opts.Destroy()
 	opts.SetFillCache(true)
 	opts.SetVerifyChecksums(false)
 	iterator := b.db.NewIterator(opts)
+	defer iterator.Close()
 	iterator.Seek(needle)
 
-	var err error
-	out := make([]Item, 0)
-	for ; iterator.Valid(); iterator.Next() {
+	out := make([]back.Item, 0)
+	for ; iterator.ValidForPrefix(prefix) && uint32(len(out)) < max; iterator.Next() {
+		item, err := back.DecodeKey(charID, iterator.Key().Data())
+		if err != nil {
+			return nil, err
+		}
+		if !back.Match(categories, item.Category) {
+			continue
+		}
+		item.Payload = append([]byte{}, iterator.Value().Data()...)
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// Purge removes all the event records of the given Character.
+func (b *Backend) Purge(charID string) (uint64, error) {
+	prefix := back.KeyPrefix(charID)
+	return b.deleteKeys(charID, b.collectKeys(prefix, prefix))
+}
+
+// Compact removes the event records that violate the retention policy,
+// and the deduplication entries out of the window.
+// The keys to be reclaimed are collected without locking the counters, then
+// deleted by batches so that the pushes and the acknowledgements only wait
+// for one batch at once.
+func (b *Backend) Compact(policy back.Retention) (back.Reclaimed, error) {
+	var stats back.Reclaimed
+	keys := make([][]byte, 0)
+	dedup := make([][]byte, 0)
+
+	ropts := gorocksdb.NewDefaultReadOptions()
+	defer ropts.Destroy()
+	ropts.SetFillCache(false)
+	iterator := b.db.NewIterator(ropts)
+	defer iterator.Close()
+
+	metaPrefix := []byte(back.MetaPrefix)
+	dedupPrefix := []byte(back.DedupPrefix)
+	now := back.Now()
+	var current string
+	var rank uint32
+	for iterator.SeekToFirst(); iterator.Valid(); iterator.Next() {
 		k := iterator.Key().Data()
-		if !bytes.HasPrefix(k, prefix) {
-			break
+		if bytes.HasPrefix(k, metaPrefix) {
+			if bytes.HasPrefix(k, dedupPrefix) && !b.opts.Duplicate(back.DecodeCounter(iterator.Value().Data()), now) {
+				dedup = append(dedup, append([]byte{}, k...))
+			}
+			continue
+		}
+		item, err := back.SplitKey(k)
+		if err != nil {
+			return stats, err
 		}
-		tokens := strings.SplitN(string(k), "/", 3)
-		when, err = strconv.ParseUint(tokens[1], 16, 64)
-		out = append(out, Item{
-			CharID:  charID,
-			When:    when,
-			ID:      tokens[2],
-			Payload: iterator.Value().Data(),
-		})
+		if item.CharID != current {
+			current, rank = item.CharID, 0
+		}
+		if policy.Reclaim(rank, item.When, &stats) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		rank++
 	}
 
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > compactBatch {
+			batch = batch[:compactBatch]
+		}
+		keys = keys[len(batch):]
+		if err := b.compactEvents(batch); err != nil {
+			return stats, err
+		}
+	}
+	for len(dedup) > 0 {
+		batch := dedup
+		if len(batch) > compactBatch {
+			batch = batch[:compactBatch]
+		}
+		dedup = dedup[len(batch):]
+		if err := b.compactDedup(batch, now); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// compactEvents deletes a batch of event records, that may span several
+// Characters, in a single write.
+func (b *Backend) compactEvents(keys [][]byte) error {
+	b.counters.Lock()
+	defer b.counters.Unlock()
+
+	ropts := gorocksdb.NewDefaultReadOptions()
+	defer ropts.Destroy()
+	batch := gorocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	for len(keys) > 0 {
+		item, _ := back.SplitKey(keys[0])
+		prefix := back.KeyPrefix(item.CharID)
+		i := 1
+		for i < len(keys) && bytes.HasPrefix(keys[i], prefix) {
+			i++
+		}
+		if _, err := b.stageDeletes(batch, ropts, item.CharID, keys[:i]); err != nil {
+			return err
+		}
+		keys = keys[i:]
+	}
+	return b.write(batch)
+}
+
+// compactDedup deletes a batch of deduplication entries. An entry renewed by
+// a push since it has been collected is kept.
+func (b *Backend) compactDedup(keys [][]byte, now uint64) error {
+	b.counters.Lock()
+	defer b.counters.Unlock()
+
+	ropts := gorocksdb.NewDefaultReadOptions()
+	defer ropts.Destroy()
+	batch := gorocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	for _, k := range keys {
+		v, err := b.db.GetBytes(ropts, k)
+		if err != nil {
+			return err
+		}
+		if v != nil && !b.opts.Duplicate(back.DecodeCounter(v), now) {
+			batch.Delete(k)
+		}
+	}
+	return b.write(batch)
+}
+
+// Close releases the RocksDB handle
+func (b *Backend) Close() error {
+	b.db.Close()
+	return nil
+}
+
+func (b *Backend) write(batch *gorocksdb.WriteBatch) error {
+	opts := gorocksdb.NewDefaultWriteOptions()
+	opts.SetSync(false)
+	defer opts.Destroy()
+	return b.db.Write(opts, batch)
+}
+
+// refKeys locates the keys of the referenced events. The references that
+// match no event are ignored.
+func (b *Backend) refKeys(charID string, refs []back.Ref) [][]byte {
+	opts := gorocksdb.NewDefaultReadOptions()
+	defer opts.Destroy()
+	iterator := b.db.NewIterator(opts)
+	defer iterator.Close()
+
+	keys := make([][]byte, 0, len(refs))
+	for _, ref := range refs {
+		prefix := back.TimePrefix(charID, ref.When)
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+			k := iterator.Key().Data()
+			if item, err := back.DecodeKey(charID, k); err == nil && item.ID == ref.ID {
+				keys = append(keys, append([]byte{}, k...))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// collectKeys returns a copy of all the keys with the given prefix, starting at the needle.
+func (b *Backend) collectKeys(prefix, needle []byte) [][]byte {
+	opts := gorocksdb.NewDefaultReadOptions()
+	defer opts.Destroy()
+	iterator := b.db.NewIterator(opts)
+	defer iterator.Close()
+
+	keys := make([][]byte, 0)
+	for iterator.Seek(needle); iterator.ValidForPrefix(prefix); iterator.Next() {
+		keys = append(keys, append([]byte{}, iterator.Key().Data()...))
+	}
+	return keys
+}
+
+// deleteKeys removes the given event records of a Character, then updates
+// the counter of the Character accordingly. Missing records are ignored.
+func (b *Backend) deleteKeys(charID string, keys [][]byte) (uint64, error) {
+	b.counters.Lock()
+	defer b.counters.Unlock()
+
+	ropts := gorocksdb.NewDefaultReadOptions()
+	defer ropts.Destroy()
+	batch := gorocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	count, err := b.stageDeletes(batch, ropts, charID, keys)
+	if err != nil || count <= 0 {
+		return 0, err
+	}
+	if err = b.write(batch); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// stageDeletes stages in the batch the deletion of the given event records of
+// a Character that still exist, along with the alteration of its counter.
+// It must be called with the counters locked.
+func (b *Backend) stageDeletes(batch *gorocksdb.WriteBatch, ropts *gorocksdb.ReadOptions, charID string, keys [][]byte) (uint64, error) {
+	var count uint64
+	for _, k := range keys {
+		v, err := b.db.Get(ropts, k)
+		if err != nil {
+			return 0, err
+		}
+		exists := v.Exists()
+		v.Free()
+		if exists {
+			batch.Delete(k)
+			count++
+		}
+	}
+	if count <= 0 {
+		return 0, nil
+	}
+	if err := b.addCounter(batch, charID, -int64(count)); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// addCounter stages in the batch the alteration of the counter of a Character.
+// It must be called with the counters locked.
+func (b *Backend) addCounter(batch *gorocksdb.WriteBatch, charID string, delta int64) error {
+	if charID == "" || delta == 0 {
+		return nil
+	}
+	count, err := b.Count(charID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return out, nil
+	k := back.CounterKey(charID)
+	if v := int64(count) + delta; v > 0 {
+		batch.Put(k, back.EncodeCounter(uint64(v)))
+	} else {
+		batch.Delete(k)
+	}
+	return nil
 }
@@ -0,0 +1,64 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build rocksdb
+// +build rocksdb
+
+package evtbacklocal
+
+import (
+	"fmt"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"github.com/jfsmig/hegemonie/pkg/event/backend/backendtest"
+	"github.com/juju/errors"
+	"github.com/tecbot/gorocksdb"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T, opts back.Options) back.Backend {
+		path, err := ioutil.TempDir("", "hege-evt-rocksdb-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(path) })
+		b, err := Open(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+// TestLegacyFormat checks that a base written by a former version of the
+// service, with keys formatted as "<char>/<inverted time>/<id>", is refused.
+func TestLegacyFormat(t *testing.T) {
+	path, err := ioutil.TempDir("", "hege-evt-rocksdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	options := gorocksdb.NewDefaultOptions()
+	options.SetCreateIfMissing(true)
+	db, err := gorocksdb.OpenDb(options, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wopts := gorocksdb.NewDefaultWriteOptions()
+	defer wopts.Destroy()
+	legacy := fmt.Sprintf("%s/%16X/%s", "c0", uint64(math.MaxUint64)-back.Now(), "e0")
+	if err = db.Put(wopts, []byte(legacy), []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err = Open(path, back.Options{}); !errors.IsNotValid(errors.Cause(err)) {
+		t.Fatal("unexpected error", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtbackmem
+
+import (
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"sort"
+	"sync"
+)
+
+// Backend implements an evtback.Backend that keeps everything in RAM.
+// It is intended for tests and ephemeral sandboxes, nothing is persisted.
+type Backend struct {
+	rw   sync.RWMutex
+	opts back.Options
+	logs map[string][]back.Item
+	// pushed remembers when each event ID was pushed, by Character
+	pushed map[string]map[string]uint64
+}
+
+// Open returns a Backend that's ready to work
+func Open(opts back.Options) *Backend {
+	return &Backend{
+		opts:   opts,
+		logs:   make(map[string][]back.Item),
+		pushed: make(map[string]map[string]uint64),
+	}
+}
+
+// before tells if a is listed before b: by decreasing timestamp then by increasing ID.
+func before(a, b *back.Item) bool {
+	if a.When != b.When {
+		return a.When > b.When
+	}
+	return a.ID < b.ID
+}
+
+// Push1 inserts an event record in the current backend.
+// The timestamp is determined by the current backend itself.
+func (b *Backend) Push1(charID string, id string, category back.Category, severity back.Severity, payload []byte) error {
+	item := back.Item{
+		CharID:   charID,
+		When:     back.Now(),
+		ID:       id,
+		Category: category,
+		Severity: severity,
+		Payload:  append([]byte{}, payload...),
+	}
+
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	if b.opts.DedupWindow > 0 {
+		ids := b.pushed[charID]
+		if ids == nil {
+			ids = make(map[string]uint64)
+			b.pushed[charID] = ids
+		}
+		if first, ok := ids[id]; ok && b.opts.Duplicate(first, item.When) {
+			return nil
+		}
+		ids[id] = item.When
+	}
+
+	log := b.logs[charID]
+	idx := sort.Search(len(log), func(i int) bool { return !before(&log[i], &item) })
+	log = append(log, back.Item{})
+	copy(log[idx+1:], log[idx:])
+	log[idx] = item
+	b.logs[charID] = log
+	return nil
+}
+
+// Ack1 removes the Item from the log.
+func (b *Backend) Ack1(charID string, when uint64, id string) error {
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	b.ack(charID, when, id)
+	return nil
+}
+
+// AckMany removes the referenced Items from the log.
+func (b *Backend) AckMany(charID string, refs []back.Ref) (uint64, error) {
+	var count uint64
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	for _, ref := range refs {
+		if b.ack(charID, ref.When, ref.ID) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// AckUpTo removes from the log all the Items not more recent than the marker.
+func (b *Backend) AckUpTo(charID string, marker uint64) (uint64, error) {
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	log := b.logs[charID]
+	idx := sort.Search(len(log), func(i int) bool { return log[i].When <= marker })
+	count := uint64(len(log) - idx)
+	b.set(charID, log[:idx])
+	return count, nil
+}
+
+// Count returns the size of the log.
+func (b *Backend) Count(charID string) (uint64, error) {
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+	return uint64(len(b.logs[charID])), nil
+}
+
+// ack removes an Item and tells if it was present.
+// It must be called with the lock held in write mode.
+func (b *Backend) ack(charID string, when uint64, id string) bool {
+	needle := back.Item{When: when, ID: id}
+	log := b.logs[charID]
+	idx := sort.Search(len(log), func(i int) bool { return !before(&log[i], &needle) })
+	if idx < len(log) && log[idx].When == when && log[idx].ID == id {
+		b.set(charID, append(log[:idx], log[idx+1:]...))
+		return true
+	}
+	return false
+}
+
+// set replaces the log of a Character, the empty logs are forgotten.
+// It must be called with the lock held in write mode.
+func (b *Backend) set(charID string, log []back.Item) {
+	if len(log) > 0 {
+		b.logs[charID] = log
+	} else {
+		delete(b.logs, charID)
+	}
+}
+
+// List returns a sorted array of event records strictly older than the marker,
+// all belonging to the Character whose ID is given and to the given categories.
+func (b *Backend) List(charID string, marker uint64, max uint32, categories []back.Category) ([]back.Item, error) {
+	max = back.ListMax(max)
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+	log := b.logs[charID]
+	idx := 0
+	if marker > 0 {
+		idx = sort.Search(len(log), func(i int) bool { return log[i].When < marker })
+	}
+
+	out := make([]back.Item, 0)
+	for ; idx < len(log) && uint32(len(out)) < max; idx++ {
+		x := log[idx]
+		if !back.Match(categories, x.Category) {
+			continue
+		}
+		x.Payload = append([]byte{}, x.Payload...)
+		out = append(out, x)
+	}
+	return out, nil
+}
+
+// Purge removes the whole log of the given Character.
+func (b *Backend) Purge(charID string) (uint64, error) {
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	count := uint64(len(b.logs[charID]))
+	delete(b.logs, charID)
+	return count, nil
+}
+
+// Compact removes the Items that violate the retention policy.
+func (b *Backend) Compact(policy back.Retention) (back.Reclaimed, error) {
+	var stats back.Reclaimed
+
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	for charID, log := range b.logs {
+		kept := log[:0]
+		for idx, x := range log {
+			if !policy.Reclaim(uint32(idx), x.When, &stats) {
+				kept = append(kept, x)
+			}
+		}
+		b.set(charID, kept)
+	}
+
+	now := back.Now()
+	for charID, ids := range b.pushed {
+		for id, first := range ids {
+			if !b.opts.Duplicate(first, now) {
+				delete(ids, id)
+			}
+		}
+		if len(ids) <= 0 {
+			delete(b.pushed, charID)
+		}
+	}
+	return stats, nil
+}
+
+// Close releases the memory held by the Backend.
+func (b *Backend) Close() error {
+	b.rw.Lock()
+	defer b.rw.Unlock()
+	b.logs = make(map[string][]back.Item)
+	b.pushed = make(map[string]map[string]uint64)
+	return nil
+}
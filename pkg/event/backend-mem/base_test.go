@@ -0,0 +1,33 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtbackmem
+
+import (
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"github.com/jfsmig/hegemonie/pkg/event/backend/backendtest"
+	"testing"
+	"time"
+)
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T, opts back.Options) back.Backend { return Open(opts) })
+}
+
+func TestDedupSweep(t *testing.T) {
+	b := Open(back.Options{DedupWindow: time.Millisecond})
+	for _, id := range []string{"e0", "e1"} {
+		if err := b.Push1("c0", id, back.CategorySystem, back.SeverityInfo, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := b.Compact(back.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.pushed) != 0 {
+		t.Fatal("dedup entries left", b.pushed)
+	}
+}
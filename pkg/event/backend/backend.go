@@ -0,0 +1,318 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package evtback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/juju/errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is the storage abstraction behind the Event service.
+// Each Character owns a log of events, sorted by decreasing timestamp then by
+// increasing event ID. All the implementations must be safe for concurrent use.
+type Backend interface {
+	// Push1 inserts an event record in the log of the given Character.
+	// The timestamp is determined by the Backend itself.
+	// When the deduplication is enabled, pushing again an event ID already
+	// pushed for the same Character within the window succeeds without
+	// inserting anything, whether the former event has been acknowledged or not.
+	Push1(charID string, id string, category Category, severity Severity, payload []byte) error
+
+	// Ack1 makes the event record unlistable.
+	// Acknowledging an event that doesn't exist is not an error.
+	Ack1(charID string, when uint64, id string) error
+
+	// AckMany acknowledges each referenced event record of the given Character.
+	// It returns the number of records actually acknowledged.
+	AckMany(charID string, refs []Ref) (uint64, error)
+
+	// AckUpTo acknowledges all the event records of the given Character whose
+	// timestamp is lower or equal to the marker.
+	// It returns the number of records actually acknowledged.
+	AckUpTo(charID string, marker uint64) (uint64, error)
+
+	// Count returns the number of event records of the given Character that
+	// are still unacknowledged. The call must not scan the whole log.
+	Count(charID string) (uint64, error)
+
+	// List returns a page of event records belonging to the given Character,
+	// all strictly older than the marker. A zero marker starts at the most
+	// recent event. The max is a hint, bounded by the Backend.
+	// When categories are specified, only the events of those categories are
+	// returned.
+	List(charID string, marker uint64, max uint32, categories []Category) ([]Item, error)
+
+	// Purge removes all the event records of the given Character.
+	// It returns the number of records removed.
+	Purge(charID string) (uint64, error)
+
+	// Compact removes all the event records that violate the retention policy,
+	// for all the Characters. It also forgets the deduplication entries older
+	// than the window.
+	Compact(policy Retention) (Reclaimed, error)
+
+	// Close releases the resources held by the Backend.
+	Close() error
+}
+
+// Item is an Event record.
+type Item struct {
+	// CharID the unique ID of the character the event belongs to
+	CharID string
+	// When the timestamp at which the Event occured
+	When uint64
+	// ID the unique ID of the event.
+	ID string
+	// Category the kind of game activity the event relates to
+	Category Category
+	// Severity how much the event deserves the attention of the player
+	Severity Severity
+	// Payload the actual data of the encoded parameters to render it.
+	Payload []byte
+}
+
+// Category classifies the events by kind of game activity.
+type Category uint8
+
+// Severity ranks the events by level of importance for the player.
+type Severity uint8
+
+// The values match the enumerations of the event API.
+const (
+	CategorySystem Category = iota
+	CategoryMilitary
+	CategoryEconomy
+	CategoryDiplomacy
+	categoryCount
+)
+
+const (
+	SeverityInfo Severity = iota
+	SeverityNotice
+	SeverityWarning
+	SeverityCritical
+	severityCount
+)
+
+// Valid tells if the Category is known
+func (c Category) Valid() bool { return c < categoryCount }
+
+// Valid tells if the Severity is known
+func (s Severity) Valid() bool { return s < severityCount }
+
+// Match tells if an event of the given Category passes the filter.
+// An empty filter matches everything.
+func Match(categories []Category, c Category) bool {
+	if len(categories) <= 0 {
+		return true
+	}
+	for _, x := range categories {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Options gathers the tunables common to all the Backend implementations.
+type Options struct {
+	// DedupWindow is the period during which the push of an event whose ID
+	// has already been pushed for the same Character is ignored.
+	// Zero disables the deduplication.
+	DedupWindow time.Duration
+}
+
+// Duplicate tells if an event first pushed at the given timestamp makes a
+// replay at the current timestamp a duplicate.
+func (o Options) Duplicate(first, now uint64) bool {
+	return o.DedupWindow > 0 && first+uint64(o.DedupWindow) >= now
+}
+
+// Ref is the exact naming of an event record of a known Character
+type Ref struct {
+	When uint64
+	ID   string
+}
+
+// Retention describes the event records to be reclaimed by a compaction.
+type Retention struct {
+	// OlderThan is the timestamp under which the events are expired.
+	// Zero disables the expiration.
+	OlderThan uint64
+	// MaxCount is the maximum number of events kept per Character, the oldest
+	// are reclaimed first. Zero disables the quota.
+	MaxCount uint32
+}
+
+// Reclaimed counts the event records removed by a compaction.
+type Reclaimed struct {
+	Expired   uint64
+	OverQuota uint64
+}
+
+// Reclaim tells if the event at the given rank in its Character's log
+// (0 being the most recent) must be reclaimed, and accounts it.
+func (r Retention) Reclaim(rank uint32, when uint64, stats *Reclaimed) bool {
+	if r.MaxCount > 0 && rank >= r.MaxCount {
+		stats.OverQuota++
+		return true
+	}
+	if r.OlderThan > 0 && when < r.OlderThan {
+		stats.Expired++
+		return true
+	}
+	return false
+}
+
+const (
+	// DefaultListMax is the page size applied when the client gave no hint.
+	DefaultListMax = 100
+	// MaxListMax is the upper bound of the page size.
+	MaxListMax = 1000
+)
+
+// ListMax bounds the page size hint provided by a client.
+func ListMax(max uint32) uint32 {
+	if max <= 0 {
+		return DefaultListMax
+	}
+	if max > MaxListMax {
+		return MaxListMax
+	}
+	return max
+}
+
+var lastNow uint64
+
+// Now returns a timestamp in nanoseconds since the Epoch, strictly greater
+// than any value returned by a previous call in the same process. That spares
+// the backends the management of collisions among events pushed in a burst.
+func Now() uint64 {
+	for {
+		last := atomic.LoadUint64(&lastNow)
+		now := uint64(time.Now().UnixNano())
+		if now <= last {
+			now = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&lastNow, last, now) {
+			return now
+		}
+	}
+}
+
+// The key encoding below is shared by the backends built upon ordered KV stores.
+// The timestamp is inverted so that a forward iteration on the keys yields the
+// events from the most recent to the oldest. The category and the severity are
+// encoded in the key, after the timestamp, so that filtering the events doesn't
+// require to read the payloads.
+
+// KeyPrefix returns the prefix common to all the keys of the given Character.
+func KeyPrefix(charID string) []byte {
+	return []byte(charID + "/")
+}
+
+// TimePrefix returns the prefix common to all the keys of the given Character
+// at the given timestamp. It allows locating an event given its exact naming.
+func TimePrefix(charID string, when uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%016X/", charID, math.MaxUint64-when))
+}
+
+// EncodeKey returns the key of the event record.
+func EncodeKey(charID string, when uint64, category Category, severity Severity, id string) []byte {
+	return []byte(fmt.Sprintf("%s/%016X/%X%X/%s", charID, math.MaxUint64-when, category, severity, id))
+}
+
+// SeekKey returns the smallest key of the events of the given Character that
+// are strictly older than the marker.
+func SeekKey(charID string, marker uint64) []byte {
+	if marker == 0 {
+		return KeyPrefix(charID)
+	}
+	return TimePrefix(charID, marker-1)
+}
+
+// The backends that share one keyspace for the events and their metadata prefix
+// the keys of the metadata with MetaPrefix. Those keys sort before any event key.
+const (
+	// MetaPrefix is the prefix common to all the metadata keys.
+	MetaPrefix = "#"
+	// CounterPrefix is the prefix common to all the counter keys.
+	CounterPrefix = MetaPrefix + "count/"
+	// DedupPrefix is the prefix common to all the deduplication keys.
+	DedupPrefix = MetaPrefix + "dedup/"
+)
+
+// CounterKey returns the key of the counter of the unacknowledged events of
+// the given Character.
+func CounterKey(charID string) []byte {
+	return []byte(CounterPrefix + charID)
+}
+
+// DedupKey returns the key that remembers when the event with the given ID
+// was pushed for the given Character. Its value is encoded as a counter.
+func DedupKey(charID, id string) []byte {
+	return []byte(DedupPrefix + charID + "/" + id)
+}
+
+// EncodeCounter returns the value of a counter key.
+func EncodeCounter(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// DecodeCounter parses the value of a counter key, a missing value counts as zero.
+func DecodeCounter(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// SplitKey parses the key of an event record whose Character is unknown.
+// The Character IDs are expected to not contain any '/'.
+// The Item returned has no payload.
+func SplitKey(k []byte) (Item, error) {
+	idx := bytes.IndexByte(k, '/')
+	if idx < 0 {
+		return Item{}, errors.NotValidf("key format")
+	}
+	return DecodeKey(string(k[:idx]), k)
+}
+
+// DecodeKey parses the key of an event record whose Character is known.
+// The Item returned has no payload.
+func DecodeKey(charID string, k []byte) (Item, error) {
+	prefix := KeyPrefix(charID)
+	if !bytes.HasPrefix(k, prefix) {
+		return Item{}, errors.NotValidf("key prefix")
+	}
+	k = k[len(prefix):]
+	if len(k) < 20 || k[16] != '/' || k[19] != '/' {
+		return Item{}, errors.NotValidf("key format")
+	}
+	w, err := strconv.ParseUint(string(k[:16]), 16, 64)
+	if err != nil {
+		return Item{}, errors.NewNotValid(err, "key timestamp")
+	}
+	class, err := strconv.ParseUint(string(k[17:19]), 16, 8)
+	if err != nil {
+		return Item{}, errors.NewNotValid(err, "key class")
+	}
+	return Item{
+		CharID:   charID,
+		When:     math.MaxUint64 - w,
+		Category: Category(class >> 4),
+		Severity: Severity(class & 0x0F),
+		ID:       string(k[20:]),
+	}, nil
+}
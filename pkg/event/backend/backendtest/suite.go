@@ -0,0 +1,469 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package backendtest
+
+import (
+	"fmt"
+	back "github.com/jfsmig/hegemonie/pkg/event/backend"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Opener builds a fresh and empty Backend for a single conformance test.
+type Opener func(t *testing.T, opts back.Options) back.Backend
+
+// RunConformance plays the set of tests that every Backend implementation
+// must pass. It is intended to be called from the test suite of each
+// implementation.
+func RunConformance(t *testing.T, open Opener) {
+	for _, tc := range []struct {
+		name string
+		opts back.Options
+		run  func(*testing.T, back.Backend)
+	}{
+		{"Empty", back.Options{}, testEmpty},
+		{"Order", back.Options{}, testOrder},
+		{"Pagination", back.Options{}, testPagination},
+		{"Ack", back.Options{}, testAck},
+		{"Isolation", back.Options{}, testIsolation},
+		{"Concurrency", back.Options{}, testConcurrency},
+		{"Purge", back.Options{}, testPurge},
+		{"CompactExpired", back.Options{}, testCompactExpired},
+		{"CompactQuota", back.Options{}, testCompactQuota},
+		{"AckMany", back.Options{}, testAckMany},
+		{"AckUpTo", back.Options{}, testAckUpTo},
+		{"Count", back.Options{}, testCount},
+		{"Dedup", back.Options{DedupWindow: time.Hour}, testDedup},
+		{"DedupDisabled", back.Options{}, testDedupDisabled},
+		{"DedupExpired", back.Options{DedupWindow: time.Millisecond}, testDedupExpired},
+		{"Categories", back.Options{}, testCategories},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := open(t, tc.opts)
+			defer b.Close()
+			tc.run(t, b)
+		})
+	}
+}
+
+func mustPush(t *testing.T, b back.Backend, charID string, ids ...string) {
+	mustPushClass(t, b, charID, back.CategorySystem, back.SeverityInfo, ids...)
+}
+
+func mustPushClass(t *testing.T, b back.Backend, charID string, c back.Category, s back.Severity, ids ...string) {
+	for _, id := range ids {
+		if err := b.Push1(charID, id, c, s, []byte("payload-"+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mustList(t *testing.T, b back.Backend, charID string, marker uint64, max uint32, categories ...back.Category) []back.Item {
+	items, err := b.List(charID, marker, max, categories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range items {
+		if x.CharID != charID {
+			t.Fatal("unexpected character", x.CharID)
+		}
+		if string(x.Payload) != "payload-"+x.ID {
+			t.Fatal("unexpected payload", x.ID, string(x.Payload))
+		}
+		if !back.Match(categories, x.Category) {
+			t.Fatal("unexpected category", x.ID, x.Category)
+		}
+	}
+	return items
+}
+
+func checkCount(t *testing.T, b back.Backend, charID string, expected uint64) {
+	count, err := b.Count(charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != expected {
+		t.Fatalf("unexpected count for %s: %d/%d", charID, count, expected)
+	}
+}
+
+func checkIDs(t *testing.T, items []back.Item, ids ...string) {
+	if len(items) != len(ids) {
+		t.Fatalf("unexpected count %d/%d", len(items), len(ids))
+	}
+	for i, x := range items {
+		if x.ID != ids[i] {
+			t.Fatalf("unexpected item at %d: %s/%s", i, x.ID, ids[i])
+		}
+	}
+}
+
+func testEmpty(t *testing.T, b back.Backend) {
+	checkIDs(t, mustList(t, b, "c0", 0, 0))
+	checkIDs(t, mustList(t, b, "c0", back.Now(), 10))
+	if err := b.Ack1("c0", back.Now(), "nope"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testOrder(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3")
+	items := mustList(t, b, "c0", 0, 0)
+	checkIDs(t, items, "e3", "e2", "e1", "e0")
+	for i := 1; i < len(items); i++ {
+		if items[i-1].When <= items[i].When {
+			t.Fatal("unexpected order")
+		}
+	}
+}
+
+func testPagination(t *testing.T, b back.Backend) {
+	var ids []string
+	for i := 0; i < 25; i++ {
+		ids = append(ids, fmt.Sprintf("e%02d", i))
+	}
+	mustPush(t, b, "c0", ids...)
+
+	var all []back.Item
+	marker := uint64(0)
+	for {
+		items := mustList(t, b, "c0", marker, 10)
+		if len(items) <= 0 {
+			break
+		}
+		if len(items) > 10 {
+			t.Fatal("page too large", len(items))
+		}
+		all = append(all, items...)
+		marker = items[len(items)-1].When
+	}
+
+	var expected []string
+	for i := len(ids) - 1; i >= 0; i-- {
+		expected = append(expected, ids[i])
+	}
+	checkIDs(t, all, expected...)
+}
+
+func testAck(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2")
+	items := mustList(t, b, "c0", 0, 0)
+	checkIDs(t, items, "e2", "e1", "e0")
+
+	if err := b.Ack1("c0", items[1].When, items[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e2", "e0")
+
+	// Acknowledging twice is harmless
+	if err := b.Ack1("c0", items[1].When, items[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	// A mismatching timestamp doesn't match the event
+	if err := b.Ack1("c0", items[0].When+1, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e2", "e0")
+}
+
+func testIsolation(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1")
+	mustPush(t, b, "c00", "e2")
+	mustPush(t, b, "c1", "e3")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e1", "e0")
+	checkIDs(t, mustList(t, b, "c00", 0, 0), "e2")
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e3")
+	checkIDs(t, mustList(t, b, "c2", 0, 0))
+}
+
+func testConcurrency(t *testing.T, b back.Backend) {
+	const nbWorkers, nbEvents = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < nbWorkers; i++ {
+		wg.Add(1)
+		go func(charID string) {
+			defer wg.Done()
+			for j := 0; j < nbEvents; j++ {
+				id := fmt.Sprintf("e%02d", j)
+				if err := b.Push1(charID, id, back.CategorySystem, back.SeverityInfo, []byte("payload-"+id)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(fmt.Sprintf("c%d", i))
+	}
+	wg.Wait()
+
+	for i := 0; i < nbWorkers; i++ {
+		items := mustList(t, b, fmt.Sprintf("c%d", i), 0, back.MaxListMax)
+		if len(items) != nbEvents {
+			t.Fatal("unexpected count", len(items))
+		}
+	}
+}
+
+func testPurge(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2")
+	mustPush(t, b, "c1", "e3")
+	count, err := b.Purge("c0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatal("unexpected purge count", count)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0))
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e3")
+
+	count, err = b.Purge("c0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("unexpected purge count", count)
+	}
+}
+
+func testCompactExpired(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3")
+	mustPush(t, b, "c1", "e4")
+	items := mustList(t, b, "c0", 0, 0)
+
+	// Expire e0 and e1, strictly older than e2
+	stats, err := b.Compact(back.Retention{OlderThan: items[1].When})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Expired != 2 || stats.OverQuota != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e3", "e2")
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e4")
+
+	// Nothing more to reclaim
+	stats, err = b.Compact(back.Retention{OlderThan: items[1].When})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Expired != 0 || stats.OverQuota != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
+
+func testCompactQuota(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3")
+	mustPush(t, b, "c1", "e4", "e5")
+	mustPush(t, b, "c2", "e6")
+
+	stats, err := b.Compact(back.Retention{MaxCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Expired != 0 || stats.OverQuota != 4 {
+		t.Fatal("unexpected stats", stats)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e3")
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e5")
+	checkIDs(t, mustList(t, b, "c2", 0, 0), "e6")
+
+	// Zero values disable the retention
+	stats, err = b.Compact(back.Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Expired != 0 || stats.OverQuota != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
+
+func testAckMany(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3")
+	items := mustList(t, b, "c0", 0, 0)
+
+	count, err := b.AckMany("c0", []back.Ref{
+		{When: items[0].When, ID: items[0].ID},
+		{When: items[2].When, ID: items[2].ID},
+		// Unknown event, or known event with a mismatching timestamp
+		{When: items[1].When, ID: "nope"},
+		{When: items[1].When + 1, ID: items[1].ID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected ack count", count)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e2", "e0")
+
+	// Acknowledging twice is harmless
+	count, err = b.AckMany("c0", []back.Ref{{When: items[0].When, ID: items[0].ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("unexpected ack count", count)
+	}
+}
+
+func testAckUpTo(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3")
+	mustPush(t, b, "c1", "e4")
+	items := mustList(t, b, "c0", 0, 0)
+
+	// The marker is inclusive
+	count, err := b.AckUpTo("c0", items[2].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected ack count", count)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e3", "e2")
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e4")
+
+	count, err = b.AckUpTo("c0", items[0].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal("unexpected ack count", count)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0))
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e4")
+}
+
+func testCount(t *testing.T, b back.Backend) {
+	checkCount(t, b, "c0", 0)
+	mustPush(t, b, "c0", "e0", "e1", "e2", "e3", "e4", "e5")
+	mustPush(t, b, "c1", "e6", "e7")
+	checkCount(t, b, "c0", 6)
+	checkCount(t, b, "c1", 2)
+
+	items := mustList(t, b, "c0", 0, 0)
+	if err := b.Ack1("c0", items[0].When, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Ack1("c0", items[0].When, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, b, "c0", 5)
+
+	if _, err := b.AckMany("c0", []back.Ref{{When: items[1].When, ID: items[1].ID}}); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, b, "c0", 4)
+
+	if _, err := b.AckUpTo("c0", items[4].When); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, b, "c0", 2)
+
+	if _, err := b.Compact(back.Retention{MaxCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, b, "c0", 1)
+	checkCount(t, b, "c1", 1)
+
+	if _, err := b.Purge("c0"); err != nil {
+		t.Fatal(err)
+	}
+	checkCount(t, b, "c0", 0)
+	checkCount(t, b, "c1", 1)
+}
+
+func testDedup(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e1", "e0")
+	mustPush(t, b, "c1", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e1", "e0")
+	checkIDs(t, mustList(t, b, "c1", 0, 0), "e0")
+	checkCount(t, b, "c0", 2)
+
+	// A replay after the acknowledgement is still ignored
+	items := mustList(t, b, "c0", 0, 0)
+	if err := b.Ack1("c0", items[1].When, items[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	mustPush(t, b, "c0", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e1")
+	checkCount(t, b, "c0", 1)
+
+	// The compaction keeps the entries within the window
+	if _, err := b.Compact(back.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+	mustPush(t, b, "c0", "e0", "e1")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e1")
+}
+
+func testDedupDisabled(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e0", "e0")
+	checkCount(t, b, "c0", 2)
+}
+
+func testDedupExpired(t *testing.T, b back.Backend) {
+	mustPush(t, b, "c0", "e0")
+	time.Sleep(5 * time.Millisecond)
+	mustPush(t, b, "c0", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e0", "e0")
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := b.Compact(back.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+	mustPush(t, b, "c0", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0), "e0", "e0", "e0")
+}
+
+func testCategories(t *testing.T, b back.Backend) {
+	mustPushClass(t, b, "c0", back.CategoryMilitary, back.SeverityWarning, "e0")
+	mustPushClass(t, b, "c0", back.CategoryEconomy, back.SeverityInfo, "e1")
+	mustPushClass(t, b, "c0", back.CategoryMilitary, back.SeverityCritical, "e2")
+	mustPushClass(t, b, "c0", back.CategoryDiplomacy, back.SeverityNotice, "e3")
+	mustPushClass(t, b, "c0", back.CategorySystem, back.SeverityInfo, "e4")
+
+	all := mustList(t, b, "c0", 0, 0)
+	checkIDs(t, all, "e4", "e3", "e2", "e1", "e0")
+	for i, expected := range []struct {
+		c back.Category
+		s back.Severity
+	}{
+		{back.CategorySystem, back.SeverityInfo},
+		{back.CategoryDiplomacy, back.SeverityNotice},
+		{back.CategoryMilitary, back.SeverityCritical},
+		{back.CategoryEconomy, back.SeverityInfo},
+		{back.CategoryMilitary, back.SeverityWarning},
+	} {
+		if all[i].Category != expected.c || all[i].Severity != expected.s {
+			t.Fatal("unexpected class", all[i])
+		}
+	}
+
+	checkIDs(t, mustList(t, b, "c0", 0, 0, back.CategoryMilitary), "e2", "e0")
+	checkIDs(t, mustList(t, b, "c0", 0, 0, back.CategoryEconomy, back.CategorySystem), "e4", "e1")
+
+	// The pagination applies on the filtered stream
+	page := mustList(t, b, "c0", 0, 1, back.CategoryMilitary)
+	checkIDs(t, page, "e2")
+	checkIDs(t, mustList(t, b, "c0", page[0].When, 1, back.CategoryMilitary), "e0")
+
+	// The exact naming of an event doesn't depend on its class
+	if err := b.Ack1("c0", all[2].When, all[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0, back.CategoryMilitary), "e0")
+	count, err := b.AckMany("c0", []back.Ref{{When: all[4].When, ID: all[4].ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatal("unexpected ack count", count)
+	}
+	checkIDs(t, mustList(t, b, "c0", 0, 0, back.CategoryMilitary))
+	checkCount(t, b, "c0", 3)
+}
@@ -13,10 +13,33 @@ import (
 	"github.com/jfsmig/hegemonie/pkg/utils"
 	"github.com/juju/errors"
 	"google.golang.org/grpc"
+	"strings"
 )
 
 // ClientCLI gathers the event-related client actions available at the command line.
-type ClientCLI struct{}
+type ClientCLI struct {
+	// Category and Severity qualify the pushed events
+	Category string
+	Severity string
+	// Categories restricts the listing to those categories
+	Categories []string
+}
+
+func parseCategory(s string) (proto.Category, error) {
+	v, ok := proto.Category_value[strings.ToUpper(s)]
+	if !ok {
+		return 0, errors.NotValidf("category %s", s)
+	}
+	return proto.Category(v), nil
+}
+
+func parseSeverity(s string) (proto.Severity, error) {
+	v, ok := proto.Severity_value[strings.ToUpper(s)]
+	if !ok {
+		return 0, errors.NotValidf("severity %s", s)
+	}
+	return proto.Severity(v), nil
+}
 
 func (cfg *ClientCLI) connect(ctx context.Context, action utils.ActionFunc) error {
 	endpoint, err := utils.DefaultDiscovery.Event()
@@ -30,12 +53,26 @@ func (cfg *ClientCLI) connect(ctx context.Context, action utils.ActionFunc) erro
 // A descriptive error is returned in case of failure.
 // FIXME(jfsmig): no retry is performed upon error
 func (cfg *ClientCLI) DoPush(ctx context.Context, charID string, msg ...string) error {
+	category, err := parseCategory(cfg.Category)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	severity, err := parseSeverity(cfg.Severity)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
 		var err error
 		client := proto.NewProducerClient(cnx)
 		for _, a := range msg {
 			id := uuid.New().String()
-			_, e := client.Push1(ctx, &proto.Push1Req{CharId: charID, EvtId: id, Payload: []byte(a)})
+			_, e := client.Push1(ctx, &proto.Push1Req{
+				CharId:   charID,
+				EvtId:    id,
+				Payload:  []byte(a),
+				Category: category,
+				Severity: severity,
+			})
 			if e != nil {
 				if err == nil {
 					err = errors.Trace(e)
@@ -72,19 +109,95 @@ func (cfg *ClientCLI) DoAck(ctx context.Context, charID, evtID string, when uint
 	})
 }
 
+// DoAckMany consumes the messages whose owner is given on the command line, with
+// each message described by its timestamp and ID.
+// FIXME(jfsmig): no retry is performed upon error
+func (cfg *ClientCLI) DoAckMany(ctx context.Context, charID string, refs []*proto.EventRef) error {
+	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		client := proto.NewConsumerClient(cnx)
+		rep, err := client.AckMany(ctx, &proto.AckManyReq{CharId: charID, Items: refs})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		utils.Logger.Info().
+			Str("char", charID).
+			Int("asked", len(refs)).
+			Uint64("count", rep.Count).
+			Msg("ACK")
+		return nil
+	})
+}
+
+// DoAckUpTo consumes all the messages of a Character, not more recent than the timestamp.
+// FIXME(jfsmig): no retry is performed upon error
+func (cfg *ClientCLI) DoAckUpTo(ctx context.Context, charID string, when uint64) error {
+	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		client := proto.NewConsumerClient(cnx)
+		rep, err := client.AckUpTo(ctx, &proto.AckUpToReq{CharId: charID, Marker: when})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		utils.Logger.Info().
+			Str("char", charID).
+			Uint64("when", when).
+			Uint64("count", rep.Count).
+			Msg("ACK")
+		return nil
+	})
+}
+
+// DoCount prints to os.Stdout the number of unacknowledged messages of each Character.
+func (cfg *ClientCLI) DoCount(ctx context.Context, charIDs ...string) error {
+	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		client := proto.NewConsumerClient(cnx)
+		for _, charID := range charIDs {
+			rep, err := client.Count(ctx, &proto.CountReq{CharId: charID})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			fmt.Printf("%s %d\n", charID, rep.Count)
+		}
+		return nil
+	})
+}
+
+// DoPurge removes the whole log of the Character whose ID is given on the command line.
+func (cfg *ClientCLI) DoPurge(ctx context.Context, charID string) error {
+	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		client := proto.NewAdminClient(cnx)
+		rep, err := client.Purge(ctx, &proto.PurgeReq{CharId: charID})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		utils.Logger.Info().
+			Str("char", charID).
+			Uint64("count", rep.Count).
+			Msg("PURGE")
+		return nil
+	})
+}
+
 // DoList dumps to os.Stdout the Event objects streamed by the contacted service. The output consists
 // in a JSON stream of objects separated by a CRLF (i.e. one object per line)
 // FIXME(jfsmig): no retry is performed upon error
 func (cfg *ClientCLI) DoList(ctx context.Context, charID string, when uint64, marker string, max uint32) error {
+	req := proto.ListReq{CharId: charID, Marker: when, Max: max}
+	for _, s := range cfg.Categories {
+		c, err := parseCategory(s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		req.Categories = append(req.Categories, c)
+	}
 	return cfg.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
 		client := proto.NewConsumerClient(cnx)
-		rep, err := client.List(ctx, &proto.ListReq{CharId: charID, Marker: when, Max: 100})
+		rep, err := client.List(ctx, &req)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		anyError := false
 		for _, x := range rep.Items {
-			fmt.Printf("%s %d %s %s\n", x.CharId, x.When, x.EvtId, x.Payload)
+			fmt.Printf("%s %d %s %s %s %s\n", x.CharId, x.When, x.EvtId, x.Category, x.Severity, x.Payload)
 		}
 		if anyError {
 			return errors.New("Invalid events matched")
@@ -173,7 +173,7 @@ func (s {{.SetName}}) areItemsUnique() bool {
 		if l0 == a{{.F0}} && l1 == a{{.F1}} {
 			return false
 		}
-		l0 = a{{.F0}}
+		l0, l1 = a{{.F0}}, a{{.F1}}
 	}
 	return true
 }
@@ -200,7 +200,7 @@ func (s {{.SetName}}) Slice(m0 {{.T0}}, m1 {{.T1}}, max uint32) []{{.ItemType}}
 
 func (s {{.SetName}}) getIndex(f0 {{.T0}}, f1 {{.T1}}) int {
 	i := sort.Search(len(s), func(i int) bool {
-		return s[i]{{.F0}} >= f0 || (s[i]{{.F0}} == f0 && s[i]{{.F1}} >= f1)
+		return s[i]{{.F0}} > f0 || (s[i]{{.F0}} == f0 && s[i]{{.F1}} >= f1)
 	})
 	if i < len(s) && s[i]{{.F0}} == f0 && s[i]{{.F1}} == f1 {
 		return i
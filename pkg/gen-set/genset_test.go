@@ -87,6 +87,30 @@ func TestSetOfTwoFieldUS(t *testing.T) {
 	s.testRandomVacuum()
 }
 
+func TestSetOfTwoFieldGet(t *testing.T) {
+	s := make(setOfTwoFieldsUS, 0)
+	for _, f0 := range []uint64{1, 2, 3} {
+		for _, f1 := range []string{"a", "b", "c"} {
+			s.Add(&twoFieldsUS{f0, f1})
+		}
+	}
+	s.CheckThenFail()
+	for _, f0 := range []uint64{1, 2, 3} {
+		for _, f1 := range []string{"a", "b", "c"} {
+			if x := s.Get(f0, f1); x == nil || x.f0 != f0 || x.f1 != f1 {
+				t.Fatal("not found", f0, f1)
+			}
+		}
+		if s.Has(f0, "d") {
+			t.Fatal("unexpected item", f0)
+		}
+	}
+	s = append(s, &twoFieldsUS{3, "c"})
+	if s.Check() == nil {
+		t.Fatal("duplicate not detected")
+	}
+}
+
 // Only the tests around the functional cases are tested here.
 //go:generate go run github.com/jfsmig/hegemonie/pkg/gen-set ./genset_auto_test.go main:setOfUint64:uint64 :uint64
 //go:generate go run github.com/jfsmig/hegemonie/pkg/gen-set ./genset_auto_test.go main:setOfString:string :string
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	authclient "github.com/jfsmig/hegemonie/pkg/auth/client"
 	evtclient "github.com/jfsmig/hegemonie/pkg/event/client"
+	"github.com/jfsmig/hegemonie/pkg/event/proto"
 	mapclient "github.com/jfsmig/hegemonie/pkg/map/client"
 	regclient "github.com/jfsmig/hegemonie/pkg/region/client"
 	"github.com/jfsmig/hegemonie/pkg/utils"
@@ -19,6 +20,7 @@ import (
 	"gopkg.in/yaml.v3"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -56,7 +58,7 @@ func clients() *cobra.Command {
 	}
 
 	// Set a common reasonable timeout to all client RPC
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	sessionID := os.Getenv("HEGE_CLI_SESSIONID")
 	if sessionID == "" {
 		sessionID = "cli/" + uuid.New().String()
@@ -89,7 +91,7 @@ func clients() *cobra.Command {
 		}
 		return nil
 	}
-	//cmd.PersistentPostRun = func(_ *cobra.Command, _ []string) { cancel() }
+	cmd.PersistentPostRun = func(_ *cobra.Command, _ []string) { cancel() }
 
 	cmd.AddCommand(clientMap(ctx), clientEvent(ctx), clientAuth(ctx), clientRegion(ctx))
 	return cmd
@@ -211,7 +213,101 @@ func clientMap(ctx context.Context) *cobra.Command {
 	}
 	positions.Flags().Uint32VarP(&pathArgs.Max, "max", "m", 0, "List max N positions")
 
-	cmd.AddCommand(list, path, step, cities, roads, positions)
+	var neighborArgs mapclient.NeighborhoodArgs
+	neighborhood := &cobra.Command{
+		Use:     "neighborhood",
+		Short:   "List the positions around a position",
+		Example: "map neighborhood $MAPID $ID [$MARKER] --hops $N --distance $D",
+		Args:    cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := neighborArgs.PathArgs.Parse(args); err != nil {
+				return errors.Trace(err)
+			}
+			return cfg.GetNeighborhood(ctx, neighborArgs)
+		},
+	}
+	neighborhood.Flags().Uint32Var(&neighborArgs.MaxHops, "hops", 0, "Max number of roads from the position")
+	neighborhood.Flags().Uint64Var(&neighborArgs.MaxDistance, "distance", 0, "Max length of the path from the position")
+
+	distance := &cobra.Command{
+		Use:     "distance",
+		Short:   "Compute the length of the shortest path between two nodes",
+		Example: "map distance $MAPID $SRC $DST",
+		Args:    cobra.ExactArgs(3),
+		RunE:    hook(func() error { return cfg.GetDistance(ctx, pathArgs) }),
+	}
+
+	var editArgs mapclient.EditArgs
+	editHook := func(nb int, action func() error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			if err := editArgs.PathArgs.Parse(args[:nb]); err != nil {
+				return errors.Trace(err)
+			}
+			return action()
+		}
+	}
+
+	addVertex := &cobra.Command{
+		Use:     "add-vertex",
+		Short:   "Add a vertex to the map, connected to its peers",
+		Example: "map add-vertex $MAPID $ID -x $X -y $Y --link $PEER0,$PEER1",
+		Args:    cobra.ExactArgs(2),
+		RunE:    editHook(2, func() error { return cfg.AddVertex(ctx, editArgs) }),
+	}
+	addVertex.Flags().Uint64VarP(&editArgs.X, "x", "x", 0, "Abscissa of the vertex")
+	addVertex.Flags().Uint64VarP(&editArgs.Y, "y", "y", 0, "Ordinate of the vertex")
+	addVertex.Flags().StringVarP(&editArgs.City, "city", "c", "", "Name of the city carried by the vertex")
+	addVertex.Flags().UintSliceVarP(&editArgs.Links, "link", "l", nil, "Connect the vertex to that peer, in both directions")
+	addVertex.Flags().StringVarP(&editArgs.Terrain, "terrain", "t", "", "Terrain of the vertex (plain, forest, mountain, ford)")
+
+	removeVertex := &cobra.Command{
+		Use:     "remove-vertex",
+		Short:   "Remove a vertex and its roads from the map",
+		Example: "map remove-vertex $MAPID $ID",
+		Args:    cobra.ExactArgs(2),
+		RunE:    editHook(2, func() error { return cfg.RemoveVertex(ctx, editArgs) }),
+	}
+
+	addRoad := &cobra.Command{
+		Use:     "add-road",
+		Short:   "Add a road to the map",
+		Example: "map add-road $MAPID $SRC $DST",
+		Args:    cobra.ExactArgs(3),
+		RunE:    editHook(3, func() error { return cfg.AddRoad(ctx, editArgs) }),
+	}
+	addRoad.Flags().Uint64VarP(&editArgs.Weight, "weight", "w", 0, "Length of the road (0 to use the distance of the vertices)")
+	addRoad.Flags().StringVarP(&editArgs.Terrain, "terrain", "t", "", "Terrain along the road (empty to use the terrain of the destination)")
+	addRoad.Flags().Float64Var(&editArgs.Cost, "cost", 0, "Multiplier of the length of the road, prevailing over the terrain (0 to ignore)")
+
+	removeRoad := &cobra.Command{
+		Use:     "remove-road",
+		Short:   "Remove a road from the map",
+		Example: "map remove-road $MAPID $SRC $DST",
+		Args:    cobra.ExactArgs(3),
+		RunE:    editHook(3, func() error { return cfg.RemoveRoad(ctx, editArgs) }),
+	}
+
+	renameCity := &cobra.Command{
+		Use:     "rename-city",
+		Short:   "Set the name of the city of a vertex (an empty name removes the city)",
+		Example: "map rename-city $MAPID $ID $NAME",
+		Args:    cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			editArgs.City = args[2]
+			return editHook(2, func() error { return cfg.RenameCity(ctx, editArgs) })(cmd, args)
+		},
+	}
+
+	reload := &cobra.Command{
+		Use:     "reload",
+		Short:   "Load the new and changed maps of the repository of the service",
+		Example: "map reload",
+		Args:    cobra.NoArgs,
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.ReloadMaps(ctx) },
+	}
+
+	cmd.AddCommand(list, path, step, cities, roads, positions, neighborhood, distance,
+		addVertex, removeVertex, addRoad, removeRoad, renameCity, reload)
 	return cmd
 }
 
@@ -233,6 +329,8 @@ func clientEvent(ctx context.Context) *cobra.Command {
 		Args:    cobra.MinimumNArgs(2),
 		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoPush(ctx, args[0], args[1:]...) },
 	}
+	push.Flags().StringVarP(&cfg.Category, "category", "c", "system", "Category of the events (system, military, economy, diplomacy)")
+	push.Flags().StringVarP(&cfg.Severity, "severity", "s", "info", "Severity of the events (info, notice, warning, critical)")
 
 	list := &cobra.Command{
 		Use:     "list",
@@ -256,6 +354,7 @@ func clientEvent(ctx context.Context) *cobra.Command {
 		},
 	}
 	list.Flags().Uint32VarP(&max, "max", "m", 0, "List at most N events")
+	list.Flags().StringSliceVarP(&cfg.Categories, "category", "c", nil, "Only list the events of those categories")
 
 	ack := &cobra.Command{
 		Use:     "ack",
@@ -263,19 +362,67 @@ func clientEvent(ctx context.Context) *cobra.Command {
 		Example: `server event ack "${CHARACTER}" "${EVENT_UUID}" "${EVENT_TIMESTAMP}"`,
 		Args:    cobra.ExactArgs(3),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var when uint64
-			if len(args) > 2 {
-				var err error
-				when, err = strconv.ParseUint(args[1], 10, 63)
+			when, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			return cfg.DoAck(ctx, args[0], args[1], when)
+		},
+	}
+
+	ackMany := &cobra.Command{
+		Use:     "ack-many",
+		Short:   "Acknowledge several events of a Character",
+		Example: `server event ack-many "${CHARACTER}" "${EVENT_TIMESTAMP}/${EVENT_UUID}" ...`,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			refs := make([]*proto.EventRef, 0, len(args)-1)
+			for _, a := range args[1:] {
+				tokens := strings.SplitN(a, "/", 2)
+				if len(tokens) != 2 {
+					return errors.NotValidf("event reference [%s]", a)
+				}
+				when, err := strconv.ParseUint(tokens[0], 10, 64)
 				if err != nil {
 					return errors.Trace(err)
 				}
+				refs = append(refs, &proto.EventRef{When: when, EvtId: tokens[1]})
 			}
-			return cfg.DoAck(ctx, args[0], args[1], when)
+			return cfg.DoAckMany(ctx, args[0], refs)
+		},
+	}
+
+	ackUpTo := &cobra.Command{
+		Use:     "ack-up-to",
+		Short:   "Acknowledge all the events of a Character up to a timestamp (inclusive)",
+		Example: `server event ack-up-to "${CHARACTER}" "${EVENT_TIMESTAMP}"`,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			when, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			return cfg.DoAckUpTo(ctx, args[0], when)
 		},
 	}
 
-	cmd.AddCommand(push, ack, list)
+	count := &cobra.Command{
+		Use:     "count",
+		Short:   "Count the unacknowledged events of Characters",
+		Example: `server event count "${CHARACTER}" ...`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoCount(ctx, args...) },
+	}
+
+	purge := &cobra.Command{
+		Use:     "purge",
+		Short:   "Remove all the events of a Character",
+		Example: `server event purge "${CHARACTER}"`,
+		Args:    cobra.ExactArgs(1),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoPurge(ctx, args[0]) },
+	}
+
+	cmd.AddCommand(push, ack, ackMany, ackUpTo, count, list, purge)
 	return cmd
 
 }
@@ -401,11 +548,45 @@ func clientRegion(ctx context.Context) *cobra.Command {
 		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoRegionGetScores(ctx, args[0]) },
 	}
 
+	listSnapshots := &cobra.Command{
+		Use:     "snapshots",
+		Short:   "List the snapshots kept for the region",
+		Example: "hege client regions snapshots $REGION_ID",
+		Args:    cobra.ExactArgs(1),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoListSnapshots(ctx, args[0]) },
+	}
+
+	rollback := &cobra.Command{
+		Use:     "rollback",
+		Short:   "Restore the region as it was in a snapshot",
+		Example: "hege client regions rollback $REGION_ID $SNAPSHOT_ID",
+		Args:    cobra.ExactArgs(2),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoRollback(ctx, args[0], args[1]) },
+	}
+
+	exportSnapshot := &cobra.Command{
+		Use:     "export",
+		Short:   "Dump the JSON form of a snapshot of the region",
+		Example: "hege client regions export $REGION_ID $SNAPSHOT_ID > snapshot.json",
+		Args:    cobra.ExactArgs(2),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoExportSnapshot(ctx, args[0], args[1]) },
+	}
+
+	repinMap := &cobra.Command{
+		Use:     "repin",
+		Short:   "Pin the region on the version of its map currently served",
+		Example: "hege client regions repin $REGION_ID",
+		Args:    cobra.ExactArgs(1),
+		RunE:    func(cmd *cobra.Command, args []string) error { return cfg.DoRepinMap(ctx, args[0]) },
+	}
+
 	cmd.AddCommand(
 		createRegion, listRegions,
 		roundMovement, roundProduction,
 		pushStats, getStats,
-		getScore)
+		getScore,
+		listSnapshots, rollback, exportSnapshot,
+		repinMap)
 	return cmd
 }
 
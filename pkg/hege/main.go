@@ -8,6 +8,7 @@ package main
 import (
 	"context"
 	"github.com/jfsmig/hegemonie/pkg/map/client"
+	regclient "github.com/jfsmig/hegemonie/pkg/region/client"
 	"github.com/jfsmig/hegemonie/pkg/utils"
 	"github.com/juju/errors"
 	"github.com/spf13/cobra"
@@ -37,7 +38,7 @@ func tools() *cobra.Command {
 		RunE:  nonLeaf,
 	}
 	ctx := context.Background()
-	cmd.AddCommand(toolsMap(ctx))
+	cmd.AddCommand(toolsMap(ctx), toolsRegion(ctx))
 	return cmd
 }
 
@@ -49,12 +50,14 @@ func toolsMap(_ context.Context) *cobra.Command {
 		RunE:  nonLeaf,
 	}
 
+	var canvas mapclient.CanvasArgs
 	normalize := &cobra.Command{
 		Use:   "normalize",
 		Short: "Normalize the positions in a map (stdin/stdout)",
 		Long:  `Read the map description on the standard input, remap the positions of the vertices in the map graph so that they fit in the given boundaries and dump it to the standard output.`,
-		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolNormalize() },
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolNormalize(canvas) },
 	}
+	addCanvasFlags(normalize, &canvas)
 
 	var maxDist float64
 	split := &cobra.Command{
@@ -80,11 +83,13 @@ func toolsMap(_ context.Context) *cobra.Command {
 		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolDot() },
 	}
 
+	var svgArgs mapclient.SvgArgs
 	drawSvg := &cobra.Command{
 		Use:   "svg",
 		Short: "Convert the JSON map to SVG  (stdin/stdout)",
-		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolSvg() },
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolSvg(svgArgs) },
 	}
+	addSvgFlags(drawSvg, &svgArgs)
 
 	seedInit := &cobra.Command{
 		Use:     "init",
@@ -93,8 +98,136 @@ func toolsMap(_ context.Context) *cobra.Command {
 		RunE:    func(cmd *cobra.Command, args []string) error { return mapclient.ToolInit() },
 	}
 
-	cmd.AddCommand(normalize, split, noisify, drawDot, drawSvg, seedInit)
+	var genArgs mapclient.GenerateArgs
+	generate := &cobra.Command{
+		Use:   "generate",
+		Short: "Procedurally generate a JSON raw map (stdout)",
+		Long:  `Scatter sites in the given box, link them with roads, spread the cities on the sites and dump the raw map to the standard output. The same seed always produces the same map.`,
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolGenerate(genArgs) },
+	}
+	generate.Flags().StringVar(&genArgs.ID, "id", "generated", "Name of the map")
+	generate.Flags().UintVarP(&genArgs.Cities, "cities", "c", 16, "Number of cities")
+	generate.Flags().Float64VarP(&genArgs.Density, "density", "d", 0.25, "Ratio of cities among the sites, in ]0,1]")
+	generate.Flags().Uint64VarP(&genArgs.Width, "width", "x", 1920, "Width of the bounding box")
+	generate.Flags().Uint64VarP(&genArgs.Height, "height", "y", 1080, "Height of the bounding box")
+	generate.Flags().Int64VarP(&genArgs.Seed, "seed", "r", 0, "Seed of the random generator")
+
+	var proj mapclient.Projection
+	var geoID string
+	fromGeo := &cobra.Command{
+		Use:   "from-geojson",
+		Short: "Convert a GeoJSON feature collection to a JSON raw map (stdin/stdout)",
+		Long:  `Read a GeoJSON feature collection on the standard input, turn its points into sites and its lines into roads, project their coordinates to the positions of the map and dump the raw map to the standard output.`,
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolFromGeoJSON(geoID, proj) },
+	}
+	fromGeo.Flags().StringVar(&geoID, "id", "", "Name of the map (default: the name of the collection)")
+	fromGeo.Flags().BoolVar(&proj.Fit, "fit", false, "Place the origin at the top-left corner of the features")
+
+	toGeo := &cobra.Command{
+		Use:   "to-geojson",
+		Short: "Convert a JSON raw map to a GeoJSON feature collection (stdin/stdout)",
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolToGeoJSON(proj) },
+	}
+
+	for _, c := range []*cobra.Command{fromGeo, toGeo} {
+		c.Flags().Float64Var(&proj.Lon, "lon", 0, "Longitude of the origin of the map")
+		c.Flags().Float64Var(&proj.Lat, "lat", 0, "Latitude of the origin of the map")
+		c.Flags().Float64Var(&proj.Scale, "scale", 1, "Position units per degree")
+	}
+
+	var repair bool
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Diagnose the connectivity of a JSON raw map (stdin/stdout)",
+		Long:  `Read the map on the standard input and report its connected components, its dangling roads, its one-way roads and its isolated cities. With --repair, drop the invalid roads, add the shortest roads that make the map strongly connected and dump the repaired map to the standard output.`,
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolCheck(repair) },
+	}
+	check.Flags().BoolVar(&repair, "repair", false, "Dump the repaired map instead of the report")
+
+	var fairArgs mapclient.FairnessArgs
+	fairness := &cobra.Command{
+		Use:   "fairness",
+		Short: "Compare the starting positions of the cities of a map (stdin/stdout)",
+		Long:  `Read the map on the standard input and compute, for each city, the average distance to its nearest cities, its number of roads and its centrality. Flag the cities whose metrics are too far from the mean.`,
+		RunE:  func(cmd *cobra.Command, args []string) error { return mapclient.ToolFairness(fairArgs) },
+	}
+	fairness.Flags().IntVarP(&fairArgs.K, "neighbors", "k", 3, "Number of neighbor cities considered")
+	fairness.Flags().Float64VarP(&fairArgs.Threshold, "threshold", "t", 2, "Number of standard deviations beyond which a city is an outlier")
+
+	var buildArgs mapclient.BuildArgs
+	build := &cobra.Command{
+		Use:     "build",
+		Short:   "Produce a final JSON raw map from a seed through a pipeline of steps",
+		Long:    `Load the JSON map seed, apply in memory the steps of the YAML pipeline (split, noise, normalize), check the outcome as the map service would, then write the final map and its optional SVG and DOT previews.`,
+		Example: "map build calaquyr.seed.json pipeline.yml --svg calaquyr.svg",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			buildArgs.PathSeed, buildArgs.PathSpec = args[0], args[1]
+			return mapclient.ToolBuild(buildArgs)
+		},
+	}
+	addSvgFlags(build, &buildArgs.Svg)
+	build.Flags().StringVarP(&buildArgs.PathOutput, "output", "o", "", "Path of the final map (default: the seed path ending with .final.json)")
+	build.Flags().StringVar(&buildArgs.PathSvg, "svg", "", "Path of the SVG preview")
+	build.Flags().StringVar(&buildArgs.PathDot, "dot", "", "Path of the DOT preview")
+
+	var diffArgs regclient.DiffArgs
+	diff := &cobra.Command{
+		Use:     "diff",
+		Short:   "Compare two versions of a JSON raw map",
+		Long:    `Report the vertices added, removed or moved, the roads added or removed and the cities renamed between the two versions of the map. With --region, also report the cities and the armies of the region that sit on the removed vertices.`,
+		Example: "map diff old.final.json new.final.json --region live.json",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if diffArgs.PathRegion == "" {
+				return mapclient.ToolDiff(args[0], args[1])
+			}
+			diffArgs.PathOld, diffArgs.PathNew = args[0], args[1]
+			return regclient.ToolDiff(diffArgs)
+		},
+	}
+	diff.Flags().StringVar(&diffArgs.PathRegion, "region", "", "Path to the live JSON of a region using the old map")
+
+	cmd.AddCommand(normalize, split, noisify, drawDot, drawSvg, seedInit, generate, fromGeo, toGeo, check, fairness, diff, build)
 	return cmd
 }
 
+func toolsRegion(_ context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "region",
+		Short: "Region handling tools",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  nonLeaf,
+	}
+
+	var renderArgs regclient.RenderArgs
+	render := &cobra.Command{
+		Use:   "render",
+		Short: "Draw the state of a region on its map in SVG (stdin/stdout)",
+		Long:  `Read the live JSON of a region on the standard input, draw its map with the cities colored by faction, the armies heading to their targets and the ongoing fights, then dump the SVG to the standard output.`,
+		RunE:  func(cmd *cobra.Command, args []string) error { return regclient.ToolRender(renderArgs) },
+	}
+	addSvgFlags(render, &renderArgs.SvgArgs)
+	render.Flags().StringVarP(&renderArgs.PathMap, "map", "m", "", "Path to the JSON raw map of the region")
+	render.Flags().BoolVar(&renderArgs.ByOverlord, "overlord", false, "Color the cities after their overlord instead of their owner")
+	render.MarkFlagRequired("map")
+
+	cmd.AddCommand(render)
+	return cmd
+}
+
+func addSvgFlags(cmd *cobra.Command, svg *mapclient.SvgArgs) {
+	addCanvasFlags(cmd, &svg.CanvasArgs)
+	cmd.Flags().BoolVar(&svg.Labels, "labels", false, "Write the name of the cities")
+	cmd.Flags().BoolVar(&svg.Weights, "weights", false, "Write the length of the roads")
+	cmd.Flags().BoolVar(&svg.Classes, "classes", false, "Add CSS classes to each vertex")
+}
+
+func addCanvasFlags(cmd *cobra.Command, canvas *mapclient.CanvasArgs) {
+	cmd.Flags().Uint64Var(&canvas.Width, "width", 1920, "Width of the bounding box")
+	cmd.Flags().Uint64Var(&canvas.Height, "height", 1080, "Height of the bounding box")
+	cmd.Flags().Uint64Var(&canvas.Padding, "padding", 50, "Margin on each side of the bounding box")
+	cmd.Flags().BoolVar(&canvas.Stretch, "stretch", false, "Fill the bounding box without preserving the aspect ratio")
+}
+
 func nonLeaf(_ *cobra.Command, _ []string) error { return errors.New("missing subcommand") }
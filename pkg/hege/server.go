@@ -185,6 +185,7 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 	var err error
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	grpcSrv, err = srv.serverTLS()
 	if err != nil {
@@ -200,6 +201,7 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 		if err != nil {
 			return errors.Annotate(err, "App config error")
 		}
+		srv.apps = append(srv.apps, app)
 	}
 
 	grpc_health_v1.RegisterHealthServer(grpcSrv, srv)
@@ -212,7 +214,6 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 	if srv.EndpointMonitor != "" {
 		listenerMon, err = net.Listen("tcp", srv.EndpointMonitor)
 		if err != nil {
-			cancel()
 			return errors.NewNotValid(err, "listen error")
 		}
 
@@ -223,6 +224,10 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL, syscall.SIGABRT)
 	defer signal.Stop(stopChan)
 
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+
 	var barrier sync.WaitGroup
 	runner := func(wg *sync.WaitGroup, tag string, cb func() error) {
 		defer wg.Done()
@@ -242,11 +247,15 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 		go runner(&barrier, "monitor", func() error { return prometheusExporter.Serve(listenerMon) })
 	}
 
-	select {
-	case <-stopChan:
-		break
-	case <-ctx.Done():
-		break
+	for running := true; running; {
+		select {
+		case <-stopChan:
+			running = false
+		case <-ctx.Done():
+			running = false
+		case <-hupChan:
+			srv.reload(ctx)
+		}
 	}
 	cancel()
 
@@ -264,6 +273,17 @@ func (srv *srvCommons) runServer(registrators ...appGenerator) error {
 	return nil
 }
 
+// reload asks all the applications that support it to reload their data
+func (srv *srvCommons) reload(ctx context.Context) {
+	for _, app := range srv.apps {
+		if r, ok := app.(utils.Reloadable); ok {
+			if err := r.Reload(ctx); err != nil {
+				utils.Logger.Warn().Err(err).Msg("reload error")
+			}
+		}
+	}
+}
+
 func (srv *srvCommons) wrapPreRun(srvtype string) func(*cobra.Command, []string) error {
 	return func(*cobra.Command, []string) (err error) {
 		srv.ServiceType = srvtype
@@ -0,0 +1,118 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapagent
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/jfsmig/hegemonie/pkg/map/proto"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+)
+
+// AddVertex inserts a vertex, connected to the map with the roads of the request
+func (s *srvMap) AddVertex(ctx context.Context, req *proto.AddVertexReq) (*proto.MapVersion, error) {
+	if req.Vertex == nil {
+		return nil, errors.BadRequestf("missing vertex")
+	}
+	v := vertexP2M(req.Vertex, req.City)
+	roads := make([]mapgraph.Edge, 0, len(req.Roads))
+	for _, r := range req.Roads {
+		roads = append(roads, edgeP2M(r))
+	}
+	return s._edit(req.MapName, func(m *mapgraph.Map) error { return m.AddVertex(v, roads...) })
+}
+
+// RemoveVertex deletes a vertex and its roads
+func (s *srvMap) RemoveVertex(ctx context.Context, req *proto.VertexReq) (*proto.MapVersion, error) {
+	return s._edit(req.MapName, func(m *mapgraph.Map) error { return m.RemoveVertex(req.Id) })
+}
+
+// AddRoad inserts a road between two existing vertices
+func (s *srvMap) AddRoad(ctx context.Context, req *proto.RoadReq) (*proto.MapVersion, error) {
+	if req.Road == nil {
+		return nil, errors.BadRequestf("missing road")
+	}
+	r := edgeP2M(req.Road)
+	return s._edit(req.MapName, func(m *mapgraph.Map) error { return m.AddRoad(r) })
+}
+
+// RemoveRoad deletes a road, e.g. when a bridge is destroyed
+func (s *srvMap) RemoveRoad(ctx context.Context, req *proto.RoadReq) (*proto.MapVersion, error) {
+	if req.Road == nil {
+		return nil, errors.BadRequestf("missing road")
+	}
+	return s._edit(req.MapName, func(m *mapgraph.Map) error { return m.RemoveRoad(req.Road.Src, req.Road.Dst) })
+}
+
+// RenameCity sets the name of the city carried by a vertex
+func (s *srvMap) RenameCity(ctx context.Context, req *proto.RenameCityReq) (*proto.MapVersion, error) {
+	return s._edit(req.MapName, func(m *mapgraph.Map) error { return m.RenameCity(req.Id, req.City) })
+}
+
+// _edit applies the alteration to the map under the write lock, then persists
+// the map in the repository. The alteration is reverted if the map cannot be
+// persisted, so that the service never serves a map that would be lost at the
+// next restart.
+func (s *srvMap) _edit(name string, action func(*mapgraph.Map) error) (*proto.MapVersion, error) {
+	var rep proto.MapVersion
+	err := s._get('w', name, func(m *mapgraph.Map) error {
+		// The alteration replaces the content of the map without altering
+		// the previous one, so that a shallow copy is enough to revert it.
+		saved := *m
+		if err := action(m); err != nil {
+			return err
+		}
+		if err := s.save(m); err != nil {
+			*m = saved
+			return errors.Annotate(err, "persistence error")
+		}
+		rep.Name, rep.Version = m.ID, m.Version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	utils.Logger.Info().Str("map", rep.Name).Uint64("version", rep.Version).Msg("edited")
+	return &rep, nil
+}
+
+// save atomically replaces the file of the map in the repository. A map that
+// wasn't loaded from a file gets a new file in the repository.
+func (s *srvMap) save(m *mapgraph.Map) error {
+	path, ok := s.paths[m.ID]
+	if !ok {
+		path = filepath.Join(s.config.PathRepository, m.ID+".final.json")
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", " ")
+	err = encoder.Encode(m)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Trace(err)
+	}
+	s.paths[m.ID] = path
+	// The next reload must not consider the file has changed
+	if info, err := os.Stat(path); err == nil {
+		s.files[path] = stampOf(info)
+	}
+	return nil
+}
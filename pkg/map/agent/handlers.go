@@ -15,31 +15,53 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Config gathers the configuration fields required to start a gRPC map API service.
 type Config struct {
 	PathRepository string `yaml:"repository" json:"repository"`
+	// ReloadPeriod is the delay between two scans of the repository for
+	// new or changed maps. No periodic scan happens when zero.
+	ReloadPeriod time.Duration `yaml:"reload_period" json:"reload_period"`
 }
 
 type srvMap struct {
 	proto.UnimplementedMapServer
+	proto.UnimplementedAdminServer
 
 	config Config
 	maps   mapgraph.SetOfMaps
-	rw     sync.RWMutex
+	// paths maps the name of each map to the file it has been loaded from
+	paths map[string]string
+	// files tells the version of each file at its last load
+	files map[string]fileStamp
+	rw    sync.RWMutex
+
+	// reload serializes the reloads of the repository
+	reload sync.Mutex
 }
 
 // Application implements the expectations of the application backend
 func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorable, error) {
-	app := &srvMap{config: cfg, maps: make(mapgraph.SetOfMaps, 0)}
-	if err := app.LoadDirectory(cfg.PathRepository); err != nil {
+	app := &srvMap{
+		config: cfg,
+		maps:   make(mapgraph.SetOfMaps, 0),
+		paths:  make(map[string]string),
+		files:  make(map[string]fileStamp),
+	}
+	report, err := app.load()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if len(report.Failed) > 0 {
+		return nil, errors.NotValidf("map files %v", report.Failed)
+	}
+	if cfg.ReloadPeriod > 0 {
+		go app.runReloads(ctx, cfg.ReloadPeriod)
+	}
 	return app, nil
 }
 
@@ -47,6 +69,7 @@ func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorabl
 // ctx is used for a clean stop of the service.
 func (s *srvMap) Register(grpcSrv *grpc.Server) error {
 	proto.RegisterMapServer(grpcSrv, s)
+	proto.RegisterAdminServer(grpcSrv, s)
 	grpc_prometheus.Register(grpcSrv)
 	utils.Logger.Info().
 		Int("maps", s.maps.Len()).
@@ -75,7 +98,7 @@ func (s *srvMap) Vertices(req *proto.ListVerticesReq, stream proto.Map_VerticesS
 				return nil
 			}
 			for _, x := range vertices {
-				err := stream.Send(&proto.Vertex{Id: x.ID, X: x.X, Y: x.Y})
+				err := stream.Send(vertexM2P(x))
 				if err != nil {
 					return errors.Trace(err)
 				}
@@ -95,7 +118,8 @@ func (s *srvMap) Edges(req *proto.ListEdgesReq, stream proto.Map_EdgesServer) er
 				return nil
 			}
 			for _, x := range edges {
-				err := stream.Send(&proto.Edge{Src: x.S, Dst: x.D})
+				err := stream.Send(&proto.Edge{Src: x.S, Dst: x.D, Weight: m.RoadWeight(x),
+					Terrain: string(x.Terrain), Cost: x.Cost})
 				if err != nil {
 					return errors.Trace(err)
 				}
@@ -126,6 +150,41 @@ func (s *srvMap) GetPath(req *proto.PathRequest, stream proto.Map_GetPathServer)
 	})
 }
 
+// Neighborhood streams the vertices around a vertex, sorted by ID.
+func (s *srvMap) Neighborhood(req *proto.NeighborhoodReq, stream proto.Map_NeighborhoodServer) error {
+	return s._get('r', req.MapName, func(m *mapgraph.Map) error {
+		neighbors, err := m.Neighborhood(req.Id, req.MaxHops, req.MaxDistance)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		i := sort.Search(len(neighbors), func(i int) bool { return neighbors[i].ID > req.Marker })
+		for _, n := range neighbors[i:] {
+			err = stream.Send(&proto.Neighbor{Id: n.ID, Hops: n.Hops, Distance: n.Distance})
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+// Distance computes the length of the shortest path between two vertices.
+func (s *srvMap) Distance(ctx context.Context, req *proto.DistanceReq) (*proto.DistanceRep, error) {
+	var rep proto.DistanceRep
+	err := s._get('r', req.MapName, func(m *mapgraph.Map) error {
+		var err error
+		rep.Distance, rep.Hops, err = m.Distance(req.Src, req.Dst)
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
 // Cities streams City <ID,name> pair objects
 func (s *srvMap) Cities(req *proto.ListCitiesReq, stream proto.Map_CitiesServer) error {
 	return s._get('r', req.MapName, func(m *mapgraph.Map) error {
@@ -137,7 +196,11 @@ func (s *srvMap) Cities(req *proto.ListCitiesReq, stream proto.Map_CitiesServer)
 			}
 			for _, v := range cities {
 				if v.City != "" {
-					err := stream.Send(&proto.CityLocation{Id: v.ID, Name: v.City})
+					loc := &proto.CityLocation{Id: v.ID, Name: v.City, Terrain: string(v.Terrain)}
+					if v.Bonus != nil {
+						loc.Mult, loc.Plus = v.Bonus.Mult, v.Bonus.Plus
+					}
+					err := stream.Send(loc)
 					if err == io.EOF {
 						return nil
 					}
@@ -153,37 +216,35 @@ func (s *srvMap) Cities(req *proto.ListCitiesReq, stream proto.Map_CitiesServer)
 
 // Maps streams the name of the maps registered in the current service
 func (s *srvMap) Maps(req *proto.ListMapsReq, stream proto.Map_MapsServer) error {
-	// Extract stats on a slice of the array of cities, under the umbrella of 	a read-lock
-	slice := func(marker string) []proto.MapName {
-		out := make([]proto.MapName, 0)
+	// Extract stats on a slice of the array of maps, under the umbrella of a read-lock
+	slice := func(marker string) []*proto.MapName {
+		out := make([]*proto.MapName, 0)
 		s.rw.RLock()
 		defer s.rw.RUnlock()
+		if req.Name != "" {
+			// Only one map is expected, the second slice is empty
+			if m := s.maps.Get(req.Name); m != nil && marker == "" {
+				out = append(out, describeMap(m))
+			}
+			return out
+		}
 		for _, m := range s.maps.Slice(marker, 100) {
-			out = append(out, proto.MapName{
-				Name:          m.ID,
-				CountEdges:    uint32(len(m.Roads)),
-				CountVertices: uint32(len(m.Cells)),
-				CountCities: func() (total uint32) {
-					for _, c := range m.Cells {
-						if c.City != "" {
-							total++
-						}
-					}
-					return total
-				}(),
-			})
+			out = append(out, describeMap(m))
 		}
 		return out
 	}
 
 	next := req.Marker
+	if req.Name != "" {
+		next = ""
+	}
 	for {
 		names := slice(next)
 		if len(names) <= 0 {
 			return nil
 		}
 		for _, v := range names {
-			err := stream.Send(&v)
+			err := stream.Send(v)
 			if err == io.EOF {
 				return nil
 			}
@@ -195,40 +256,22 @@ func (s *srvMap) Maps(req *proto.ListMapsReq, stream proto.Map_MapsServer) error
 	}
 }
 
-// LoadDirectory loads all the maps stored as files, containing JSON objects desribing maps.
-// Only the filenames with a .final.json suffix are considered.
-func (s *srvMap) LoadDirectory(path string) error {
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return errors.Trace(err)
-		}
-
-		// Only accept non-hidden JSON files
-		_, fn := filepath.Split(path)
-		if info.IsDir() || info.Size() <= 0 {
-			return nil
-		}
-		if len(fn) < 2 || fn[0] == '.' {
-			return nil
-		}
-		if !strings.HasSuffix(fn, ".final.json") {
-			return nil
-		}
-
-		var f *os.File
-		f, err = os.Open(path)
-		if err != nil {
-			return errors.NewNotValid(err, "fs error")
-		}
-		defer f.Close()
-
-		m := mapgraph.NewMap()
-		if err = m.Load(f); err != nil {
-			return errors.NewNotValid(err, "format error")
-		}
-		s.maps.Add(m)
-		return nil
-	})
+func describeMap(m *mapgraph.Map) *proto.MapName {
+	return &proto.MapName{
+		Name:          m.ID,
+		CountEdges:    uint32(len(m.Roads)),
+		CountVertices: uint32(len(m.Cells)),
+		CountCities: func() (total uint32) {
+			for _, c := range m.Cells {
+				if c.City != "" {
+					total++
+				}
+			}
+			return total
+		}(),
+		Version: m.Version,
+		Hash:    m.Hash(),
+	}
 }
 
 func (s *srvMap) _lock(mode rune, action func() error) error {
@@ -254,3 +297,25 @@ func (s *srvMap) _get(mode rune, name string, action func(*mapgraph.Map) error)
 		return action(m)
 	})
 }
+
+// M2P -> Model to Proto
+func vertexM2P(v *mapgraph.Vertex) *proto.Vertex {
+	out := &proto.Vertex{Id: v.ID, X: v.X, Y: v.Y, Terrain: string(v.Terrain)}
+	if v.Bonus != nil {
+		out.Mult, out.Plus = v.Bonus.Mult, v.Bonus.Plus
+	}
+	return out
+}
+
+// P2M -> Proto to Model
+func vertexP2M(v *proto.Vertex, city string) mapgraph.Vertex {
+	out := mapgraph.Vertex{ID: v.Id, X: v.X, Y: v.Y, City: city, Terrain: mapgraph.Terrain(v.Terrain)}
+	if len(v.Mult) > 0 || len(v.Plus) > 0 {
+		out.Bonus = &mapgraph.Bonus{Mult: v.Mult, Plus: v.Plus}
+	}
+	return out
+}
+
+func edgeP2M(r *proto.Edge) mapgraph.Edge {
+	return mapgraph.Edge{S: r.Src, D: r.Dst, W: r.Weight, Terrain: mapgraph.Terrain(r.Terrain), Cost: r.Cost}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapagent
+
+import (
+	"context"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/jfsmig/hegemonie/pkg/map/proto"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileStamp identifies a version of a map file, so that unchanged files are
+// not loaded again.
+type fileStamp struct {
+	size  int64
+	mtime time.Time
+}
+
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{size: info.Size(), mtime: info.ModTime()}
+}
+
+// reloadReport tells what happened to each map file during a reload.
+type reloadReport struct {
+	// Loaded lists the files new or changed, successfully loaded
+	Loaded []string
+	// Unchanged lists the files not loaded because unchanged since the last load
+	Unchanged []string
+	// Failed lists the files that failed to load. The previous version of
+	// their map, if any, is still served.
+	Failed []string
+}
+
+// loadedFile is the outcome of the load of one map file
+type loadedFile struct {
+	path  string
+	stamp fileStamp
+	m     *mapgraph.Map
+	err   error
+}
+
+// scanDirectory lists all the map files of the repository, and loads those
+// that changed since the given stamps. Only the non-hidden files with a
+// .final.json suffix are considered.
+func scanDirectory(path string, known map[string]fileStamp) ([]loadedFile, error) {
+	out := make([]loadedFile, 0)
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		// Only accept non-hidden JSON files
+		_, fn := filepath.Split(path)
+		if info.IsDir() || info.Size() <= 0 {
+			return nil
+		}
+		if len(fn) < 2 || fn[0] == '.' {
+			return nil
+		}
+		if !strings.HasSuffix(fn, ".final.json") {
+			return nil
+		}
+
+		lf := loadedFile{path: path, stamp: stampOf(info)}
+		if stamp, ok := known[path]; !ok || stamp != lf.stamp {
+			lf.m, lf.err = loadFile(path)
+		}
+		out = append(out, lf)
+		return nil
+	})
+	return out, err
+}
+
+func loadFile(path string) (*mapgraph.Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "fs error")
+	}
+	defer f.Close()
+
+	m := mapgraph.NewMap()
+	if err = m.Load(f); err != nil {
+		return nil, errors.NewNotValid(err, "format error")
+	}
+	return m, nil
+}
+
+// load loads the new and changed map files of the repository into a fresh
+// SetOfMaps, then atomically replaces the maps served.
+// The files are loaded and validated without lock, only the swap happens
+// under the write lock. A map whose file fails to load keeps being served in
+// its previous version, and so does a map whose file disappeared.
+func (s *srvMap) load() (reloadReport, error) {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	known, files, err := s.scan()
+	if err != nil {
+		return reloadReport{}, errors.Trace(err)
+	}
+	return s.swap(known, files), nil
+}
+
+// scan loads the files changed since their last load, under no lock. It
+// returns the versions of the files known before the scan.
+func (s *srvMap) scan() (map[string]fileStamp, []loadedFile, error) {
+	s.rw.RLock()
+	known := make(map[string]fileStamp, len(s.files))
+	for k, v := range s.files {
+		known[k] = v
+	}
+	s.rw.RUnlock()
+
+	files, err := scanDirectory(s.config.PathRepository, known)
+	return known, files, err
+}
+
+// swap replaces the maps served by the maps loaded by a scan, under the write
+// lock. The files altered since the scan keep their map as it is in memory.
+func (s *srvMap) swap(known map[string]fileStamp, files []loadedFile) reloadReport {
+	var report reloadReport
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	fresh := make(mapgraph.SetOfMaps, 0, len(s.maps))
+	paths := make(map[string]string)
+	byPath := make(map[string]*mapgraph.Map)
+	for _, m := range s.maps {
+		byPath[s.paths[m.ID]] = m
+	}
+	add := func(m *mapgraph.Map, path string) error {
+		if other, ok := paths[m.ID]; ok && other != path {
+			return errors.AlreadyExistsf("map %s in %s", m.ID, other)
+		}
+		fresh.Add(m)
+		paths[m.ID] = path
+		return nil
+	}
+
+	for _, lf := range files {
+		current := byPath[lf.path]
+		delete(byPath, lf.path)
+
+		switch {
+		case s.files[lf.path] != known[lf.path]:
+			// The map has been edited since the scan, the content in
+			// memory is more recent than the content loaded.
+			report.Unchanged = append(report.Unchanged, lf.path)
+			lf.m, lf.err = nil, nil
+		case lf.m == nil && lf.err == nil:
+			report.Unchanged = append(report.Unchanged, lf.path)
+		case lf.err == nil:
+			if err := add(lf.m, lf.path); err != nil {
+				lf.err = err
+			} else {
+				report.Loaded = append(report.Loaded, lf.path)
+				s.files[lf.path] = lf.stamp
+				continue
+			}
+		}
+
+		if lf.err != nil {
+			utils.Logger.Warn().Err(lf.err).Str("path", lf.path).Msg("map load error")
+			report.Failed = append(report.Failed, lf.path)
+			// Don't retry the same file until it changes
+			s.files[lf.path] = lf.stamp
+		}
+		if current != nil {
+			if err := add(current, lf.path); err != nil {
+				utils.Logger.Warn().Err(err).Str("path", lf.path).Msg("map dropped")
+			}
+		}
+	}
+
+	// The maps whose file disappeared are still served
+	for path, m := range byPath {
+		utils.Logger.Warn().Str("path", path).Str("map", m.ID).Msg("map file missing")
+		if err := add(m, path); err != nil {
+			utils.Logger.Warn().Err(err).Str("path", path).Msg("map dropped")
+		}
+	}
+
+	sort.Sort(&fresh)
+	s.maps, s.paths = fresh, paths
+	if len(report.Loaded) > 0 || len(report.Failed) > 0 {
+		utils.Logger.Info().
+			Int("loaded", len(report.Loaded)).
+			Int("unchanged", len(report.Unchanged)).
+			Int("failed", len(report.Failed)).
+			Int("maps", s.maps.Len()).
+			Msg("reload")
+	}
+	return report
+}
+
+// runReloads periodically reloads the repository until the context is done.
+func (s *srvMap) runReloads(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.load(); err != nil {
+				utils.Logger.Warn().Err(err).Msg("reload error")
+			}
+		}
+	}
+}
+
+// Reload reloads the map repository, e.g. upon a SIGHUP
+func (s *srvMap) Reload(ctx context.Context) error {
+	_, err := s.load()
+	return err
+}
+
+// ReloadMaps reloads the map repository and reports the outcome for each file
+func (s *srvMap) ReloadMaps(ctx context.Context, req *proto.ReloadMapsReq) (*proto.ReloadMapsRep, error) {
+	report, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return &proto.ReloadMapsRep{
+		Loaded:    report.Loaded,
+		Unchanged: report.Unchanged,
+		Failed:    report.Failed,
+	}, nil
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapagent
+
+import (
+	"context"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/jfsmig/hegemonie/pkg/map/proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRepository is a directory of map files whose versions are told apart
+// by their modification time, whatever the resolution of the filesystem.
+type testRepository struct {
+	t     *testing.T
+	dir   string
+	mtime time.Time
+}
+
+func newTestRepository(t *testing.T) *testRepository {
+	return &testRepository{t: t, dir: t.TempDir(), mtime: time.Now().Add(-time.Hour)}
+}
+
+func (r *testRepository) path(name string) string { return filepath.Join(r.dir, name) }
+
+func (r *testRepository) write(name, content string) {
+	r.t.Helper()
+	path := r.path(name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.mtime = r.mtime.Add(time.Second)
+	if err := os.Chtimes(path, r.mtime, r.mtime); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// writeMap writes a valid map with a single city
+func (r *testRepository) writeMap(name, id, city string) {
+	r.t.Helper()
+	r.write(name, fmt.Sprintf(`{"id":%q,
+		"sites":[{"id":1,"x":0,"y":0,"city":%q},{"id":2,"x":10,"y":0}],
+		"roads":[{"src":1,"dst":2},{"src":2,"dst":1}]}`, id, city))
+}
+
+func (r *testRepository) service() *srvMap {
+	return &srvMap{
+		config: Config{PathRepository: r.dir},
+		maps:   make(mapgraph.SetOfMaps, 0),
+		paths:  make(map[string]string),
+		files:  make(map[string]fileStamp),
+	}
+}
+
+func reloadOf(t *testing.T, s *srvMap) reloadReport {
+	t.Helper()
+	report, err := s.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func checkReport(t *testing.T, report reloadReport, loaded, unchanged, failed []string) {
+	t.Helper()
+	for _, tc := range []struct {
+		name          string
+		got, expected []string
+	}{
+		{"loaded", report.Loaded, loaded},
+		{"unchanged", report.Unchanged, unchanged},
+		{"failed", report.Failed, failed},
+	} {
+		if len(tc.got) != len(tc.expected) || (len(tc.got) > 0 && !reflect.DeepEqual(tc.got, tc.expected)) {
+			t.Fatal(tc.name, tc.got, "expected", tc.expected)
+		}
+	}
+}
+
+// checkCity checks the name of the city of the map served
+func checkCity(t *testing.T, s *srvMap, id, city string) {
+	t.Helper()
+	m := s.maps.Get(id)
+	if m == nil {
+		t.Fatal("map", id, "not served")
+	}
+	if got := m.CellGet(1).City; got != city {
+		t.Fatal("map", id, "city", got, "expected", city)
+	}
+}
+
+func TestReload(t *testing.T) {
+	repo := newTestRepository(t)
+	a, b, c := repo.path("a.final.json"), repo.path("b.final.json"), repo.path("c.final.json")
+	repo.writeMap("a.final.json", "a", "Aster")
+	repo.writeMap("b.final.json", "b", "Borage")
+	// Ignored files
+	repo.writeMap(".hidden.final.json", "hidden", "Hidden")
+	repo.writeMap("seed.json", "seed", "Seed")
+
+	s := repo.service()
+	checkReport(t, reloadOf(t, s), []string{a, b}, nil, nil)
+	if s.maps.Len() != 2 {
+		t.Fatal("maps", s.maps.Len())
+	}
+	checkReport(t, reloadOf(t, s), nil, []string{a, b}, nil)
+
+	// A changed file is swapped in
+	repo.writeMap("a.final.json", "a", "Anise")
+	checkReport(t, reloadOf(t, s), []string{a}, []string{b}, nil)
+	checkCity(t, s, "a", "Anise")
+
+	// A file that fails to load keeps the previous map, and isn't retried
+	// until it changes.
+	repo.write("a.final.json", `{"id":"a",`)
+	checkReport(t, reloadOf(t, s), nil, []string{b}, []string{a})
+	checkCity(t, s, "a", "Anise")
+	checkReport(t, reloadOf(t, s), nil, []string{a, b}, nil)
+	checkCity(t, s, "a", "Anise")
+
+	// A deleted file keeps its map served
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	checkReport(t, reloadOf(t, s), nil, []string{a}, nil)
+	checkCity(t, s, "b", "Borage")
+
+	// A file with the ID of the map of another file is rejected
+	repo.writeMap("c.final.json", "a", "Cumin")
+	checkReport(t, reloadOf(t, s), nil, []string{a}, []string{c})
+	checkCity(t, s, "a", "Anise")
+	if s.paths["a"] != a || s.maps.Len() != 2 {
+		t.Fatal("paths", s.paths)
+	}
+}
+
+// TestReloadEdited checks that a map edited through the Admin service
+// between the scan of its file and the swap keeps its edition.
+func TestReloadEdited(t *testing.T) {
+	repo := newTestRepository(t)
+	a := repo.path("a.final.json")
+	repo.writeMap("a.final.json", "a", "Aster")
+	s := repo.service()
+	checkReport(t, reloadOf(t, s), []string{a}, nil, nil)
+
+	repo.writeMap("a.final.json", "a", "Anise")
+	known, files, err := s.scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].m == nil {
+		t.Fatal("file not loaded", files)
+	}
+	if _, err = s.RenameCity(context.Background(), &proto.RenameCityReq{MapName: "a", Id: 1, City: "Dill"}); err != nil {
+		t.Fatal(err)
+	}
+	checkReport(t, s.swap(known, files), nil, []string{a}, nil)
+	checkCity(t, s, "a", "Dill")
+
+	// The file saved by the edition isn't loaded again
+	checkReport(t, reloadOf(t, s), nil, []string{a}, nil)
+	checkCity(t, s, "a", "Dill")
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"context"
+	"github.com/jfsmig/hegemonie/pkg/map/proto"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"google.golang.org/grpc"
+)
+
+// EditArgs gathers the arguments of the alterations of a map.
+// The vertex of the alteration (or the source of the road) is PathArgs.Src,
+// the destination of the road is PathArgs.Dst.
+type EditArgs struct {
+	PathArgs
+	X, Y   uint64
+	City   string
+	Weight uint64
+	// Terrain of the new vertex or of the new road
+	Terrain string
+	// Cost is the explicit multiplier of the length of the new road
+	Cost float64
+	// Links are the peers of the new vertex, connected with roads in both directions
+	Links []uint
+}
+
+// AddVertex inserts a new vertex in the map, and dumps the new version of the map to os.Stdout
+func (c *ClientCLI) AddVertex(ctx context.Context, args EditArgs) error {
+	req := proto.AddVertexReq{
+		MapName: args.MapName,
+		Vertex:  &proto.Vertex{Id: args.Src, X: args.X, Y: args.Y, Terrain: args.Terrain},
+		City:    args.City,
+	}
+	for _, link := range args.Links {
+		peer := uint64(link)
+		req.Roads = append(req.Roads,
+			&proto.Edge{Src: args.Src, Dst: peer},
+			&proto.Edge{Src: peer, Dst: args.Src})
+	}
+	return c.edit(ctx, func(ctx context.Context, cli proto.AdminClient) (*proto.MapVersion, error) {
+		return cli.AddVertex(ctx, &req)
+	})
+}
+
+// RemoveVertex deletes a vertex and its roads, and dumps the new version of the map to os.Stdout
+func (c *ClientCLI) RemoveVertex(ctx context.Context, args EditArgs) error {
+	return c.edit(ctx, func(ctx context.Context, cli proto.AdminClient) (*proto.MapVersion, error) {
+		return cli.RemoveVertex(ctx, &proto.VertexReq{MapName: args.MapName, Id: args.Src})
+	})
+}
+
+// AddRoad inserts a road, and dumps the new version of the map to os.Stdout
+func (c *ClientCLI) AddRoad(ctx context.Context, args EditArgs) error {
+	return c.edit(ctx, func(ctx context.Context, cli proto.AdminClient) (*proto.MapVersion, error) {
+		return cli.AddRoad(ctx, &proto.RoadReq{
+			MapName: args.MapName,
+			Road: &proto.Edge{Src: args.Src, Dst: args.Dst, Weight: args.Weight,
+				Terrain: args.Terrain, Cost: args.Cost},
+		})
+	})
+}
+
+// RemoveRoad deletes a road, and dumps the new version of the map to os.Stdout
+func (c *ClientCLI) RemoveRoad(ctx context.Context, args EditArgs) error {
+	return c.edit(ctx, func(ctx context.Context, cli proto.AdminClient) (*proto.MapVersion, error) {
+		return cli.RemoveRoad(ctx, &proto.RoadReq{
+			MapName: args.MapName,
+			Road:    &proto.Edge{Src: args.Src, Dst: args.Dst},
+		})
+	})
+}
+
+// RenameCity sets the name of the city of a vertex, and dumps the new version of the map to os.Stdout
+func (c *ClientCLI) RenameCity(ctx context.Context, args EditArgs) error {
+	return c.edit(ctx, func(ctx context.Context, cli proto.AdminClient) (*proto.MapVersion, error) {
+		return cli.RenameCity(ctx, &proto.RenameCityReq{MapName: args.MapName, Id: args.Src, City: args.City})
+	})
+}
+
+// ReloadMaps triggers a reload of the map repository, and dumps the outcome to os.Stdout
+func (c *ClientCLI) ReloadMaps(ctx context.Context) error {
+	return c.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := proto.NewAdminClient(cnx).ReloadMaps(ctx, &proto.ReloadMapsReq{})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.DumpJSON(rep)
+	})
+}
+
+func (c *ClientCLI) edit(ctx context.Context, action func(context.Context, proto.AdminClient) (*proto.MapVersion, error)) error {
+	return c.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := action(ctx, proto.NewAdminClient(cnx))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.DumpJSON(rep)
+	})
+}
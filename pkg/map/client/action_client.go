@@ -91,6 +91,44 @@ func (c *ClientCLI) GetStep(ctx context.Context, args PathArgs) error {
 	return c.getPath(ctx, args)
 }
 
+// NeighborhoodArgs gathers the arguments of a neighborhood query. The center
+// is PathArgs.Src and the marker is PathArgs.Dst.
+type NeighborhoodArgs struct {
+	PathArgs
+	MaxHops     uint32
+	MaxDistance uint64
+}
+
+// GetNeighborhood produces to os.Stdout a JSON array of <id,hops,distance> tuples,
+// with one tuple for each vertex around the given vertex.
+func (c *ClientCLI) GetNeighborhood(ctx context.Context, args NeighborhoodArgs) error {
+	return c.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := proto.NewMapClient(cnx).Neighborhood(ctx, &proto.NeighborhoodReq{
+			MapName:     args.MapName,
+			Id:          args.Src,
+			MaxHops:     args.MaxHops,
+			MaxDistance: args.MaxDistance,
+			Marker:      args.Dst,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.EncodeWhole(func() (interface{}, error) { return rep.Recv() })
+	})
+}
+
+// GetDistance produces to os.Stdout a JSON object with the length and the
+// number of roads of the shortest path from the given source to the given destination.
+func (c *ClientCLI) GetDistance(ctx context.Context, args PathArgs) error {
+	return c.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := proto.NewMapClient(cnx).Distance(ctx, &proto.DistanceReq{MapName: args.MapName, Src: args.Src, Dst: args.Dst})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.DumpJSON(rep)
+	})
+}
+
 func (c *ClientCLI) getPath(ctx context.Context, args PathArgs) error {
 	return c.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
 		rep, err := proto.NewMapClient(cnx).GetPath(ctx, &proto.PathRequest{MapName: args.MapName, Src: args.Src, Dst: args.Dst})
@@ -7,10 +7,12 @@ package mapclient
 
 import (
 	"encoding/json"
-	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
 	"github.com/jfsmig/hegemonie/pkg/utils"
 	"github.com/juju/errors"
+	"math/rand"
 	"os"
+	"sort"
 )
 
 func loadAndDo(action func(m MapRaw) error) error {
@@ -30,10 +32,7 @@ func ToolNoise(noise float64) error {
 		if err != nil {
 			return errors.Trace(err)
 		}
-		xmin, xmax, ymin, ymax := m.computeBox()
-		if noise > 0 {
-			m.applyNoiseOnPositions(float64(xmax-xmin)*(noise/100), float64(ymax-ymin)*(noise/100))
-		}
+		m.applyNoise(rand.New(rand.NewSource(rand.Int63())), noise)
 		return utils.DumpJSON(m.extractRawMap())
 	})
 }
@@ -53,17 +52,47 @@ func ToolSplit(maxDist float64) error {
 	})
 }
 
+// CanvasArgs describes the box the positions of a map are fit in
+type CanvasArgs struct {
+	Width, Height uint64
+	// Padding is the margin kept empty on each side of the box
+	Padding uint64
+	// Stretch fills the whole box, without preserving the aspect ratio of the map
+	Stretch bool
+}
+
+func (c CanvasArgs) validate() error {
+	if 2*c.Padding >= c.Width || 2*c.Padding >= c.Height {
+		return errors.NotValidf("padding %v too large for a %vx%v box", c.Padding, c.Width, c.Height)
+	}
+	return nil
+}
+
+// apply remaps the positions of the sites so that the map fits in the box
+func (c CanvasArgs) apply(m *mapMem) {
+	w, h := c.Width-2*c.Padding, c.Height-2*c.Padding
+	if c.Stretch {
+		m.resizeStretch(float64(w), float64(h))
+		m.shift(c.Padding, c.Padding)
+	} else {
+		m.resizeAndAdjust(w, h)
+		m.SiftToTheCenter(c.Width, c.Height)
+	}
+}
+
 // ToolNormalize consumes a MapRaw on os.Stdin, parses it a remap each node position
-// (keeping the same aspect ratio to fit the whole map into a given bounded box.
-func ToolNormalize() error {
+// to fit the whole map into a given bounded box, keeping the same aspect ratio unless
+// told to stretch the map.
+func ToolNormalize(args CanvasArgs) error {
+	if err := args.validate(); err != nil {
+		return errors.Trace(err)
+	}
 	return loadAndDo(func(raw MapRaw) error {
 		m, err := raw.extractMemMap()
 		if err != nil {
 			return errors.Trace(err)
 		}
-		var xbound, ybound, xPad, yPad uint64 = 1920, 1080, 50, 50
-		m.resizeAndAdjust(xbound-2*xPad, ybound-2*yPad)
-		m.SiftToTheCenter(xbound, ybound)
+		args.apply(&m)
 		return utils.DumpJSON(m.extractRawMap())
 	})
 }
@@ -77,59 +106,16 @@ func ToolDot() error {
 			return errors.Trace(err)
 		}
 
-		fmt.Println("graph g {")
-		for r := range m.uniqueRoads() {
-			fmt.Printf("%s -- %s;\n", r.Src.getDotName(), r.Dst.getDotName())
-		}
-		fmt.Println("}")
+		m.writeDot(os.Stdout)
 		return nil
 	})
 }
 
 // ToolSvg consumes a MapRaw on os.Stdin, parses it and dumps a SVG representation
 // of it to os.Stdout.
-func ToolSvg() error {
+func ToolSvg(args SvgArgs) error {
 	return loadAndDo(func(raw MapRaw) error {
-		m, err := raw.extractMemMap()
-		if err != nil {
-			return errors.Trace(err)
-		}
-
-		var xbound, ybound, xPad, yPad uint64 = 1920, 1080, 50, 50
-		m.resizeAndAdjust(xbound-2*xPad, ybound-2*yPad)
-		m.SiftToTheCenter(xbound, ybound)
-
-		fmt.Printf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
-<svg xmlns="http://www.w3.org/2000/svg"
-	style="background-color: rgb(255, 255, 255);"
-	xmlns:xlink="http://www.w3.org/1999/xlink"
-	version="1.1"
-	width="%dpx" height="%dpx"
-	viewBox="-0.5 -0.5 %d %d">
-`, int64(xbound), int64(ybound), int64(xbound), int64(ybound))
-		fmt.Println(`<g>`)
-		for r := range m.uniqueRoads() {
-			fmt.Printf(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black" stroke-width="1"/>
-`, int64(r.Src.Raw.X), int64(r.Src.Raw.Y), int64(r.Dst.Raw.X), int64(r.Dst.Raw.Y))
-		}
-		fmt.Println(`</g>`)
-		fmt.Println(`<g>`)
-		for s := range m.sortedSites() {
-			color := `white`
-			radius := 5
-			stroke := 1
-			if s.Raw.City != "" {
-				color = `gray`
-				radius = 10
-				stroke = 1
-			}
-			fmt.Printf(`<circle id="%v" class="clickable" cx="%d" cy="%d" r="%d" stroke="black" stroke-width="%d" fill="%s"/>
-`, s.Raw.ID, int64(s.Raw.X), int64(s.Raw.Y), radius, stroke, color)
-		}
-		fmt.Println(`</g>`)
-		fmt.Println(`</svg>`)
-		return nil
+		return RenderSvg(os.Stdout, raw, args, SvgDecoration{})
 	})
 }
 
@@ -137,15 +123,114 @@ func ToolSvg() error {
 // and then dumps that MapRaw to os.Stdout.
 func ToolInit() error {
 	var in MapSeed
-	var out MapRaw
-	err := json.NewDecoder(os.Stdin).Decode(&in)
+	if err := in.load(os.Stdin); err != nil {
+		return errors.Trace(err)
+	}
+	out, err := in.extractRawMap()
 	if err != nil {
-		return errors.NewNotValid(err, "invalid json")
+		return errors.Trace(err)
 	}
-	out, err = in.extractRawMap()
+	return utils.DumpJSON(out)
+}
+
+// ToolGenerate procedurally builds a MapRaw after the given parameters, then
+// dumps it to os.Stdout.
+func ToolGenerate(args GenerateArgs) error {
+	m, err := generateMemMap(args)
 	if err != nil {
 		return errors.Trace(err)
+	}
+	return utils.DumpJSON(m.extractRawMap())
+}
 
+// ToolFromGeoJSON consumes a GeoJSON FeatureCollection on os.Stdin, converts it
+// into a MapRaw with the given projection, then dumps that MapRaw to os.Stdout.
+// A non-empty id overrides the name of the collection.
+func ToolFromGeoJSON(id string, proj Projection) error {
+	var in geoCollection
+	decoder := json.NewDecoder(os.Stdin)
+	decoder.UseNumber()
+	if err := decoder.Decode(&in); err != nil {
+		return errors.NewNotValid(err, "invalid json")
+	}
+	out, err := importGeoJSON(in, proj)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if id != "" {
+		out.ID = id
 	}
 	return utils.DumpJSON(out)
 }
+
+// ToolToGeoJSON consumes a MapRaw on os.Stdin, converts it into a GeoJSON
+// FeatureCollection with the given projection, then dumps it to os.Stdout.
+func ToolToGeoJSON(proj Projection) error {
+	return loadAndDo(func(raw MapRaw) error {
+		out, err := exportGeoJSON(raw, proj)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.DumpJSON(out)
+	})
+}
+
+// ToolCheck consumes a MapRaw on os.Stdin and dumps to os.Stdout the report
+// of its defects. With repair, the invalid roads are dropped, the roads required
+// to make the map strongly connected are added, then the repaired MapRaw is
+// dumped to os.Stdout instead of the report.
+func ToolCheck(repair bool) error {
+	return loadAndDo(func(raw MapRaw) error {
+		report, clean := raw.check()
+		if !repair {
+			if err := utils.DumpJSON(report); err != nil {
+				return err
+			}
+			if !report.Valid() {
+				return errors.NotValidf("map %s", raw.ID)
+			}
+			return nil
+		}
+
+		report.Added = clean.repair()
+		for _, r := range report.Added {
+			utils.Logger.Info().Uint64("src", r.Src).Uint64("dst", r.Dst).Msg("road added")
+		}
+		sort.Slice(clean.Roads, func(i, j int) bool {
+			ri, rj := clean.Roads[i], clean.Roads[j]
+			return ri.Src < rj.Src || (ri.Src == rj.Src && ri.Dst < rj.Dst)
+		})
+		return utils.DumpJSON(clean)
+	})
+}
+
+// ToolFairness consumes a map on os.Stdin, validates it as the map service
+// would, then dumps to os.Stdout the comparison of its cities.
+func ToolFairness(args FairnessArgs) error {
+	m := mapgraph.NewMap()
+	if err := m.Load(os.Stdin); err != nil {
+		return errors.Trace(err)
+	}
+	report, err := fairness(m, args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if n := report.Outliers(); n > 0 {
+		utils.Logger.Warn().Int("cities", n).Msg("outliers")
+	}
+	return utils.DumpJSON(report)
+}
+
+// ToolDiff loads the two versions of a MapRaw at the given paths and dumps
+// to os.Stdout the report of their differences.
+func ToolDiff(pathOld, pathNew string) error {
+	old, err := LoadRawMap(pathOld)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	new, err := LoadRawMap(pathNew)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return utils.DumpJSON(DiffMaps(old, new))
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"testing"
+)
+
+func canvasMap(t *testing.T, positions ...[2]uint64) mapMem {
+	t.Helper()
+	raw := makeRawMap()
+	raw.ID = "canvas"
+	for i, p := range positions {
+		raw.Sites = append(raw.Sites, SiteRaw{ID: uint64(i + 1), X: p[0], Y: p[1]})
+	}
+	m, err := raw.extractMemMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCanvasValidate(t *testing.T) {
+	for _, c := range []CanvasArgs{
+		{Width: 100, Height: 100, Padding: 50},
+		{Width: 100, Height: 40, Padding: 20},
+		{Width: 0, Height: 100},
+	} {
+		if err := c.validate(); err == nil {
+			t.Fatal("unexpected success with", c)
+		}
+	}
+	if err := (CanvasArgs{Width: 100, Height: 100, Padding: 49}).validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCanvasApply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		sites [][2]uint64
+		args  CanvasArgs
+		// box is the expected extent of the map on the canvas
+		box [4]uint64
+	}{
+		// The ratio 2:1 of the map is kept, the map is centered on the
+		// shortest axis.
+		{"fit", [][2]uint64{{10, 10}, {110, 60}, {50, 30}},
+			CanvasArgs{Width: 400, Height: 300, Padding: 20},
+			[4]uint64{20, 380, 60, 240}},
+		{"stretch", [][2]uint64{{10, 10}, {110, 60}, {50, 30}},
+			CanvasArgs{Width: 400, Height: 300, Padding: 20, Stretch: true},
+			[4]uint64{20, 380, 20, 280}},
+		// A map without width is centered on X when fit, and only moved
+		// to the padding when stretched.
+		{"fit one X", [][2]uint64{{50, 0}, {50, 100}, {50, 40}},
+			CanvasArgs{Width: 400, Height: 300, Padding: 20},
+			[4]uint64{200, 200, 20, 280}},
+		{"stretch one X", [][2]uint64{{50, 0}, {50, 100}, {50, 40}},
+			CanvasArgs{Width: 400, Height: 300, Padding: 20, Stretch: true},
+			[4]uint64{20, 20, 20, 280}},
+		{"fit one position", [][2]uint64{{7, 7}, {7, 7}},
+			CanvasArgs{Width: 400, Height: 300, Padding: 20},
+			[4]uint64{200, 200, 150, 150}},
+	} {
+		m := canvasMap(t, tc.sites...)
+		tc.args.apply(&m)
+		xmin, xmax, ymin, ymax := m.computeBox()
+		if got := [4]uint64{xmin, xmax, ymin, ymax}; got != tc.box {
+			t.Fatal(tc.name, "box", got, "expected", tc.box)
+		}
+		for _, s := range m.Sites {
+			if s.Raw.X < tc.args.Padding || s.Raw.X > tc.args.Width-tc.args.Padding ||
+				s.Raw.Y < tc.args.Padding || s.Raw.Y > tc.args.Height-tc.args.Padding {
+				t.Fatal(tc.name, "site", s.Raw.ID, "out of the canvas at", s.Raw.X, s.Raw.Y)
+			}
+		}
+	}
+}
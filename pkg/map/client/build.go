@@ -0,0 +1,223 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v3"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// SplitStep splits the roads longer than Dist
+type SplitStep struct {
+	Dist float64 `yaml:"dist"`
+}
+
+// NoiseStep moves the sites without city by up to Noise percent of the
+// dimensions of the map.
+type NoiseStep struct {
+	Noise float64 `yaml:"noise"`
+	// Seed gives the step its own rng, instead of the rng of the pipeline
+	Seed *int64 `yaml:"seed"`
+}
+
+// BuildStep is a step of a BuildSpec. Exactly one of the fields is set.
+type BuildStep struct {
+	Split     *SplitStep  `yaml:"split"`
+	Noise     *NoiseStep  `yaml:"noise"`
+	Normalize *CanvasArgs `yaml:"normalize"`
+}
+
+// BuildSpec is the pipeline of transformations that produces a final map
+// from a seed, e.g.
+//
+//	seed: 42
+//	steps:
+//	  - split: {dist: 60}
+//	  - noise: {noise: 15}
+//	  - normalize: {width: 1920, height: 1080, padding: 50}
+type BuildSpec struct {
+	// Seed initializes the rng shared by the noise steps, so that the build
+	// of a map is reproducible.
+	Seed  int64       `yaml:"seed"`
+	Steps []BuildStep `yaml:"steps"`
+}
+
+// BuildArgs tells where to find the inputs of a build and where to write its
+// outputs.
+type BuildArgs struct {
+	PathSeed string
+	PathSpec string
+	// PathOutput is the path of the final map. When empty, the path of the
+	// seed with the ".seed.json" suffix replaced by ".final.json".
+	PathOutput string
+	// PathSvg and PathDot are the paths of the optional previews
+	PathSvg string
+	PathDot string
+	Svg     SvgArgs
+}
+
+func (s *BuildStep) validate() error {
+	count := 0
+	if s.Split != nil {
+		count++
+		if s.Split.Dist <= 0 {
+			return errors.NotValidf("split dist %v", s.Split.Dist)
+		}
+	}
+	if s.Noise != nil {
+		count++
+		if s.Noise.Noise < 0 || s.Noise.Noise > 100 {
+			return errors.NotValidf("noise %v", s.Noise.Noise)
+		}
+	}
+	if s.Normalize != nil {
+		count++
+		if err := s.Normalize.validate(); err != nil {
+			return errors.Annotate(err, "normalize")
+		}
+	}
+	if count != 1 {
+		return errors.NotValidf("%d operations in the step", count)
+	}
+	return nil
+}
+
+func (spec *BuildSpec) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(spec); err != nil {
+		return errors.NewNotValid(err, "malformed pipeline")
+	}
+	for i := range spec.Steps {
+		if err = spec.Steps[i].validate(); err != nil {
+			return errors.Annotatef(err, "step #%d", i)
+		}
+	}
+	return nil
+}
+
+// run applies the steps in their order
+func (spec *BuildSpec) run(m mapMem) mapMem {
+	rng := rand.New(rand.NewSource(spec.Seed))
+	for i, step := range spec.Steps {
+		switch {
+		case step.Split != nil:
+			m = m.splitLongRoads(step.Split.Dist)
+		case step.Noise != nil:
+			r := rng
+			if step.Noise.Seed != nil {
+				r = rand.New(rand.NewSource(*step.Noise.Seed))
+			}
+			m.applyNoise(r, step.Noise.Noise)
+		case step.Normalize != nil:
+			step.Normalize.apply(&m)
+		}
+		utils.Logger.Debug().Int("step", i).Int("sites", len(m.Sites)).Msg("built")
+	}
+	return m
+}
+
+// ToolBuild produces a final map from a seed, through the pipeline of steps
+// of a spec, all in memory. The final map is checked as the map service
+// would, before being written along with its optional previews.
+func ToolBuild(args BuildArgs) error {
+	var spec BuildSpec
+	if err := spec.load(args.PathSpec); err != nil {
+		return errors.Trace(err)
+	}
+	if args.PathSvg != "" {
+		if err := args.Svg.validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if args.PathOutput == "" {
+		if !strings.HasSuffix(args.PathSeed, ".seed.json") {
+			return errors.NotValidf("no output path for seed %s", args.PathSeed)
+		}
+		args.PathOutput = strings.TrimSuffix(args.PathSeed, ".seed.json") + ".final.json"
+	}
+
+	var seed MapSeed
+	f, err := os.Open(args.PathSeed)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = seed.load(f)
+	f.Close()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	raw, err := seed.extractRawMap()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	m, err := raw.extractMemMap()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	m = spec.run(m)
+	final := m.extractRawMap()
+
+	encoded := bytes.Buffer{}
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetIndent("", " ")
+	if err = encoder.Encode(final); err != nil {
+		return errors.Trace(err)
+	}
+	if err = mapgraph.NewMap().Load(bytes.NewReader(encoded.Bytes())); err != nil {
+		return errors.Annotate(err, "invalid final map")
+	}
+
+	if err = writeFile(args.PathOutput, func(out *os.File) error {
+		_, err := out.Write(encoded.Bytes())
+		return err
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	if args.PathSvg != "" {
+		if err = writeFile(args.PathSvg, func(out *os.File) error {
+			return RenderSvg(out, final, args.Svg, SvgDecoration{})
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if args.PathDot != "" {
+		if err = writeFile(args.PathDot, func(out *os.File) error {
+			m.writeDot(out)
+			return nil
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	utils.Logger.Info().Str("map", final.ID).Str("path", args.PathOutput).
+		Int("sites", len(final.Sites)).Int("roads", len(final.Roads)).Msg("built")
+	return nil
+}
+
+func writeFile(path string, write func(out *os.File) error) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = write(out); err != nil {
+		out.Close()
+		return errors.Annotate(err, path)
+	}
+	return errors.Annotate(out.Close(), path)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/juju/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const buildSeed = `{"id":"build", "sites":[
+	{"id":"a","city":true},{"id":"b","city":true},{"id":"c","city":true},{"id":"d"}],
+	"roads":[["a","b"],["b","c"],["c","d"],["d","a"]]}`
+
+func writeTemp(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadSpec(t *testing.T, encoded string) (BuildSpec, error) {
+	t.Helper()
+	var spec BuildSpec
+	err := spec.load(writeTemp(t, t.TempDir(), "pipeline.yml", encoded))
+	return spec, err
+}
+
+func TestBuildSpecLoad(t *testing.T) {
+	spec, err := loadSpec(t, `
+seed: 3
+steps:
+  - split: {dist: 30}
+  - noise: {noise: 10, seed: 7}
+  - normalize: {width: 400, height: 300, padding: 10, stretch: true}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Seed != 3 || len(spec.Steps) != 3 || *spec.Steps[1].Noise.Seed != 7 || !spec.Steps[2].Normalize.Stretch {
+		t.Fatalf("unexpected spec %+v", spec)
+	}
+
+	for _, tc := range []struct {
+		name, encoded, problem string
+	}{
+		{"no operation", "steps:\n  - {}\n", "0 operations"},
+		{"two operations", "steps:\n  - {split: {dist: 30}, noise: {noise: 10}}\n", "2 operations"},
+		{"unknown step field", "steps:\n  - split: {dist: 30, length: 2}\n", "length"},
+		{"unknown spec field", "sead: 1\nsteps: []\n", "sead"},
+		{"bad split", "steps:\n  - split: {dist: 0}\n", "split dist"},
+		{"bad noise", "steps:\n  - noise: {noise: 101}\n", "noise"},
+		{"bad canvas", "steps:\n  - normalize: {width: 10, height: 10, padding: 5}\n", "padding"},
+	} {
+		_, err := loadSpec(t, tc.encoded)
+		if !errors.IsNotValid(err) {
+			t.Fatal(tc.name, "unexpected error", err)
+		}
+		if !strings.Contains(err.Error(), tc.problem) {
+			t.Fatal(tc.name, "missing", tc.problem, "in", err)
+		}
+	}
+}
+
+func TestBuildSpecRun(t *testing.T) {
+	run := func(spec BuildSpec) MapRaw {
+		t.Helper()
+		raw, err := loadSeed(buildSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := raw.extractMemMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = spec.run(m)
+		return m.extractRawMap()
+	}
+	steps := func(noiseSeed *int64) []BuildStep {
+		return []BuildStep{
+			{Split: &SplitStep{Dist: 30}},
+			{Noise: &NoiseStep{Noise: 20, Seed: noiseSeed}},
+		}
+	}
+
+	m0 := run(BuildSpec{Seed: 1, Steps: steps(nil)})
+	if len(m0.Sites) <= 4 {
+		t.Fatal("no road split", m0.Sites)
+	}
+	if m1 := run(BuildSpec{Seed: 1, Steps: steps(nil)}); !reflect.DeepEqual(m0, m1) {
+		t.Fatal("the same seed produced different maps")
+	}
+	if m1 := run(BuildSpec{Seed: 2, Steps: steps(nil)}); reflect.DeepEqual(m0, m1) {
+		t.Fatal("different seeds produced the same map")
+	}
+
+	// The seed of the step prevails over the seed of the pipeline
+	seed := int64(1)
+	m0 = run(BuildSpec{Seed: 5, Steps: steps(&seed)})
+	if m1 := run(BuildSpec{Seed: 6, Steps: steps(&seed)}); !reflect.DeepEqual(m0, m1) {
+		t.Fatal("the seed of the step was ignored")
+	}
+	if m1 := run(BuildSpec{Seed: 5, Steps: steps(nil)}); reflect.DeepEqual(m0, m1) {
+		t.Fatal("the seed of the step was ignored")
+	}
+}
+
+func loadFinal(t *testing.T, path string) MapRaw {
+	t.Helper()
+	raw, err := LoadRawMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestToolBuild(t *testing.T) {
+	dir := t.TempDir()
+	pathSeed := writeTemp(t, dir, "build.seed.json", buildSeed)
+	pathSpec := writeTemp(t, dir, "pipeline.yml", "steps:\n  - split: {dist: 30}\n")
+
+	// By default, the final map is next to its seed
+	args := BuildArgs{PathSeed: pathSeed, PathSpec: pathSpec, PathDot: filepath.Join(dir, "build.dot")}
+	if err := ToolBuild(args); err != nil {
+		t.Fatal(err)
+	}
+	if final := loadFinal(t, filepath.Join(dir, "build.final.json")); final.ID != "build" || len(final.Sites) <= 4 {
+		t.Fatalf("unexpected final map %+v", final)
+	}
+	if _, err := os.Stat(args.PathDot); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the suffix, the output path is required
+	args = BuildArgs{PathSeed: writeTemp(t, dir, "build.json", buildSeed), PathSpec: pathSpec}
+	if err := ToolBuild(args); !errors.IsNotValid(err) {
+		t.Fatal("unexpected error", err)
+	}
+	args.PathOutput = filepath.Join(dir, "other.json")
+	if err := ToolBuild(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBuildDocs checks that the pipeline shipped with the seeds produces the
+// same maps as the former `hege tools map init | hege tools map normalize`,
+// with the default canvas of the normalize command.
+func TestBuildDocs(t *testing.T) {
+	docs := filepath.Join("..", "..", "..", "docs", "maps")
+	paths, err := filepath.Glob(filepath.Join(docs, "*.seed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no seed found")
+	}
+	dir := t.TempDir()
+	for _, path := range paths {
+		// The former pipeline, through JSON between both tools
+		var seed MapSeed
+		encoded, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = seed.load(bytes.NewReader(encoded)); err != nil {
+			t.Fatal(path, err)
+		}
+		raw, err := seed.extractRawMap()
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if encoded, err = json.Marshal(raw); err != nil {
+			t.Fatal(err)
+		}
+		raw = makeRawMap()
+		if err = json.Unmarshal(encoded, &raw); err != nil {
+			t.Fatal(err)
+		}
+		m, err := raw.extractMemMap()
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		CanvasArgs{Width: 1920, Height: 1080, Padding: 50}.apply(&m)
+		expected := m.extractRawMap()
+
+		output := filepath.Join(dir, filepath.Base(path)+".final.json")
+		args := BuildArgs{PathSeed: path, PathSpec: filepath.Join(docs, "pipeline.yml"), PathOutput: output}
+		if err = ToolBuild(args); err != nil {
+			t.Fatal(path, err)
+		}
+		if got := loadFinal(t, output); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%s: got %+v expected %+v", path, got, expected)
+		}
+	}
+}
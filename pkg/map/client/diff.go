@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"encoding/json"
+	"github.com/juju/errors"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// MovedSite is a site present in both maps at different positions
+type MovedSite struct {
+	ID   uint64 `json:"id"`
+	OldX uint64 `json:"oldX"`
+	OldY uint64 `json:"oldY"`
+	X    uint64 `json:"x"`
+	Y    uint64 `json:"y"`
+}
+
+// RenamedCity is a site present in both maps with different city names.
+// An empty name stands for a site without city.
+type RenamedCity struct {
+	ID  uint64 `json:"id"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// MapDiff lists the differences between two versions of a MapRaw. The sites
+// are identified by their ID, the roads by their source and destination.
+type MapDiff struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	AddedSites    []uint64      `json:"addedSites,omitempty"`
+	RemovedSites  []uint64      `json:"removedSites,omitempty"`
+	MovedSites    []MovedSite   `json:"movedSites,omitempty"`
+	RenamedCities []RenamedCity `json:"renamedCities,omitempty"`
+	// AlteredSites are the sites whose terrain or bonus changed
+	AlteredSites []uint64 `json:"alteredSites,omitempty"`
+
+	AddedRoads   []RoadRaw `json:"addedRoads,omitempty"`
+	RemovedRoads []RoadRaw `json:"removedRoads,omitempty"`
+	// AlteredRoads are the roads whose terrain or cost changed, with their
+	// new attributes
+	AlteredRoads []RoadRaw `json:"alteredRoads,omitempty"`
+}
+
+// Empty tells if both maps are identical
+func (d *MapDiff) Empty() bool {
+	return len(d.AddedSites) == 0 && len(d.RemovedSites) == 0 && len(d.MovedSites) == 0 &&
+		len(d.RenamedCities) == 0 && len(d.AlteredSites) == 0 &&
+		len(d.AddedRoads) == 0 && len(d.RemovedRoads) == 0 && len(d.AlteredRoads) == 0
+}
+
+// DiffMaps compares two versions of a map, the lists of the report are sorted.
+func DiffMaps(prev, next MapRaw) MapDiff {
+	diff := MapDiff{Old: prev.ID, New: next.ID}
+
+	prevSites := make(map[uint64]SiteRaw)
+	for _, s := range prev.Sites {
+		prevSites[s.ID] = s
+	}
+	nextSites := make(map[uint64]bool)
+	for _, s := range next.Sites {
+		nextSites[s.ID] = true
+		before, ok := prevSites[s.ID]
+		if !ok {
+			diff.AddedSites = append(diff.AddedSites, s.ID)
+			continue
+		}
+		if before.X != s.X || before.Y != s.Y {
+			diff.MovedSites = append(diff.MovedSites, MovedSite{ID: s.ID, OldX: before.X, OldY: before.Y, X: s.X, Y: s.Y})
+		}
+		if before.City != s.City {
+			diff.RenamedCities = append(diff.RenamedCities, RenamedCity{ID: s.ID, Old: before.City, New: s.City})
+		}
+		if !reflect.DeepEqual(before.SiteAttributes, s.SiteAttributes) {
+			diff.AlteredSites = append(diff.AlteredSites, s.ID)
+		}
+	}
+	for _, s := range prev.Sites {
+		if !nextSites[s.ID] {
+			diff.RemovedSites = append(diff.RemovedSites, s.ID)
+		}
+	}
+
+	prevRoads := make(map[RoadRaw]RoadAttributes)
+	for _, r := range prev.Roads {
+		prevRoads[r.ends()] = r.RoadAttributes
+	}
+	nextRoads := make(map[RoadRaw]bool)
+	for _, r := range next.Roads {
+		nextRoads[r.ends()] = true
+		attrs, ok := prevRoads[r.ends()]
+		if !ok {
+			diff.AddedRoads = append(diff.AddedRoads, r)
+		} else if attrs != r.RoadAttributes {
+			diff.AlteredRoads = append(diff.AlteredRoads, r)
+		}
+	}
+	for _, r := range prev.Roads {
+		if !nextRoads[r.ends()] {
+			diff.RemovedRoads = append(diff.RemovedRoads, r)
+			// A duplicated road is reported once
+			nextRoads[r.ends()] = true
+		}
+	}
+
+	sortIDs := func(ids []uint64) {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	sortRoads := func(roads []RoadRaw) {
+		sort.Slice(roads, func(i, j int) bool {
+			ri, rj := roads[i], roads[j]
+			return ri.Src < rj.Src || (ri.Src == rj.Src && ri.Dst < rj.Dst)
+		})
+	}
+	sortIDs(diff.AddedSites)
+	sortIDs(diff.RemovedSites)
+	sortIDs(diff.AlteredSites)
+	sort.Slice(diff.MovedSites, func(i, j int) bool { return diff.MovedSites[i].ID < diff.MovedSites[j].ID })
+	sort.Slice(diff.RenamedCities, func(i, j int) bool { return diff.RenamedCities[i].ID < diff.RenamedCities[j].ID })
+	sortRoads(diff.AddedRoads)
+	sortRoads(diff.RemovedRoads)
+	sortRoads(diff.AlteredRoads)
+	return diff
+}
+
+// LoadRawMap reads the MapRaw stored in the JSON file at the given path
+func LoadRawMap(path string) (MapRaw, error) {
+	var raw MapRaw
+	f, err := os.Open(path)
+	if err != nil {
+		return raw, errors.Trace(err)
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&raw); err != nil {
+		return raw, errors.NewNotValid(err, "invalid map "+path)
+	}
+	return raw, nil
+}
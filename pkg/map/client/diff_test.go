@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"reflect"
+	"testing"
+)
+
+func TestDiffMaps(t *testing.T) {
+	prev := makeRawMap()
+	prev.ID = "prev"
+	prev.Sites = []SiteRaw{
+		{ID: 4, X: 30, Y: 0},
+		{ID: 1, X: 0, Y: 0, City: "Aster"},
+		{ID: 2, X: 10, Y: 0, City: "Borage"},
+		{ID: 3, X: 20, Y: 0, City: "Cumin"},
+	}
+	prev.Roads = []RoadRaw{
+		{Src: 1, Dst: 2},
+		{Src: 2, Dst: 3, RoadAttributes: RoadAttributes{Cost: 1}},
+		{Src: 3, Dst: 4},
+		{Src: 4, Dst: 1},
+		// A duplicated road is reported once
+		{Src: 4, Dst: 1},
+	}
+
+	if d := DiffMaps(prev, prev); !d.Empty() {
+		t.Fatalf("unexpected differences %+v", d)
+	}
+
+	next := makeRawMap()
+	next.ID = "next"
+	next.Sites = []SiteRaw{
+		{ID: 6, X: 50, Y: 0, City: "Fennel"},
+		{ID: 3, X: 20, Y: 0, SiteAttributes: SiteAttributes{Terrain: mapgraph.TerrainForest}},
+		{ID: 2, X: 10, Y: 5, City: "Borage"},
+		{ID: 1, X: 0, Y: 0, City: "Anise"},
+		{ID: 5, X: 40, Y: 0},
+	}
+	next.Roads = []RoadRaw{
+		{Src: 1, Dst: 2},
+		{Src: 2, Dst: 3, RoadAttributes: RoadAttributes{Cost: 2}},
+		{Src: 3, Dst: 6},
+		{Src: 3, Dst: 5},
+	}
+
+	expected := MapDiff{
+		Old:          "prev",
+		New:          "next",
+		AddedSites:   []uint64{5, 6},
+		RemovedSites: []uint64{4},
+		MovedSites:   []MovedSite{{ID: 2, OldX: 10, OldY: 0, X: 10, Y: 5}},
+		RenamedCities: []RenamedCity{
+			{ID: 1, Old: "Aster", New: "Anise"},
+			{ID: 3, Old: "Cumin", New: ""},
+		},
+		AlteredSites: []uint64{3},
+		AddedRoads:   []RoadRaw{{Src: 3, Dst: 5}, {Src: 3, Dst: 6}},
+		RemovedRoads: []RoadRaw{{Src: 3, Dst: 4}, {Src: 4, Dst: 1}},
+		AlteredRoads: []RoadRaw{{Src: 2, Dst: 3, RoadAttributes: RoadAttributes{Cost: 2}}},
+	}
+	d := DiffMaps(prev, next)
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("got %+v expected %+v", d, expected)
+	}
+	if d.Empty() {
+		t.Fatal("unexpected empty diff")
+	}
+}
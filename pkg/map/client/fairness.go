@@ -0,0 +1,150 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/juju/errors"
+	"math"
+	"sort"
+)
+
+// FairnessArgs tells how to compare the cities of a map
+type FairnessArgs struct {
+	// K is the number of neighbor cities considered for each city
+	K int
+	// Threshold is the number of standard deviations from the mean beyond
+	// which a city is flagged as an outlier.
+	Threshold float64
+}
+
+// CityFairness gathers the metrics of a city site
+type CityFairness struct {
+	ID   uint64 `json:"id"`
+	City string `json:"city"`
+	// Neighbors is the average length of the paths to the K nearest cities
+	Neighbors float64 `json:"neighbors"`
+	// Roads is the number of roads leaving the site
+	Roads float64 `json:"roads"`
+	// Closeness is the closeness centrality of the site, i.e. the inverse of
+	// the average length of the paths to all the other sites, multiplied
+	// by 1000 for the sake of readability.
+	Closeness float64 `json:"closeness"`
+	// Outliers lists the metrics whose value is beyond the threshold
+	Outliers []string `json:"outliers,omitempty"`
+}
+
+// MetricStats are the mean and the standard deviation of a metric over the cities
+type MetricStats struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// FairnessReport compares the cities of a map
+type FairnessReport struct {
+	Map    string                 `json:"map"`
+	K      int                    `json:"k"`
+	Stats  map[string]MetricStats `json:"stats"`
+	Cities []CityFairness         `json:"cities"`
+}
+
+// Outliers returns the number of cities with at least one outlier metric
+func (r *FairnessReport) Outliers() int {
+	count := 0
+	for _, c := range r.Cities {
+		if len(c.Outliers) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func fairness(m *mapgraph.Map, args FairnessArgs) (FairnessReport, error) {
+	report := FairnessReport{
+		Map:    m.ID,
+		K:      args.K,
+		Stats:  make(map[string]MetricStats),
+		Cities: make([]CityFairness, 0),
+	}
+	if args.K < 1 {
+		return report, errors.NotValidf("k=%d", args.K)
+	}
+	if args.Threshold <= 0 {
+		return report, errors.NotValidf("threshold=%v", args.Threshold)
+	}
+
+	for _, v := range m.Cells {
+		if v.City == "" {
+			continue
+		}
+		dist, err := m.Distances(v.ID)
+		if err != nil {
+			return report, errors.Trace(err)
+		}
+
+		toCities := make([]uint64, 0)
+		var total uint64
+		for id, d := range dist {
+			if id == v.ID {
+				continue
+			}
+			total += d
+			if m.CellGet(id).City != "" {
+				toCities = append(toCities, d)
+			}
+		}
+		sort.Slice(toCities, func(i, j int) bool { return toCities[i] < toCities[j] })
+		if len(toCities) > args.K {
+			toCities = toCities[:args.K]
+		}
+
+		cf := CityFairness{ID: v.ID, City: v.City, Roads: float64(len(m.CellAdjacency(v.ID)))}
+		if len(toCities) > 0 {
+			var sum uint64
+			for _, d := range toCities {
+				sum += d
+			}
+			cf.Neighbors = float64(sum) / float64(len(toCities))
+		}
+		if total > 0 {
+			cf.Closeness = 1000 * float64(len(dist)-1) / float64(total)
+		}
+		report.Cities = append(report.Cities, cf)
+	}
+
+	metrics := []struct {
+		name  string
+		value func(c *CityFairness) float64
+	}{
+		{"neighbors", func(c *CityFairness) float64 { return c.Neighbors }},
+		{"roads", func(c *CityFairness) float64 { return c.Roads }},
+		{"closeness", func(c *CityFairness) float64 { return c.Closeness }},
+	}
+	for _, metric := range metrics {
+		var stats MetricStats
+		n := float64(len(report.Cities))
+		for i := range report.Cities {
+			stats.Mean += metric.value(&report.Cities[i]) / n
+		}
+		for i := range report.Cities {
+			delta := metric.value(&report.Cities[i]) - stats.Mean
+			stats.StdDev += delta * delta / n
+		}
+		stats.StdDev = math.Sqrt(stats.StdDev)
+		report.Stats[metric.name] = stats
+
+		if stats.StdDev == 0 {
+			continue
+		}
+		for i := range report.Cities {
+			c := &report.Cities[i]
+			if math.Abs(metric.value(c)-stats.Mean) > args.Threshold*stats.StdDev {
+				c.Outliers = append(c.Outliers, metric.name)
+			}
+		}
+	}
+	return report, nil
+}
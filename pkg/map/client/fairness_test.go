@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"fmt"
+	"testing"
+)
+
+// remoteCityMap builds a 3x3 grid of cities, and a remote city only linked
+// to a corner of the grid by a long road.
+func remoteCityMap() MapRaw {
+	m := makeRawMap()
+	m.ID = "remote"
+	link := func(src, dst uint64) {
+		m.Roads = append(m.Roads, RoadRaw{Src: src, Dst: dst}, RoadRaw{Src: dst, Dst: src})
+	}
+	for i := uint64(0); i < 9; i++ {
+		id := i + 1
+		m.Sites = append(m.Sites, SiteRaw{ID: id, X: (i % 3) * 10, Y: (i / 3) * 10, City: fmt.Sprintf("c%d", id)})
+		if i%3 > 0 {
+			link(id-1, id)
+		}
+		if i >= 3 {
+			link(id-3, id)
+		}
+	}
+	m.Sites = append(m.Sites, SiteRaw{ID: 10, X: 200, Y: 200, City: "remote"})
+	link(9, 10)
+	return m
+}
+
+func TestFairnessOutlier(t *testing.T) {
+	m := loadGraph(t, remoteCityMap())
+	report, err := fairness(m, FairnessArgs{K: 3, Threshold: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Cities) != 10 {
+		t.Fatal("cities", len(report.Cities))
+	}
+	for _, c := range report.Cities {
+		flagged := make(map[string]bool)
+		for _, metric := range c.Outliers {
+			flagged[metric] = true
+		}
+		remote := c.ID == 10
+		if flagged["neighbors"] != remote || flagged["closeness"] != remote {
+			t.Fatal("city", c.ID, "outliers", c.Outliers)
+		}
+	}
+	if report.Outliers() != 1 {
+		t.Fatal("outliers", report.Outliers())
+	}
+
+	// A higher threshold tolerates the remote city
+	if report, err = fairness(m, FairnessArgs{K: 3, Threshold: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Outliers() != 0 {
+		t.Fatal("outliers", report.Outliers())
+	}
+
+	if _, err = fairness(m, FairnessArgs{K: 0, Threshold: 2}); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if _, err = fairness(m, FairnessArgs{K: 3, Threshold: 0}); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
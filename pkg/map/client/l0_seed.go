@@ -6,36 +6,106 @@
 package mapclient
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/juju/errors"
+	"io"
+	"io/ioutil"
+	"math"
 	"sort"
+	"strings"
 )
 
+// defaultSpacing is the distance between the sites placed by default
+const defaultSpacing = 100
+
 // SiteSeed is the minimal representation of a graph node in a map for Hegemonie.
+// The coordinates are optional, the sites without coordinates are placed
+// by default (see placeSites).
 type SiteSeed struct {
-	ID   string `json:"id"`
-	X    uint64 `json:"x"`
-	Y    uint64 `json:"y"`
-	City bool   `json:"city"`
+	ID   string  `json:"id"`
+	X    *uint64 `json:"x,omitempty"`
+	Y    *uint64 `json:"y,omitempty"`
+	City bool    `json:"city"`
+	SiteAttributes
 }
 
-// RoadSeed is the minimal representation of a diriectional graph edge in a map for Hegemonie.
+// RoadSeed is the minimal representation of a graph edge in a map for Hegemonie.
+// The road is undirected unless OneWay is set.
+// In a seed file, a road is either an object {"src": "A", "dst": "B"} or
+// the shorter pair ["A", "B"].
 type RoadSeed struct {
 	Src string `json:"src"`
 	Dst string `json:"dst"`
+	// OneWay restricts the road to the direction from Src to Dst
+	OneWay bool `json:"oneway,omitempty"`
+	RoadAttributes
 }
 
 // MapSeed is a handy representation of a map where nearly each node of the graph carries a city.
 // - The sites are identified by a textual name, typically decided by a content creator
 // that will name the cities in a meaningful way.
 // - Each site name MUST be unique
-// - The reads bidirectional.
-// - Duplicated roads are treated as is, no simplification is done.
+// - The roads are bidirectional, unless explicitly one-way.
+// - Each road MUST be unique, whatever its direction.
 type MapSeed struct {
 	ID    string     `json:"id"`
 	Sites []SiteSeed `json:"sites"`
 	Roads []RoadSeed `json:"roads"`
 }
 
+// UnmarshalJSON accepts both the object and the pair forms of a road
+func (r *RoadSeed) UnmarshalJSON(b []byte) error {
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		var pair []string
+		if err := json.Unmarshal(trimmed, &pair); err != nil || len(pair) != 2 {
+			return errors.NotValidf("road %s, expected a pair of sites", string(trimmed))
+		}
+		*r = RoadSeed{Src: pair[0], Dst: pair[1]}
+		return nil
+	}
+
+	// The alias type prevents the recursion
+	type plainRoad RoadSeed
+	var tmp plainRoad
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tmp); err != nil {
+		return errors.NewNotValid(err, fmt.Sprintf("road %s", string(b)))
+	}
+	*r = RoadSeed(tmp)
+	return nil
+}
+
+// load decodes the JSON seed. The unknown fields are refused, to detect the
+// typos, and the syntax errors mention their line and column.
+func (ms *MapSeed) load(in io.Reader) error {
+	encoded, err := ioutil.ReadAll(in)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(ms); err != nil {
+		var offset int64 = -1
+		switch tv := err.(type) {
+		case *json.SyntaxError:
+			offset = tv.Offset
+		case *json.UnmarshalTypeError:
+			offset = tv.Offset
+		}
+		if offset >= 0 && offset <= int64(len(encoded)) {
+			before := encoded[:offset]
+			line := bytes.Count(before, []byte{'\n'}) + 1
+			column := len(before) - bytes.LastIndexByte(before, '\n')
+			return errors.NewNotValid(err, fmt.Sprintf("invalid json at line %d column %d", line, column))
+		}
+		return errors.NewNotValid(err, "invalid json")
+	}
+	return nil
+}
+
 func (s SiteSeed) cityName() string {
 	if s.City {
 		return s.ID
@@ -46,42 +116,151 @@ func (s SiteSeed) cityName() string {
 // extractRawMap to a extractRawMap mapMem. extractRawMap maps are very similar to mapMem Seeds, they mostly differ
 // by the kind/type of index used for the Sites (vertices): mapMem Seeds use strings, extractRawMap Maps
 // use sequential indexes.
+// All the problems of the seed are reported at once, each naming the
+// offending site or road.
 func (ms *MapSeed) extractRawMap() (MapRaw, error) {
-	var err error
-	byName := make(map[string]uint64)
+	problems := make([]string, 0)
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
 
 	rawMap := makeRawMap()
 	rawMap.ID = ms.ID
+	if ms.ID == "" {
+		fail("empty map ID")
+	}
 
-	// Sorted Sites / Vertices
-	sort.Slice(ms.Sites, func(i, j int) bool { return ms.Sites[i].ID < ms.Sites[j].ID })
+	names := make(map[string]bool)
 	for idx, s := range ms.Sites {
+		name := fmt.Sprintf("site %s", s.ID)
+		switch {
+		case s.ID == "":
+			name = fmt.Sprintf("site #%d", idx)
+			fail("%s: empty ID", name)
+		case names[s.ID]:
+			fail("%s: duplicate", name)
+		default:
+			names[s.ID] = true
+		}
+		if (s.X == nil) != (s.Y == nil) {
+			fail("%s: partial coordinates", name)
+		}
+	}
+
+	// Sorted Sites / Vertices
+	byName := make(map[string]uint64)
+	sites := append([]SiteSeed{}, ms.Sites...)
+	sort.SliceStable(sites, func(i, j int) bool { return sites[i].ID < sites[j].ID })
+	placed := make([]bool, 0, len(sites))
+	for idx, s := range sites {
 		// We need a non-zero unique ID that is monotonically increasing
 		id := uint64(idx) + 1
-		sr := SiteRaw{ID: id, X: s.X, Y: s.Y, City: s.cityName()}
+		sr := SiteRaw{ID: id, City: s.cityName(), SiteAttributes: s.SiteAttributes}
+		if s.X != nil && s.Y != nil {
+			sr.X, sr.Y = *s.X, *s.Y
+		}
 		rawMap.Sites = append(rawMap.Sites, sr)
-		byName[s.ID] = id
+		placed = append(placed, s.X != nil && s.Y != nil)
+		if _, ok := byName[s.ID]; !ok {
+			byName[s.ID] = id
+		}
 	}
+	placeSites(rawMap.Sites, placed)
 
 	// Sorted Roads / Edges
+	seen := make(map[RoadRaw]int)
 	for i, r := range ms.Roads {
+		name := fmt.Sprintf("road #%d (%s->%s)", i, r.Src, r.Dst)
 		src, dst := byName[r.Src], byName[r.Dst]
-		if src <= 0 {
-			err = errors.NotValidf("No such site [%s] [%v:%v->%v]", r.Src, i, r.Src, r.Dst)
-			break
-		} else if dst <= 0 {
-			err = errors.NotValidf("No such site [%s] [%v:%v->%v]", r.Dst, i, r.Src, r.Dst)
-			break
-		} else {
-			// map seeds are graphs, raw maps are digraphs ... we need to expand in directions
-			rawMap.Roads = append(rawMap.Roads, RoadRaw{src, dst})
-			rawMap.Roads = append(rawMap.Roads, RoadRaw{dst, src})
+		switch {
+		case src <= 0:
+			fail("%s: unknown site %q", name, r.Src)
+			continue
+		case dst <= 0:
+			fail("%s: unknown site %q", name, r.Dst)
+			continue
+		case src == dst:
+			fail("%s: self-loop", name)
+			continue
+		}
+
+		// map seeds are graphs, raw maps are digraphs ... we need to expand in directions
+		roads := []RoadRaw{{Src: src, Dst: dst, RoadAttributes: r.RoadAttributes}}
+		if !r.OneWay {
+			roads = append(roads, RoadRaw{Src: dst, Dst: src, RoadAttributes: r.RoadAttributes})
+		}
+		for _, road := range roads {
+			if j, ok := seen[road.ends()]; ok {
+				fail("%s: duplicate of road #%d", name, j)
+				break
+			}
+			seen[road.ends()] = i
 		}
+		rawMap.Roads = append(rawMap.Roads, roads...)
 	}
 	sort.Slice(rawMap.Roads, func(i, j int) bool {
 		ri, rj := rawMap.Roads[i], rawMap.Roads[j]
 		return ri.Src < rj.Src || (ri.Src == rj.Src && ri.Dst < rj.Dst)
 	})
 
-	return rawMap, err
+	if len(problems) > 0 {
+		return rawMap, errors.NotValidf("seed [%s]: %s", ms.ID, strings.Join(problems, "; "))
+	}
+	return rawMap, nil
+}
+
+// placeSites gives a position to the sites without coordinates: they are
+// evenly spread, in their order, on a circle around the sites with
+// coordinates.
+func placeSites(sites []SiteRaw, placed []bool) {
+	var xmin, xmax, ymin, ymax uint64 = math.MaxUint64, 0, math.MaxUint64, 0
+	missing := 0
+	for i, s := range sites {
+		if !placed[i] {
+			missing++
+			continue
+		}
+		xmin, xmax = minU64(xmin, s.X), maxU64(xmax, s.X)
+		ymin, ymax = minU64(ymin, s.Y), maxU64(ymax, s.Y)
+	}
+	if missing == 0 {
+		return
+	}
+
+	// Large enough to surround the placed sites, and to keep the new sites
+	// away from each other.
+	extent := 0.0
+	cx, cy := 0.0, 0.0
+	if missing < len(sites) {
+		extent = math.Max(float64(xmax-xmin), float64(ymax-ymin))
+		cx, cy = (float64(xmin)+float64(xmax))/2, (float64(ymin)+float64(ymax))/2
+	}
+	radius := math.Max(extent/2+defaultSpacing, float64(missing)*defaultSpacing/(2*math.Pi))
+	cx, cy = math.Max(cx, radius), math.Max(cy, radius)
+
+	rank := 0
+	for i := range sites {
+		if placed[i] {
+			continue
+		}
+		// Clockwise from the top
+		angle := 2*math.Pi*float64(rank)/float64(missing) - math.Pi/2
+		sites[i].X = uint64(math.Round(cx + radius*math.Cos(angle)))
+		sites[i].Y = uint64(math.Round(cy + radius*math.Sin(angle)))
+		rank++
+	}
+}
+
+func minU64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxU64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,165 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"github.com/juju/errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadSeed(encoded string) (MapRaw, error) {
+	var seed MapSeed
+	if err := seed.load(strings.NewReader(encoded)); err != nil {
+		return MapRaw{}, err
+	}
+	return seed.extractRawMap()
+}
+
+func TestSeedGood(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		encoded string
+		roads   [][2]uint64
+	}{
+		{"pairs",
+			`{"id":"m", "sites":[{"id":"b"},{"id":"a","city":true}], "roads":[["a","b"]]}`,
+			[][2]uint64{{1, 2}, {2, 1}}},
+		{"objects",
+			`{"id":"m", "sites":[{"id":"a"},{"id":"b"},{"id":"c"}], "roads":[{"src":"a","dst":"b"},{"src":"c","dst":"b","oneway":true}]}`,
+			[][2]uint64{{1, 2}, {2, 1}, {3, 2}}},
+		{"opposite one-way roads",
+			`{"id":"m", "sites":[{"id":"a"},{"id":"b"}], "roads":[{"src":"a","dst":"b","oneway":true},{"src":"b","dst":"a","oneway":true}]}`,
+			[][2]uint64{{1, 2}, {2, 1}}},
+	} {
+		raw, err := loadSeed(tc.encoded)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		roads := make([][2]uint64, 0)
+		for _, r := range raw.Roads {
+			roads = append(roads, [2]uint64{r.Src, r.Dst})
+		}
+		if !reflect.DeepEqual(roads, tc.roads) {
+			t.Fatal(tc.name, "roads", roads, "expected", tc.roads)
+		}
+	}
+
+	// The sites are sorted by name, and only the sites flagged as cities
+	// carry a city.
+	raw, err := loadSeed(`{"id":"m", "sites":[{"id":"b"},{"id":"a","city":true}], "roads":[["a","b"]]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Sites[0].City != "a" || raw.Sites[1].City != "" {
+		t.Fatal("sites", raw.Sites)
+	}
+}
+
+func TestSeedBad(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		encoded string
+		// problems are the expected parts of the error, one per problem
+		problems []string
+	}{
+		{"unknown field", `{"id":"m", "sites":[{"id":"a","citty":true}], "roads":[]}`,
+			[]string{`unknown field "citty"`}},
+		{"syntax", "{\"id\":\"m\",\n \"sites\":[{\"id\":\"a\"},]}",
+			[]string{"line 2 column"}},
+		{"bad pair", `{"id":"m", "sites":[{"id":"a"}], "roads":[["a"]]}`,
+			[]string{`road ["a"]`}},
+		{"empty map ID", `{"sites":[{"id":"a"}], "roads":[]}`,
+			[]string{"empty map ID"}},
+		{"sites", `{"id":"m", "sites":[{"id":"a"},{"id":""},{"id":"a"},{"id":"b","x":1}], "roads":[]}`,
+			[]string{"site #1: empty ID", "site a: duplicate", "site b: partial coordinates"}},
+		{"roads", `{"id":"m", "sites":[{"id":"a"},{"id":"b"}], "roads":[["a","x"],["y","b"],["a","a"],["a","b"],["b","a"]]}`,
+			[]string{
+				`road #0 (a->x): unknown site "x"`,
+				`road #1 (y->b): unknown site "y"`,
+				`road #2 (a->a): self-loop`,
+				`road #4 (b->a): duplicate of road #3`,
+			}},
+		{"one-way duplicate", `{"id":"m", "sites":[{"id":"a"},{"id":"b"}], "roads":[["a","b"],{"src":"b","dst":"a","oneway":true}]}`,
+			[]string{`road #1 (b->a): duplicate of road #0`}},
+	} {
+		_, err := loadSeed(tc.encoded)
+		if !errors.IsNotValid(err) {
+			t.Fatal(tc.name, "unexpected error", err)
+		}
+		for _, p := range tc.problems {
+			if !strings.Contains(err.Error(), p) {
+				t.Fatal(tc.name, "missing", p, "in", err)
+			}
+		}
+		if n := strings.Count(err.Error(), "; ") + 1; len(tc.problems) > 1 && n != len(tc.problems) {
+			t.Fatal(tc.name, n, "problems reported in", err)
+		}
+	}
+}
+
+func TestSeedPlacement(t *testing.T) {
+	// Without any coordinates, the sites are spread clockwise on a circle
+	raw, err := loadSeed(`{"id":"m", "sites":[{"id":"a"},{"id":"b"},{"id":"c"},{"id":"d"}], "roads":[]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]uint64{{100, 0}, {200, 100}, {100, 200}, {0, 100}}
+	for i, s := range raw.Sites {
+		if s.X != expected[i][0] || s.Y != expected[i][1] {
+			t.Fatal("site", s.ID, "at", s.X, s.Y, "expected", expected[i])
+		}
+	}
+
+	// The sites without coordinates surround the others, that keep theirs
+	raw, err = loadSeed(`{"id":"m", "sites":[{"id":"a","x":500,"y":500},{"id":"b","x":700,"y":600},{"id":"c"},{"id":"d"},{"id":"e"}], "roads":[]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Sites[0].X != 500 || raw.Sites[0].Y != 500 || raw.Sites[1].X != 700 || raw.Sites[1].Y != 600 {
+		t.Fatal("sites moved", raw.Sites)
+	}
+	cx, cy := 600.0, 550.0
+	for _, s := range raw.Sites[2:] {
+		d := math.Hypot(float64(s.X)-cx, float64(s.Y)-cy)
+		if math.Abs(d-200) > 1 {
+			t.Fatal("site", s.ID, "at", s.X, s.Y, "distance", d)
+		}
+	}
+}
+
+// TestSeedDocs checks that the seeds shipped as examples still load into
+// valid maps.
+func TestSeedDocs(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "..", "docs", "maps", "*.seed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no seed found")
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seed MapSeed
+		err = seed.load(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		raw, err := seed.extractRawMap()
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		loadGraph(t, raw)
+	}
+}
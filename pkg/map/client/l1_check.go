@@ -0,0 +1,255 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"math"
+	"sort"
+)
+
+// CheckReport lists all the defects of a MapRaw that would prevent the map
+// service from loading it, and the defects that are likely to be design
+// mistakes.
+type CheckReport struct {
+	// Components are the strongly connected components of the map, the
+	// biggest first. A valid map has exactly one component.
+	Components [][]uint64 `json:"components"`
+	// Dangling are the roads whose source or destination doesn't exist
+	Dangling []RoadRaw `json:"dangling,omitempty"`
+	// Loops are the roads whose source and destination are the same site
+	Loops []RoadRaw `json:"loops,omitempty"`
+	// Duplicates are the roads present more than once
+	Duplicates []RoadRaw `json:"duplicates,omitempty"`
+	// OneWay are the roads without a road in the opposite direction
+	OneWay []RoadRaw `json:"oneway,omitempty"`
+	// Isolated are the cities without any road
+	Isolated []uint64 `json:"isolated,omitempty"`
+	// Added are the roads added by the repair
+	Added []RoadRaw `json:"added,omitempty"`
+}
+
+// Valid tells if the map would be accepted by the map service
+func (r *CheckReport) Valid() bool {
+	return len(r.Components) <= 1 && len(r.Dangling) == 0 && len(r.Loops) == 0 && len(r.Duplicates) == 0
+}
+
+// check inspects the map and returns the report of its defects, along with
+// the map stripped of its invalid roads.
+func (mr *MapRaw) check() (CheckReport, MapRaw) {
+	var report CheckReport
+	clean := makeRawMap()
+	clean.ID = mr.ID
+	clean.Sites = append(clean.Sites, mr.Sites...)
+
+	sites := make(map[uint64]bool)
+	for _, s := range mr.Sites {
+		sites[s.ID] = true
+	}
+	roads := make(map[RoadRaw]bool)
+	for _, r := range mr.Roads {
+		switch {
+		case !sites[r.Src] || !sites[r.Dst]:
+			report.Dangling = append(report.Dangling, r)
+		case r.Src == r.Dst:
+			report.Loops = append(report.Loops, r)
+		case roads[r.ends()]:
+			report.Duplicates = append(report.Duplicates, r)
+		default:
+			roads[r.ends()] = true
+			clean.Roads = append(clean.Roads, r)
+		}
+	}
+
+	connected := make(map[uint64]bool)
+	for _, r := range clean.Roads {
+		connected[r.Src], connected[r.Dst] = true, true
+		if !roads[RoadRaw{Src: r.Dst, Dst: r.Src}] {
+			report.OneWay = append(report.OneWay, r)
+		}
+	}
+	for _, s := range mr.Sites {
+		if s.City != "" && !connected[s.ID] {
+			report.Isolated = append(report.Isolated, s.ID)
+		}
+	}
+
+	cc := clean.components()
+	report.Components = cc.list()
+	return report, clean
+}
+
+// repair adds roads to the map until it is strongly connected. The map
+// must be free of invalid roads.
+// Each step adds the shortest road from a component without exit (a sink)
+// to a component without entry (a source), preferably a source that doesn't
+// reach the sink, so that both the number of sources and the number of sinks
+// decrease. That requires max(sources, sinks) roads in the usual cases.
+func (mr *MapRaw) repair() []RoadRaw {
+	added := make([]RoadRaw, 0)
+	byID := make(map[uint64]SiteRaw)
+	for _, s := range mr.Sites {
+		byID[s.ID] = s
+	}
+	for {
+		cc := mr.components()
+		if cc.count <= 1 {
+			return added
+		}
+		sources, sinks := cc.ends()
+
+		reachable := make(map[int]map[int]bool)
+		for _, s := range sources {
+			reachable[s] = cc.reachableFrom(s)
+		}
+
+		var best RoadRaw
+		bestDist, bestReach := math.Inf(1), true
+		for _, t := range sinks {
+			for _, s := range sources {
+				if s == t {
+					continue
+				}
+				reaches := reachable[s][t]
+				if reaches && !bestReach {
+					continue
+				}
+				for _, src := range cc.members[t] {
+					for _, dst := range cc.members[s] {
+						d := siteDistance(byID[src], byID[dst])
+						if (bestReach && !reaches) || d < bestDist {
+							best, bestDist, bestReach = RoadRaw{Src: src, Dst: dst}, d, reaches
+						}
+					}
+				}
+			}
+		}
+		mr.Roads = append(mr.Roads, best)
+		added = append(added, best)
+	}
+}
+
+// components holds the strongly connected components of a map
+type components struct {
+	count   int
+	of      map[uint64]int
+	members [][]uint64
+	// next is the adjacency of the condensation of the map
+	next []map[int]bool
+}
+
+// components computes the strongly connected components of the map, with
+// Tarjan's algorithm.
+func (mr *MapRaw) components() components {
+	adj := make(map[uint64][]uint64)
+	for _, r := range mr.Roads {
+		adj[r.Src] = append(adj[r.Src], r.Dst)
+	}
+
+	cc := components{of: make(map[uint64]int)}
+	index := make(map[uint64]int)
+	low := make(map[uint64]int)
+	onStack := make(map[uint64]bool)
+	stack := make([]uint64, 0)
+	var visit func(v uint64)
+	visit = func(v uint64) {
+		index[v], low[v] = len(index), len(index)
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range adj[v] {
+			if _, ok := index[w]; !ok {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] == index[v] {
+			members := make([]uint64, 0)
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				cc.of[w] = cc.count
+				members = append(members, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+			cc.members = append(cc.members, members)
+			cc.count++
+		}
+	}
+	for _, s := range mr.Sites {
+		if _, ok := index[s.ID]; !ok {
+			visit(s.ID)
+		}
+	}
+
+	cc.next = make([]map[int]bool, cc.count)
+	for i := range cc.next {
+		cc.next[i] = make(map[int]bool)
+	}
+	for _, r := range mr.Roads {
+		if s, d := cc.of[r.Src], cc.of[r.Dst]; s != d {
+			cc.next[s][d] = true
+		}
+	}
+	return cc
+}
+
+// ends returns the components without entry and the components without exit
+func (cc *components) ends() (sources, sinks []int) {
+	entries := make([]int, cc.count)
+	for _, next := range cc.next {
+		for d := range next {
+			entries[d]++
+		}
+	}
+	for i := 0; i < cc.count; i++ {
+		if entries[i] == 0 {
+			sources = append(sources, i)
+		}
+		if len(cc.next[i]) == 0 {
+			sinks = append(sinks, i)
+		}
+	}
+	return sources, sinks
+}
+
+func (cc *components) reachableFrom(c int) map[int]bool {
+	seen := map[int]bool{c: true}
+	todo := []int{c}
+	for len(todo) > 0 {
+		c, todo = todo[len(todo)-1], todo[:len(todo)-1]
+		for d := range cc.next[c] {
+			if !seen[d] {
+				seen[d] = true
+				todo = append(todo, d)
+			}
+		}
+	}
+	return seen
+}
+
+// list returns the components, the biggest first then by lowest site ID
+func (cc *components) list() [][]uint64 {
+	out := append([][]uint64{}, cc.members...)
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) > len(out[j])
+		}
+		return out[i][0] < out[j][0]
+	})
+	return out
+}
+
+func siteDistance(src, dst SiteRaw) float64 {
+	dx, dy := float64(dst.X)-float64(src.X), float64(dst.Y)-float64(src.Y)
+	return math.Sqrt(dx*dx + dy*dy)
+}
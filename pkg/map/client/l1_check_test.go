@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+// brokenMap builds a map of 4 strongly connected components: a ring of 3
+// sites, a pair of sites and 2 single sites. One-way roads link the ring to
+// the pair, and the pair to a single site. The last site is isolated.
+func brokenMap() MapRaw {
+	m := makeRawMap()
+	m.ID = "broken"
+	for i := uint64(1); i <= 7; i++ {
+		m.Sites = append(m.Sites, SiteRaw{ID: i, X: i * 10, Y: (i % 3) * 10, City: "c"})
+	}
+	for _, r := range [][2]uint64{
+		{1, 2}, {2, 3}, {3, 1},
+		{4, 5}, {5, 4},
+		{3, 4}, {5, 6},
+		// invalid roads
+		{1, 1}, {1, 2}, {6, 9},
+	} {
+		m.Roads = append(m.Roads, RoadRaw{Src: r[0], Dst: r[1]})
+	}
+	return m
+}
+
+func TestCheck(t *testing.T) {
+	m := brokenMap()
+	report, clean := m.check()
+	if report.Valid() {
+		t.Fatal("unexpected valid map")
+	}
+	if !reflect.DeepEqual(report.Components, [][]uint64{{1, 2, 3}, {4, 5}, {6}, {7}}) {
+		t.Fatal("components", report.Components)
+	}
+	if len(report.Loops) != 1 || len(report.Duplicates) != 1 || len(report.Dangling) != 1 {
+		t.Fatal("invalid roads", report)
+	}
+	if !reflect.DeepEqual(report.Isolated, []uint64{7}) {
+		t.Fatal("isolated", report.Isolated)
+	}
+	if len(clean.Roads) != 7 || len(report.OneWay) != 5 {
+		t.Fatal("clean", clean.Roads, "oneway", report.OneWay)
+	}
+}
+
+func TestCheckRepair(t *testing.T) {
+	m := brokenMap()
+	_, clean := m.check()
+	before := make(map[RoadRaw]bool)
+	for _, r := range clean.Roads {
+		before[r] = true
+	}
+
+	added := clean.repair()
+	report, _ := clean.check()
+	if !report.Valid() || len(report.Components) != 1 || len(report.Isolated) > 0 {
+		t.Fatal("still broken", report)
+	}
+	loadGraph(t, clean)
+
+	// The repair only adds roads, and not more than the sources or sinks
+	// of the condensation: {1,2,3} and {7} are sources, {6} and {7} are sinks.
+	if len(added) == 0 || len(added) > 2 {
+		t.Fatal("added", added)
+	}
+	if len(clean.Roads) != len(before)+len(added) {
+		t.Fatal("roads", len(clean.Roads), "expected", len(before)+len(added))
+	}
+	for _, r := range added {
+		if before[r] {
+			t.Fatal("road", r, "already present")
+		}
+	}
+	for r := range before {
+		found := false
+		for _, r1 := range clean.Roads {
+			found = found || r1 == r
+		}
+		if !found {
+			t.Fatal("road", r, "lost")
+		}
+	}
+
+	// A valid map needs no repair
+	if again := clean.repair(); len(again) != 0 {
+		t.Fatal("added", again)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"encoding/json"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Projection maps the GeoJSON coordinates (longitude, latitude) to the
+// integer positions of the sites: the origin is the top-left corner of the
+// map, the Y axis goes downward.
+type Projection struct {
+	// Lon and Lat are the coordinates of the origin of the map
+	Lon, Lat float64
+	// Scale is the number of position units per degree
+	Scale float64
+	// Fit places the origin at the top-left corner of the features, upon import.
+	Fit bool
+}
+
+func (p Projection) validate() error {
+	if p.Scale <= 0 || math.IsInf(p.Scale, 0) || math.IsNaN(p.Scale) {
+		return errors.NotValidf("scale %v", p.Scale)
+	}
+	return nil
+}
+
+func (p Projection) project(pos []float64) (x, y uint64, err error) {
+	if len(pos) < 2 {
+		return 0, 0, errors.NotValidf("position %v", pos)
+	}
+	fx := math.Round((pos[0] - p.Lon) * p.Scale)
+	fy := math.Round((p.Lat - pos[1]) * p.Scale)
+	if fx < 0 || fy < 0 || fx > math.MaxInt64 || fy > math.MaxInt64 {
+		return 0, 0, errors.NotValidf("position %v out of the projected space", pos)
+	}
+	return uint64(fx), uint64(fy), nil
+}
+
+func (p Projection) unproject(x, y uint64) []float64 {
+	return []float64{p.Lon + float64(x)/p.Scale, p.Lat - float64(y)/p.Scale}
+}
+
+// GeoJSON structures, restricted to the features that matter for a map:
+//   - a Point is a site, with the optional properties "id", "city" (or "name"),
+//     "terrain" and "bonus". A Point without "id" cannot share its position
+//     with another Point.
+//   - a LineString is a road, bidirectional unless its property "oneway" is true.
+//     Its ends are the sites given by the properties "src" and "dst" or, when
+//     absent, the Points at the same positions. The intermediate positions
+//     become sites without city. The optional properties "terrain" and "cost"
+//     apply to all the segments of the line.
+type geoCollection struct {
+	Type     string       `json:"type"`
+	Name     string       `json:"name,omitempty"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoGeometry            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// geoID reads an optional numeric property. The value may be a JSON number
+// or a string, as GIS tools sometimes export the identifiers as text.
+func geoID(props map[string]interface{}, key string) (uint64, bool, error) {
+	v, ok := props[key]
+	if !ok || v == nil {
+		return 0, false, nil
+	}
+	var s string
+	switch tv := v.(type) {
+	case json.Number:
+		s = tv.String()
+	case string:
+		s = tv
+	default:
+		return 0, false, errors.NotValidf("property %s=%v", key, v)
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false, errors.NotValidf("property %s=%v", key, v)
+	}
+	return id, true, nil
+}
+
+// geoFloat reads an optional floating point property
+func geoFloat(props map[string]interface{}, key string) (float64, error) {
+	v, ok := props[key]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	switch tv := v.(type) {
+	case json.Number:
+		f, err := tv.Float64()
+		if err != nil {
+			return 0, errors.NotValidf("property %s=%v", key, v)
+		}
+		return f, nil
+	case float64:
+		return tv, nil
+	default:
+		return 0, errors.NotValidf("property %s=%v", key, v)
+	}
+}
+
+// geoBonus reads the optional bonus of a site, an object with the same
+// structure as mapgraph.Bonus
+func geoBonus(props map[string]interface{}) (*mapgraph.Bonus, error) {
+	v, ok := props["bonus"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var bonus mapgraph.Bonus
+	if err = json.Unmarshal(encoded, &bonus); err != nil {
+		return nil, errors.NewNotValid(err, "invalid bonus")
+	}
+	return &bonus, nil
+}
+
+func geoString(props map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := props[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// importGeoJSON builds a MapRaw from a GeoJSON FeatureCollection. The sites
+// keep their "id" property, the sites without one get the next free IDs.
+func importGeoJSON(in geoCollection, proj Projection) (MapRaw, error) {
+	rawMap := makeRawMap()
+	rawMap.ID = in.Name
+
+	if err := proj.validate(); err != nil {
+		return rawMap, errors.Trace(err)
+	}
+	if in.Type != "FeatureCollection" {
+		return rawMap, errors.NotValidf("type %s", in.Type)
+	}
+
+	points := make([]geoFeature, 0)
+	lines := make([]geoFeature, 0)
+	coords := make(map[int][]float64)
+	paths := make(map[int][][]float64)
+	for i, f := range in.Features {
+		switch f.Geometry.Type {
+		case "Point":
+			var pos []float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &pos); err != nil {
+				return rawMap, errors.NewNotValid(err, "invalid point")
+			}
+			coords[len(points)] = pos
+			points = append(points, f)
+		case "LineString":
+			var path [][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &path); err != nil {
+				return rawMap, errors.NewNotValid(err, "invalid line")
+			}
+			if len(path) < 2 {
+				return rawMap, errors.NotValidf("line with %d positions", len(path))
+			}
+			paths[len(lines)] = path
+			lines = append(lines, f)
+		default:
+			utils.Logger.Warn().Int("feature", i).Str("type", f.Geometry.Type).Msg("geometry ignored")
+		}
+	}
+
+	if proj.Fit {
+		proj.Lon, proj.Lat = math.Inf(1), math.Inf(-1)
+		fit := func(pos []float64) {
+			if len(pos) >= 2 {
+				proj.Lon, proj.Lat = math.Min(proj.Lon, pos[0]), math.Max(proj.Lat, pos[1])
+			}
+		}
+		for _, pos := range coords {
+			fit(pos)
+		}
+		for _, path := range paths {
+			for _, pos := range path {
+				fit(pos)
+			}
+		}
+	}
+
+	// First the sites with an explicit ID, so that the other sites get IDs
+	// that do not collide.
+	var maxID uint64
+	ids := make([]uint64, len(points))
+	seen := make(map[uint64]bool)
+	for i, f := range points {
+		id, ok, err := geoID(f.Properties, "id")
+		if err != nil {
+			return rawMap, errors.Trace(err)
+		}
+		if ok {
+			if seen[id] {
+				return rawMap, errors.NotValidf("duplicated site %v", id)
+			}
+			seen[id] = true
+			ids[i] = id
+			if id > maxID {
+				maxID = id
+			}
+		}
+	}
+
+	byPosition := make(map[[2]uint64]uint64)
+	newSite := func(x, y uint64, city string) uint64 {
+		maxID++
+		rawMap.Sites = append(rawMap.Sites, SiteRaw{ID: maxID, X: x, Y: y, City: city})
+		byPosition[[2]uint64{x, y}] = maxID
+		seen[maxID] = true
+		return maxID
+	}
+	// A Point without ID is only designated by its position, that must be
+	// its own. The Points with an ID may share a position, that then cannot
+	// designate any of them.
+	named := make([]bool, len(points))
+	for i := range points {
+		named[i] = ids[i] != 0
+	}
+	crowds := make(map[[2]uint64][]int)
+	for i, f := range points {
+		x, y, err := proj.project(coords[i])
+		if err != nil {
+			return rawMap, errors.Trace(err)
+		}
+		pos := [2]uint64{x, y}
+		for _, j := range crowds[pos] {
+			if !named[i] || !named[j] {
+				return rawMap, errors.NotValidf("points %d and %d at the same position %v", j, i, coords[i])
+			}
+		}
+		crowds[pos] = append(crowds[pos], i)
+		city := geoString(f.Properties, "city", "name")
+		if ids[i] == 0 {
+			ids[i] = newSite(x, y, city)
+		} else {
+			rawMap.Sites = append(rawMap.Sites, SiteRaw{ID: ids[i], X: x, Y: y, City: city})
+			byPosition[pos] = ids[i]
+		}
+		site := &rawMap.Sites[len(rawMap.Sites)-1]
+		site.Terrain = mapgraph.Terrain(geoString(f.Properties, "terrain"))
+		if site.Bonus, err = geoBonus(f.Properties); err != nil {
+			return rawMap, errors.Annotatef(err, "point %d", i)
+		}
+	}
+	ambiguous := make(map[[2]uint64]bool)
+	for pos, crowd := range crowds {
+		if len(crowd) > 1 {
+			ambiguous[pos] = true
+			delete(byPosition, pos)
+		}
+	}
+
+	end := func(props map[string]interface{}, key string, pos []float64) (uint64, error) {
+		id, ok, err := geoID(props, key)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if ok {
+			if !seen[id] {
+				return 0, errors.NotValidf("no such site %v", id)
+			}
+			return id, nil
+		}
+		x, y, err := proj.project(pos)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if ambiguous[[2]uint64{x, y}] {
+			return 0, errors.NotValidf("several sites at %v", pos)
+		}
+		if id, ok := byPosition[[2]uint64{x, y}]; ok {
+			return id, nil
+		}
+		return 0, errors.NotValidf("no site at %v", pos)
+	}
+	for i, f := range lines {
+		path := paths[i]
+		src, err := end(f.Properties, "src", path[0])
+		if err != nil {
+			return rawMap, errors.Annotatef(err, "line %d", i)
+		}
+		dst, err := end(f.Properties, "dst", path[len(path)-1])
+		if err != nil {
+			return rawMap, errors.Annotatef(err, "line %d", i)
+		}
+		oneway, _ := f.Properties["oneway"].(bool)
+		attrs := RoadAttributes{Terrain: mapgraph.Terrain(geoString(f.Properties, "terrain"))}
+		if attrs.Cost, err = geoFloat(f.Properties, "cost"); err != nil {
+			return rawMap, errors.Annotatef(err, "line %d", i)
+		}
+
+		// The intermediate positions are crossroads
+		steps := []uint64{src}
+		for _, pos := range path[1 : len(path)-1] {
+			x, y, err := proj.project(pos)
+			if err != nil {
+				return rawMap, errors.Annotatef(err, "line %d", i)
+			}
+			if ambiguous[[2]uint64{x, y}] {
+				return rawMap, errors.NotValidf("line %d: several sites at %v", i, pos)
+			}
+			id, ok := byPosition[[2]uint64{x, y}]
+			if !ok {
+				id = newSite(x, y, "")
+			}
+			steps = append(steps, id)
+		}
+		steps = append(steps, dst)
+
+		for j, d := range steps[1:] {
+			s := steps[j]
+			rawMap.Roads = append(rawMap.Roads, RoadRaw{Src: s, Dst: d, RoadAttributes: attrs})
+			if !oneway {
+				rawMap.Roads = append(rawMap.Roads, RoadRaw{Src: d, Dst: s, RoadAttributes: attrs})
+			}
+		}
+	}
+
+	sort.Slice(rawMap.Sites, func(i, j int) bool { return rawMap.Sites[i].ID < rawMap.Sites[j].ID })
+	sort.Slice(rawMap.Roads, func(i, j int) bool {
+		ri, rj := rawMap.Roads[i], rawMap.Roads[j]
+		return ri.Src < rj.Src || (ri.Src == rj.Src && ri.Dst < rj.Dst)
+	})
+	return rawMap, nil
+}
+
+// exportGeoJSON builds a GeoJSON FeatureCollection from a MapRaw. Each site
+// becomes a Point, and each pair of roads in both directions becomes a single
+// LineString. The roads without their reverse, or whose reverse has other
+// attributes, become "oneway" LineStrings.
+func exportGeoJSON(m MapRaw, proj Projection) (geoCollection, error) {
+	out := geoCollection{Type: "FeatureCollection", Name: m.ID, Features: make([]geoFeature, 0)}
+	if err := proj.validate(); err != nil {
+		return out, errors.Trace(err)
+	}
+
+	geometry := func(kind string, coords interface{}) geoGeometry {
+		encoded, _ := json.Marshal(coords)
+		return geoGeometry{Type: kind, Coordinates: encoded}
+	}
+
+	sites := make(map[uint64]SiteRaw)
+	for _, s := range m.Sites {
+		sites[s.ID] = s
+		props := map[string]interface{}{"id": s.ID}
+		if s.City != "" {
+			props["city"] = s.City
+		}
+		if s.Terrain != "" {
+			props["terrain"] = s.Terrain
+		}
+		if s.Bonus != nil {
+			props["bonus"] = s.Bonus
+		}
+		out.Features = append(out.Features, geoFeature{
+			Type:       "Feature",
+			Geometry:   geometry("Point", proj.unproject(s.X, s.Y)),
+			Properties: props,
+		})
+	}
+
+	roads := make(map[RoadRaw]RoadAttributes)
+	for _, r := range m.Roads {
+		roads[r.ends()] = r.RoadAttributes
+	}
+	done := make(map[RoadRaw]bool)
+	for _, r := range m.Roads {
+		if done[r.ends()] {
+			continue
+		}
+		src, ok := sites[r.Src]
+		if !ok {
+			return out, errors.NotValidf("no such source %v", r.Src)
+		}
+		dst, ok := sites[r.Dst]
+		if !ok {
+			return out, errors.NotValidf("no such destination %v", r.Dst)
+		}
+		reverse := RoadRaw{Src: r.Dst, Dst: r.Src}
+		props := map[string]interface{}{"src": r.Src, "dst": r.Dst}
+		if attrs, ok := roads[reverse]; ok && attrs == r.RoadAttributes {
+			done[reverse] = true
+		} else {
+			props["oneway"] = true
+		}
+		done[r.ends()] = true
+		if r.Terrain != "" {
+			props["terrain"] = r.Terrain
+		}
+		if r.Cost != 0 {
+			props["cost"] = r.Cost
+		}
+		out.Features = append(out.Features, geoFeature{
+			Type:       "Feature",
+			Geometry:   geometry("LineString", [][]float64{proj.unproject(src.X, src.Y), proj.unproject(dst.X, dst.Y)}),
+			Properties: props,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/juju/errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// geoRoundTrip exports the map then imports it back, through its JSON
+// encoding as the tools do.
+func geoRoundTrip(t *testing.T, m MapRaw, out, in Projection) MapRaw {
+	t.Helper()
+	exported, err := exportGeoJSON(m, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded geoCollection
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err = decoder.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := importGeoJSON(decoded, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imported
+}
+
+func TestGeoJSONRoundTrip(t *testing.T) {
+	m := makeRawMap()
+	m.ID = "geo"
+	m.Sites = []SiteRaw{
+		{ID: 3, X: 0, Y: 0, City: "Aster"},
+		{ID: 7, X: 40, Y: 8, City: "Borage", SiteAttributes: SiteAttributes{Terrain: mapgraph.TerrainForest}},
+		{ID: 10, X: 12, Y: 36, SiteAttributes: SiteAttributes{Bonus: &mapgraph.Bonus{Mult: []float64{1.5, 1}, Plus: []int64{0, 2}}}},
+		{ID: 12, X: 64, Y: 40, City: "Cumin", SiteAttributes: SiteAttributes{Terrain: mapgraph.TerrainMountain}},
+	}
+	m.Roads = []RoadRaw{
+		{Src: 3, Dst: 7, RoadAttributes: RoadAttributes{Terrain: mapgraph.TerrainFord}},
+		{Src: 7, Dst: 3, RoadAttributes: RoadAttributes{Terrain: mapgraph.TerrainFord}},
+		// One-way roads, including a pair with different attributes
+		{Src: 7, Dst: 10},
+		{Src: 10, Dst: 3, RoadAttributes: RoadAttributes{Cost: 2.5}},
+		{Src: 10, Dst: 12, RoadAttributes: RoadAttributes{Cost: 1}},
+		{Src: 12, Dst: 10, RoadAttributes: RoadAttributes{Cost: 3}},
+	}
+
+	for _, proj := range []Projection{
+		{Lon: 0, Lat: 0, Scale: 1},
+		{Lon: 2.25, Lat: 48.75, Scale: 64},
+	} {
+		if got := geoRoundTrip(t, m, proj, proj); !reflect.DeepEqual(got, m) {
+			t.Fatalf("projection %v: got %+v expected %+v", proj, got, m)
+		}
+	}
+
+	// A fitted projection finds the origin of the map back, since a site
+	// lies at the top-left corner.
+	fit := Projection{Scale: 64, Fit: true}
+	if got := geoRoundTrip(t, m, Projection{Lon: 2.25, Lat: 48.75, Scale: 64}, fit); !reflect.DeepEqual(got, m) {
+		t.Fatalf("got %+v expected %+v", got, m)
+	}
+}
+
+func decodeGeo(t *testing.T, encoded string) geoCollection {
+	t.Helper()
+	var in geoCollection
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&in); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestGeoJSONImport(t *testing.T) {
+	proj := Projection{Scale: 1}
+	point := func(x, y int, props string) string {
+		return fmt.Sprintf(`{"type":"Feature","geometry":{"type":"Point","coordinates":[%d,%d]},"properties":{%s}}`, x, -y, props)
+	}
+	line := func(props string, coords ...int) string {
+		path := make([]string, 0)
+		for i := 0; i < len(coords); i += 2 {
+			path = append(path, fmt.Sprintf("[%d,%d]", coords[i], -coords[i+1]))
+		}
+		return fmt.Sprintf(`{"type":"Feature","geometry":{"type":"LineString","coordinates":[%s]},"properties":{%s}}`,
+			strings.Join(path, ","), props)
+	}
+	collection := func(features ...string) geoCollection {
+		return decodeGeo(t, `{"type":"FeatureCollection","name":"m","features":[`+strings.Join(features, ",")+`]}`)
+	}
+
+	// The sites without ID get the next free IDs, the ends of the lines are
+	// found by ID (possibly textual) or by position, and the intermediate
+	// positions become crossroads.
+	m, err := importGeoJSON(collection(
+		point(0, 0, `"id":"5","name":"Aster"`),
+		point(10, 0, `"city":"Borage"`),
+		line(`"src":5,"oneway":true`, 0, 0, 5, 5, 10, 0),
+	), proj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MapRaw{
+		ID:    "m",
+		Sites: []SiteRaw{{ID: 5, City: "Aster"}, {ID: 6, X: 10, City: "Borage"}, {ID: 7, X: 5, Y: 5}},
+		Roads: []RoadRaw{{Src: 5, Dst: 7}, {Src: 7, Dst: 6}},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("got %+v expected %+v", m, expected)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		features []string
+	}{
+		{"point without ID on a point", []string{point(0, 0, `"id":1`), point(0, 0, ``)}},
+		{"point on a point without ID", []string{point(0, 0, ``), point(0, 0, `"id":1`)}},
+		{"duplicated ID", []string{point(0, 0, `"id":1`), point(1, 1, `"id":1`)}},
+		{"ambiguous end", []string{point(0, 0, `"id":1`), point(0, 0, `"id":2`), point(5, 5, `"id":3`), line(``, 0, 0, 5, 5)}},
+		{"unknown end", []string{point(0, 0, `"id":1`), line(`"dst":9`, 0, 0, 5, 5)}},
+		{"no site at the end", []string{point(0, 0, `"id":1`), line(``, 0, 0, 5, 5)}},
+	} {
+		if _, err := importGeoJSON(collection(tc.features...), proj); !errors.IsNotValid(err) {
+			t.Fatal(tc.name, "unexpected error", err)
+		}
+	}
+
+	// Sites with IDs may share a position, when the lines name their ends
+	if _, err = importGeoJSON(collection(
+		point(0, 0, `"id":1`), point(0, 0, `"id":2`), line(`"src":1,"dst":2`, 0, 0, 0, 0),
+	), proj); err != nil {
+		t.Fatal(err)
+	}
+}
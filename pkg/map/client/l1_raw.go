@@ -6,9 +6,24 @@
 package mapclient
 
 import (
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
 	"github.com/juju/errors"
 )
 
+// SiteAttributes are the optional properties of a site, carried as is
+// to the vertices of the map.
+type SiteAttributes struct {
+	Terrain mapgraph.Terrain `json:"terrain,omitempty"`
+	Bonus   *mapgraph.Bonus  `json:"bonus,omitempty"`
+}
+
+// RoadAttributes are the optional properties of a road, carried as is
+// to the edges of the map.
+type RoadAttributes struct {
+	Terrain mapgraph.Terrain `json:"terrain,omitempty"`
+	Cost    float64          `json:"cost,omitempty"`
+}
+
 // SiteRaw is the information of a node in the graph representation on a map by MapRaw.
 type SiteRaw struct {
 	ID uint64 `json:"id"`
@@ -17,12 +32,20 @@ type SiteRaw struct {
 	// City is the name of the city that should exist at the instanciation a region based on the current map.
 	// The presence of a City is achieved by a non-empty string in the City field, that will be the name of the city
 	City string `json:"city"`
+	SiteAttributes
 }
 
 // RoadRaw is a minimal repreentation of a directional road on the map.
 type RoadRaw struct {
 	Src uint64 `json:"src"`
 	Dst uint64 `json:"dst"`
+	RoadAttributes
+}
+
+// ends returns the road stripped of its attributes, e.g. to be used as the
+// key of a set of roads.
+func (r RoadRaw) ends() RoadRaw {
+	return RoadRaw{Src: r.Src, Dst: r.Dst}
 }
 
 // MapRaw is a human-unfriendly representation of a Map
@@ -48,9 +71,13 @@ func (mr *MapRaw) extractMemMap() (mapMem, error) {
 	memMap := makeMemMap()
 	memMap.ID = mr.ID
 	for _, s := range mr.Sites {
+		// The new sites (e.g. upon a split) get IDs above the existing ones
+		if s.ID > memMap.nextID {
+			memMap.nextID = s.ID
+		}
 		memMap.Sites[s.ID] = &siteMem{
 			Raw:   s,
-			Peers: make(map[*siteMem]bool),
+			Peers: make(map[*siteMem]RoadAttributes),
 		}
 	}
 	for _, r := range mr.Roads {
@@ -62,7 +89,7 @@ func (mr *MapRaw) extractMemMap() (mapMem, error) {
 			break
 		} else {
 			// raw maps are digraphs, mem maps are digraphs... no need to duplicate any road
-			src.Peers[dst] = true
+			src.Peers[dst] = r.RoadAttributes
 		}
 	}
 	return memMap, err
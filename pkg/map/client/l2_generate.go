@@ -0,0 +1,221 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"github.com/juju/errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// GenerateArgs gathers the parameters of the procedural generation of a map
+type GenerateArgs struct {
+	// ID is the name of the map
+	ID string
+	// Cities is the number of sites that carry a city
+	Cities uint
+	// Density is the ratio of cities among the sites, in ]0,1].
+	// With 1, every site carries a city. With 0.25, there are 3 crossroads per city.
+	Density float64
+	// Width and Height are the bounds of the positions of the sites
+	Width, Height uint64
+	// Seed initiates the random generator, the same seed always produces the same map
+	Seed int64
+}
+
+func (args GenerateArgs) validate() error {
+	if args.ID == "" {
+		return errors.NotValidf("empty map name")
+	}
+	if args.Cities < 1 {
+		return errors.NotValidf("no city")
+	}
+	if args.Density <= 0 || args.Density > 1 {
+		return errors.NotValidf("density %v not in ]0,1]", args.Density)
+	}
+	if args.Width < 1 || args.Height < 1 {
+		return errors.NotValidf("empty box %vx%v", args.Width, args.Height)
+	}
+	if nb := args.sites(); uint64(nb) > (args.Width+1)*(args.Height+1) {
+		return errors.NotValidf("%v sites won't fit in the box %vx%v", nb, args.Width, args.Height)
+	}
+	return nil
+}
+
+func (args GenerateArgs) sites() uint {
+	return uint(math.Ceil(float64(args.Cities) / args.Density))
+}
+
+// generateMemMap builds a map whose sites are randomly scattered in the box,
+// then linked with the roads of their relative neighborhood graph. That graph
+// is planar and connected, and its roads are bidirectional, so that any site
+// is reachable from any other.
+// The cities are then spread on the sites, as far as possible from each other.
+func generateMemMap(args GenerateArgs) (mapMem, error) {
+	if err := args.validate(); err != nil {
+		return mapMem{}, err
+	}
+
+	rng := rand.New(rand.NewSource(args.Seed))
+	m := makeMemMap()
+	m.ID = args.ID
+
+	sites := scatterSites(rng, args.sites(), args.Width, args.Height)
+	for _, s := range sites {
+		m.nextID++
+		s.Raw.ID = m.nextID
+		m.Sites[s.Raw.ID] = s
+	}
+	linkNeighbors(sites)
+	nameCities(rng, sites, args.Cities)
+	return m, nil
+}
+
+// scatterSites places the sites at distinct positions in the box, and tries to
+// keep a minimal spacing between them so that the sites don't pile up. The
+// spacing is relaxed when the box is too crowded to place a site.
+func scatterSites(rng *rand.Rand, nb uint, width, height uint64) []*siteMem {
+	const attempts = 32
+	spacing := 0.5 * math.Sqrt(float64(width*height)/float64(nb))
+
+	out := make([]*siteMem, 0, nb)
+	taken := make(map[[2]uint64]bool)
+	for uint(len(out)) < nb {
+		for i := 0; i < attempts; i++ {
+			s := makeSite(SiteRaw{
+				X: uint64(rng.Int63n(int64(width) + 1)),
+				Y: uint64(rng.Int63n(int64(height) + 1)),
+			})
+			if taken[[2]uint64{s.Raw.X, s.Raw.Y}] {
+				continue
+			}
+			if !tooClose(s, out, spacing) {
+				taken[[2]uint64{s.Raw.X, s.Raw.Y}] = true
+				out = append(out, s)
+				break
+			}
+		}
+		// Whether a site has been placed or not, the next site will be
+		// placed with a lower constraint.
+		spacing *= 0.95
+	}
+	return out
+}
+
+func tooClose(s *siteMem, sites []*siteMem, spacing float64) bool {
+	for _, other := range sites {
+		if distance(s, other) < spacing {
+			return true
+		}
+	}
+	return false
+}
+
+// linkNeighbors adds a bidirectional road between each pair of sites (p,q)
+// such that no other site is closer to both p and q than p and q are to each
+// other. This is the relative neighborhood graph of the sites: it contains
+// their minimum spanning tree, so it is connected.
+// The naive computation is cubic, that remains fast enough with the few
+// hundreds of sites of a playable map.
+func linkNeighbors(sites []*siteMem) {
+	for i, p := range sites {
+		// The candidate witnesses for the pair (p,q) are closer to p than q is,
+		// thus they are found before q when sorting the sites by distance to p.
+		others := make([]*siteMem, 0, len(sites)-1)
+		for j, q := range sites {
+			if i != j {
+				others = append(others, q)
+			}
+		}
+		sort.Slice(others, func(a, b int) bool {
+			return distance(p, others[a]) < distance(p, others[b])
+		})
+
+		for k, q := range others {
+			if _, ok := q.Peers[p]; ok {
+				continue
+			}
+			pq := distance(p, q)
+			linked := true
+			for _, r := range others[:k] {
+				if distance(p, r) < pq && distance(q, r) < pq {
+					linked = false
+					break
+				}
+			}
+			if linked {
+				p.Peers[q] = RoadAttributes{}
+				q.Peers[p] = RoadAttributes{}
+			}
+		}
+	}
+}
+
+// nameCities chooses the sites that carry a city and names them. The first
+// city is randomly placed, then each city is placed on the site the farthest
+// from the cities already placed.
+func nameCities(rng *rand.Rand, sites []*siteMem, nb uint) {
+	if nb < 1 || len(sites) < 1 {
+		return
+	}
+
+	// Distance to the closest city, for each site
+	closest := make([]float64, len(sites))
+	for i := range closest {
+		closest[i] = math.Inf(1)
+	}
+
+	names := make(map[string]bool)
+	next := rng.Intn(len(sites))
+	for placed := uint(0); placed < nb; placed++ {
+		city := sites[next]
+		city.Raw.City = cityName(rng, names)
+
+		next = -1
+		for i, s := range sites {
+			if d := distance(s, city); d < closest[i] {
+				closest[i] = d
+			}
+			if s.Raw.City != "" {
+				continue
+			}
+			if next < 0 || closest[i] > closest[next] {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+	}
+}
+
+var (
+	nameOnsets = []string{"b", "c", "d", "f", "g", "k", "l", "m", "n", "p", "r", "s", "t", "v", "z", "br", "ch", "dr", "gr", "qu", "st", "th", "tr"}
+	nameNuclei = []string{"a", "e", "i", "o", "u", "ai", "au", "ea", "ia", "ou", "y"}
+	nameCodas  = []string{"", "", "", "n", "r", "s", "l", "m", "nd", "rk", "st", "x"}
+)
+
+// cityName returns a pronounceable name, not in the given set, then adds it to the set.
+func cityName(rng *rand.Rand, names map[string]bool) string {
+	pick := func(tab []string) string { return tab[rng.Intn(len(tab))] }
+	for syllables := 2; ; syllables++ {
+		for attempt := 0; attempt < 8; attempt++ {
+			var sb strings.Builder
+			for i := 0; i < syllables; i++ {
+				sb.WriteString(pick(nameOnsets))
+				sb.WriteString(pick(nameNuclei))
+			}
+			sb.WriteString(pick(nameCodas))
+			name := strings.Title(sb.String())
+			if !names[name] {
+				names[name] = true
+				return name
+			}
+		}
+	}
+}
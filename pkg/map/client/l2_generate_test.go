@@ -0,0 +1,105 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"reflect"
+	"testing"
+)
+
+// loadGraph loads the map as the map service would, i.e. with all its checks
+func loadGraph(t *testing.T, raw MapRaw) *mapgraph.Map {
+	t.Helper()
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mapgraph.NewMap()
+	if err = m.Load(bytes.NewReader(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func generateRawMap(t *testing.T, args GenerateArgs) MapRaw {
+	t.Helper()
+	m, err := generateMemMap(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.extractRawMap()
+}
+
+func TestGenerate(t *testing.T) {
+	for _, args := range []GenerateArgs{
+		{ID: "one", Cities: 1, Density: 1, Width: 10, Height: 10, Seed: 1},
+		{ID: "dense", Cities: 20, Density: 1, Width: 100, Height: 100, Seed: 2},
+		{ID: "sparse", Cities: 30, Density: 0.25, Width: 1000, Height: 500, Seed: 3},
+		{ID: "tight", Cities: 16, Density: 0.5, Width: 5, Height: 5, Seed: 4},
+	} {
+		raw := generateRawMap(t, args)
+		m := loadGraph(t, raw)
+
+		if uint(m.Cells.Len()) != args.sites() {
+			t.Fatal(args.ID, "sites", m.Cells.Len(), "expected", args.sites())
+		}
+		cities := make(map[string]bool)
+		for _, v := range m.Cells {
+			if v.City != "" {
+				if cities[v.City] {
+					t.Fatal(args.ID, "duplicated city", v.City)
+				}
+				cities[v.City] = true
+			}
+			if v.X > args.Width || v.Y > args.Height {
+				t.Fatal(args.ID, "site", v.ID, "out of the box")
+			}
+		}
+		if uint(len(cities)) != args.Cities {
+			t.Fatal(args.ID, "cities", len(cities), "expected", args.Cities)
+		}
+		// The roads are bidirectional
+		for _, r := range m.Roads {
+			if !m.RoadHas(r.D, r.S) {
+				t.Fatal(args.ID, "one-way road", r.S, r.D)
+			}
+		}
+	}
+}
+
+func TestGenerateSeed(t *testing.T) {
+	args := GenerateArgs{ID: "seeded", Cities: 25, Density: 0.5, Width: 200, Height: 200, Seed: 42}
+	// The loaded maps are canonical, whatever the order of the roads
+	same := func(m0, m1 *mapgraph.Map) bool {
+		return reflect.DeepEqual(m0.Cells, m1.Cells) && reflect.DeepEqual(m0.Roads, m1.Roads)
+	}
+	m0 := loadGraph(t, generateRawMap(t, args))
+	if m1 := loadGraph(t, generateRawMap(t, args)); !same(m0, m1) {
+		t.Fatal("the same seed produced different maps")
+	}
+	args.Seed++
+	if m1 := loadGraph(t, generateRawMap(t, args)); same(m0, m1) {
+		t.Fatal("different seeds produced the same map")
+	}
+}
+
+func TestGenerateArgs(t *testing.T) {
+	for _, args := range []GenerateArgs{
+		{Cities: 1, Density: 1, Width: 10, Height: 10},
+		{ID: "x", Cities: 0, Density: 1, Width: 10, Height: 10},
+		{ID: "x", Cities: 1, Density: 0, Width: 10, Height: 10},
+		{ID: "x", Cities: 1, Density: 1.5, Width: 10, Height: 10},
+		{ID: "x", Cities: 1, Density: 1, Width: 0, Height: 10},
+		{ID: "x", Cities: 10, Density: 0.1, Width: 3, Height: 3},
+	} {
+		if _, err := generateMemMap(args); err == nil {
+			t.Fatal("unexpected success with", args)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package mapclient
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"sort"
@@ -15,11 +16,12 @@ import (
 
 type siteMem struct {
 	Raw   SiteRaw
-	Peers map[*siteMem]bool
+	Peers map[*siteMem]RoadAttributes
 }
 
 type roadMem struct {
 	Src, Dst *siteMem
+	Attrs    RoadAttributes
 }
 
 // Human-unfriendly representation of a Map
@@ -41,7 +43,7 @@ func makeMemMap() mapMem {
 func makeSite(raw SiteRaw) *siteMem {
 	return &siteMem{
 		Raw:   raw,
-		Peers: make(map[*siteMem]bool),
+		Peers: make(map[*siteMem]RoadAttributes),
 	}
 }
 
@@ -57,11 +59,11 @@ func (m *mapMem) uniqueRoads() <-chan roadMem {
 	go func() {
 		seen := make(map[RoadRaw]bool)
 		for _, s := range m.Sites {
-			for peer := range s.Peers {
+			for peer, attrs := range s.Peers {
 				r0 := RoadRaw{Src: s.Raw.ID, Dst: peer.Raw.ID}
 				if !seen[r0] {
 					seen[r0] = true
-					out <- roadMem{s, peer}
+					out <- roadMem{Src: s, Dst: peer, Attrs: attrs}
 				}
 			}
 		}
@@ -86,15 +88,29 @@ func (m *mapMem) sortedSites() <-chan *siteMem {
 	return out
 }
 
-// Produces a MapRaw with sorted sites and unique roads.
+// sortedRoads returns the unique roads sorted by source then destination,
+// so that the processing of the roads is reproducible.
+func (m *mapMem) sortedRoads() []roadMem {
+	roads := make([]roadMem, 0)
+	for r := range m.uniqueRoads() {
+		roads = append(roads, r)
+	}
+	sort.Slice(roads, func(i, j int) bool {
+		ri, rj := roads[i], roads[j]
+		return ri.Src.Raw.ID < rj.Src.Raw.ID || (ri.Src.Raw.ID == rj.Src.Raw.ID && ri.Dst.Raw.ID < rj.Dst.Raw.ID)
+	})
+	return roads
+}
+
+// Produces a MapRaw with sorted sites and sorted unique roads.
 func (m *mapMem) extractRawMap() MapRaw {
 	rawMap := makeRawMap()
 	rawMap.ID = m.ID
 	for s := range m.sortedSites() {
 		rawMap.Sites = append(rawMap.Sites, s.Raw)
 	}
-	for r := range m.uniqueRoads() {
-		rawRoad := RoadRaw{Src: r.Src.Raw.ID, Dst: r.Dst.Raw.ID}
+	for _, r := range m.sortedRoads() {
+		rawRoad := RoadRaw{Src: r.Src.Raw.ID, Dst: r.Dst.Raw.ID, RoadAttributes: r.Attrs}
 		rawMap.Roads = append(rawMap.Roads, rawRoad)
 	}
 	return rawMap
@@ -102,15 +118,19 @@ func (m *mapMem) extractRawMap() MapRaw {
 
 func (m *mapMem) deepCopy() mapMem {
 	mFinal := makeMemMap()
+	mFinal.ID, mFinal.nextID = m.ID, m.nextID
 	for id, site := range m.Sites {
 		mFinal.Sites[id] = makeSite(site.Raw)
 	}
 	for _, s := range m.Sites {
 		src := mFinal.Sites[s.Raw.ID]
-		for d := range s.Peers {
+		for d, attrs := range s.Peers {
 			dst := mFinal.Sites[d.Raw.ID]
-			src.Peers[dst] = true
-			dst.Peers[src] = true
+			src.Peers[dst] = attrs
+			// The road in the opposite direction keeps its own attributes
+			if _, ok := dst.Peers[src]; !ok {
+				dst.Peers[src] = attrs
+			}
 		}
 	}
 	return mFinal
@@ -160,10 +180,19 @@ func (m *mapMem) resizeRatio(xratio, yratio float64) {
 	}
 }
 
+// resizeStretch scales each axis independently. An axis without extent,
+// e.g. when all the sites share the same X, is not scaled.
 func (m *mapMem) resizeStretch(x, y float64) {
 	m.shiftAt(0, 0)
 	_, xmax, _, ymax := m.computeBox()
-	m.resizeRatio(x/float64(xmax), y/float64(ymax))
+	xRatio, yRatio := 1.0, 1.0
+	if xmax > 0 {
+		xRatio = x / float64(xmax)
+	}
+	if ymax > 0 {
+		yRatio = y / float64(ymax)
+	}
+	m.resizeRatio(xRatio, yRatio)
 }
 
 func (m *mapMem) resizeAndAdjust(x, y uint64) {
@@ -172,6 +201,10 @@ func (m *mapMem) resizeAndAdjust(x, y uint64) {
 	xRatio := float64(x) / float64(xmax)
 	yRatio := float64(y) / float64(ymax)
 	ratio := math.Min(xRatio, yRatio)
+	if math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		// All the sites are at the same position
+		ratio = 1
+	}
 	m.resizeRatio(ratio, ratio)
 }
 
@@ -187,18 +220,22 @@ func (m *mapMem) splitOneRoad(src, dst *siteMem, nbSegments uint) {
 		panic("bug")
 	}
 
-	xinc := uint64(math.Round(float64(dst.Raw.X-src.Raw.X) / float64(nbSegments)))
-	yinc := uint64(math.Round(float64(dst.Raw.Y-src.Raw.Y) / float64(nbSegments)))
+	// The increments are signed, the road may go toward the origin
+	xinc := (float64(dst.Raw.X) - float64(src.Raw.X)) / float64(nbSegments)
+	yinc := (float64(dst.Raw.Y) - float64(src.Raw.Y)) / float64(nbSegments)
 	segments := make([]*siteMem, 0, nbSegments+1)
 
+	// Each direction of the road keeps its attributes on all the segments
+	forth, hasForth := src.Peers[dst]
+	back, hasBack := dst.Peers[src]
 	delete(src.Peers, dst)
 	delete(dst.Peers, src)
 
 	// Create segment boundaries
 	segments = append(segments, src)
-	for i := uint(0); i < nbSegments-1; i++ {
-		last := segments[len(segments)-1]
-		x, y := last.Raw.X+xinc, last.Raw.Y+yinc
+	for i := uint(1); i < nbSegments; i++ {
+		x := uint64(math.Round(float64(src.Raw.X) + float64(i)*xinc))
+		y := uint64(math.Round(float64(src.Raw.Y) + float64(i)*yinc))
 		id := atomic.AddUint64(&m.nextID, 1)
 		raw := SiteRaw{ID: id, City: "", X: x, Y: y}
 		middle := makeSite(raw)
@@ -210,17 +247,27 @@ func (m *mapMem) splitOneRoad(src, dst *siteMem, nbSegments uint) {
 	// Link the segment boundaries
 	for i, end := range segments[1:] {
 		start := segments[i]
-		start.Peers[end] = true
-		end.Peers[start] = true
+		if hasForth {
+			start.Peers[end] = forth
+		}
+		if hasBack {
+			end.Peers[start] = back
+		}
 	}
 }
 
 func (m *mapMem) splitLongRoads(max float64) mapMem {
 	// Work on a deep copy to iterate on the original map while we alter the copy
 	mCopy := m.deepCopy()
-	for r := range m.uniqueRoads() {
+	for _, r := range m.sortedRoads() {
 		src := mCopy.Sites[r.Src.Raw.ID]
 		dst := mCopy.Sites[r.Dst.Raw.ID]
+		// The road in the opposite direction has already been split
+		_, forth := src.Peers[dst]
+		_, back := dst.Peers[src]
+		if !forth && !back {
+			continue
+		}
 		dist := distance(src, dst)
 		if max < dist {
 			mCopy.splitOneRoad(src, dst, uint(math.Ceil(dist/max)))
@@ -229,14 +276,39 @@ func (m *mapMem) splitLongRoads(max float64) mapMem {
 	return mCopy
 }
 
-func (m *mapMem) applyNoiseOnPositions(xjitter, yjitter float64) {
-	for _, s := range m.Sites {
+// applyNoise moves the sites without city by up to the given percent of the
+// dimensions of the map.
+func (m *mapMem) applyNoise(rng *rand.Rand, percent float64) {
+	if percent <= 0 {
+		return
+	}
+	xmin, xmax, ymin, ymax := m.computeBox()
+	m.applyNoiseOnPositions(rng, float64(xmax-xmin)*(percent/100), float64(ymax-ymin)*(percent/100))
+}
+
+// applyNoiseOnPositions moves the sites without city by a random offset.
+// The sites are processed in the order of their IDs, so that a seeded rng
+// always produces the same map. The positions never go below zero.
+func (m *mapMem) applyNoiseOnPositions(rng *rand.Rand, xjitter, yjitter float64) {
+	jitter := func(pos uint64, amplitude float64) uint64 {
+		return uint64(math.Max(0, math.Round(float64(pos)+(0.5-rng.Float64())*amplitude)))
+	}
+	for s := range m.sortedSites() {
 		if s.Raw.City != "" {
 			continue
 		}
-		s.Raw.X += uint64(math.Round((0.5 - rand.Float64()) * xjitter))
-		s.Raw.Y += uint64(math.Round((0.5 - rand.Float64()) * yjitter))
+		s.Raw.X = jitter(s.Raw.X, xjitter)
+		s.Raw.Y = jitter(s.Raw.Y, yjitter)
+	}
+}
+
+// writeDot writes a dot representation (cf. graphviz.org) of the map
+func (m *mapMem) writeDot(w io.Writer) {
+	fmt.Fprintln(w, "graph g {")
+	for _, r := range m.sortedRoads() {
+		fmt.Fprintf(w, "%s -- %s;\n", r.Src.getDotName(), r.Dst.getDotName())
 	}
+	fmt.Fprintln(w, "}")
 }
 
 func distance(src, dst *siteMem) float64 {
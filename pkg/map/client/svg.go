@@ -0,0 +1,153 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"github.com/juju/errors"
+	"html"
+	"io"
+	"math"
+)
+
+// SvgArgs tells how to draw a map in SVG
+type SvgArgs struct {
+	CanvasArgs
+	// Labels writes the name of the city next to each city
+	Labels bool
+	// Weights writes the length of each road at its middle
+	Weights bool
+	// Classes adds CSS classes to each vertex: "site", then either "city"
+	// or "crossroad", then "site-<ID>", then "terrain-<terrain>" when the
+	// terrain is set, so that a stylesheet can target any vertex.
+	Classes bool
+}
+
+// SvgDecoration alters the drawing of a map, e.g. to represent the state of
+// a region on top of its map.
+type SvgDecoration struct {
+	// Fill overrides the color of the sites, by site ID
+	Fill map[uint64]string
+	// Class adds CSS classes to the sites, by site ID
+	Class map[uint64]string
+	// Draw writes additional SVG elements on top of the map. The position
+	// of a site on the canvas is given by pos.
+	Draw func(w io.Writer, pos func(id uint64) (x, y int64, ok bool))
+}
+
+// RenderSvg writes to w a SVG representation of the map, fit in the canvas
+// and decorated as told.
+func RenderSvg(w io.Writer, raw MapRaw, args SvgArgs, deco SvgDecoration) error {
+	if err := args.validate(); err != nil {
+		return errors.Trace(err)
+	}
+	m, err := raw.extractMemMap()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// The lengths are computed before the positions are remapped, so
+	// that they match the lengths used by the map service.
+	lengths := make(map[RoadRaw]uint64)
+	if args.Weights {
+		for _, r := range m.sortedRoads() {
+			edge := mapgraph.Edge{Terrain: r.Attrs.Terrain, Cost: r.Attrs.Cost}
+			dst := mapgraph.Vertex{Terrain: r.Dst.Raw.Terrain}
+			w := uint64(math.Round(siteDistance(r.Src.Raw, r.Dst.Raw) * edge.Multiplier(&dst)))
+			if w < 1 {
+				w = 1
+			}
+			lengths[RoadRaw{Src: r.Src.Raw.ID, Dst: r.Dst.Raw.ID}] = w
+		}
+	}
+
+	args.apply(&m)
+
+	xbound, ybound := args.Width, args.Height
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg xmlns="http://www.w3.org/2000/svg"
+	style="background-color: rgb(255, 255, 255);"
+	xmlns:xlink="http://www.w3.org/1999/xlink"
+	version="1.1"
+	width="%dpx" height="%dpx"
+	viewBox="-0.5 -0.5 %d %d">
+`, int64(xbound), int64(ybound), int64(xbound), int64(ybound))
+	fmt.Fprintln(w, `<g>`)
+	for _, r := range m.sortedRoads() {
+		fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black" stroke-width="1"/>
+`, int64(r.Src.Raw.X), int64(r.Src.Raw.Y), int64(r.Dst.Raw.X), int64(r.Dst.Raw.Y))
+	}
+	fmt.Fprintln(w, `</g>`)
+	if args.Weights {
+		fmt.Fprintln(w, `<g font-family="sans-serif" font-size="10" text-anchor="middle">`)
+		for _, r := range m.sortedRoads() {
+			forth := RoadRaw{Src: r.Src.Raw.ID, Dst: r.Dst.Raw.ID}
+			back := RoadRaw{Src: r.Dst.Raw.ID, Dst: r.Src.Raw.ID}
+			// A single label for the roads in both directions
+			if _, ok := lengths[back]; ok && back.Src < forth.Src {
+				continue
+			}
+			x, y := (int64(r.Src.Raw.X)+int64(r.Dst.Raw.X))/2, (int64(r.Src.Raw.Y)+int64(r.Dst.Raw.Y))/2
+			fmt.Fprintf(w, `<text x="%d" y="%d">%d</text>
+`, x, y, lengths[forth])
+		}
+		fmt.Fprintln(w, `</g>`)
+	}
+	fmt.Fprintln(w, `<g>`)
+	for s := range m.sortedSites() {
+		color := `white`
+		radius := 5
+		stroke := 1
+		class := `clickable`
+		if s.Raw.City != "" {
+			color = `gray`
+			radius = 10
+			stroke = 1
+		}
+		if fill, ok := deco.Fill[s.Raw.ID]; ok {
+			color = fill
+		}
+		if args.Classes {
+			kind := `crossroad`
+			if s.Raw.City != "" {
+				kind = `city`
+			}
+			class = fmt.Sprintf(`%s site %s site-%d`, class, kind, s.Raw.ID)
+			if s.Raw.Terrain != "" {
+				class = fmt.Sprintf(`%s terrain-%s`, class, s.Raw.Terrain)
+			}
+		}
+		if extra, ok := deco.Class[s.Raw.ID]; ok {
+			class = class + " " + extra
+		}
+		fmt.Fprintf(w, `<circle id="%v" class="%s" cx="%d" cy="%d" r="%d" stroke="black" stroke-width="%d" fill="%s"/>
+`, s.Raw.ID, class, int64(s.Raw.X), int64(s.Raw.Y), radius, stroke, color)
+	}
+	fmt.Fprintln(w, `</g>`)
+	if args.Labels {
+		fmt.Fprintln(w, `<g font-family="sans-serif" font-size="14" text-anchor="middle">`)
+		for s := range m.sortedSites() {
+			if s.Raw.City != "" {
+				fmt.Fprintf(w, `<text x="%d" y="%d">%s</text>
+`, int64(s.Raw.X), int64(s.Raw.Y)-14, html.EscapeString(s.Raw.City))
+			}
+		}
+		fmt.Fprintln(w, `</g>`)
+	}
+	if deco.Draw != nil {
+		deco.Draw(w, func(id uint64) (int64, int64, bool) {
+			s, ok := m.Sites[id]
+			if !ok {
+				return 0, 0, false
+			}
+			return int64(s.Raw.X), int64(s.Raw.Y), true
+		})
+	}
+	fmt.Fprintln(w, `</svg>`)
+	return nil
+}
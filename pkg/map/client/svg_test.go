@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapclient
+
+import (
+	"bytes"
+	"github.com/jfsmig/hegemonie/pkg/map/graph"
+	"strings"
+	"testing"
+)
+
+func renderSvg(t *testing.T, raw MapRaw, args SvgArgs) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := RenderSvg(&out, raw, args, SvgDecoration{}); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestSvgOptions(t *testing.T) {
+	raw := makeRawMap()
+	raw.ID = "svg"
+	raw.Sites = []SiteRaw{
+		{ID: 1, X: 0, Y: 0, City: "Aster"},
+		{ID: 2, X: 30, Y: 40, SiteAttributes: SiteAttributes{Terrain: mapgraph.TerrainForest}},
+		{ID: 3, X: 30, Y: 100, City: "Borage & Cumin"},
+	}
+	raw.Roads = []RoadRaw{
+		// 50 long, through the forest in one direction only
+		{Src: 1, Dst: 2}, {Src: 2, Dst: 1},
+		// 60 long, with an explicit cost in one direction only
+		{Src: 2, Dst: 3, RoadAttributes: RoadAttributes{Cost: 1.5}}, {Src: 3, Dst: 2},
+	}
+	canvas := CanvasArgs{Width: 400, Height: 400, Padding: 20}
+
+	bare := renderSvg(t, raw, SvgArgs{CanvasArgs: canvas})
+	for _, unexpected := range []string{"<text", "site-", "terrain-", "crossroad"} {
+		if strings.Contains(bare, unexpected) {
+			t.Fatal("unexpected", unexpected, "in", bare)
+		}
+	}
+
+	out := renderSvg(t, raw, SvgArgs{CanvasArgs: canvas, Labels: true, Weights: true, Classes: true})
+	for _, expected := range []string{
+		// The labels of the cities, escaped
+		`>Aster</text>`, `>Borage &amp; Cumin</text>`,
+		// A single weight per pair of roads, the one of the road from the
+		// lowest ID, computed on the positions before the canvas is applied
+		`>100</text>`, `>90</text>`,
+		// The classes of the sites
+		`class="clickable site city site-1"`,
+		`class="clickable site crossroad site-2 terrain-forest"`,
+		`class="clickable site city site-3"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatal("missing", expected, "in", out)
+		}
+	}
+	if n := strings.Count(out, "<text"); n != 4 {
+		t.Fatal(n, "texts in", out)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapgraph
+
+import (
+	"github.com/juju/errors"
+	"math"
+)
+
+// Neighbor is a vertex around another vertex
+type Neighbor struct {
+	ID uint64
+	// Hops is the smallest number of roads from the center
+	Hops uint32
+	// Distance is the length of the shortest path from the center
+	Distance uint64
+}
+
+// Distances returns the length of the shortest paths from the vertex to each
+// vertex reachable from it, by vertex ID. The lengths are computed with the
+// weights of the roads, cf. RoadWeight. The vertex itself is at distance 0.
+func (m *Map) Distances(src uint64) (map[uint64]uint64, error) {
+	s := m.Cells.getIndex(src)
+	if s < 0 || m.steps == nil {
+		return nil, errors.NotFoundf("vertex %v", src)
+	}
+	_, dist := m.steps.dijkstra(uint32(s), math.MaxUint64)
+	out := make(map[uint64]uint64)
+	for i, d := range dist {
+		if d != math.MaxUint64 {
+			out[m.Cells[i].ID] = d
+		}
+	}
+	return out, nil
+}
+
+// Distance returns the length of the shortest path from the source to the
+// destination, and the number of roads of that path.
+func (m *Map) Distance(src, dst uint64) (uint64, uint32, error) {
+	s, d := m.Cells.getIndex(src), m.Cells.getIndex(dst)
+	if s < 0 || m.steps == nil {
+		return 0, 0, errors.NotFoundf("vertex %v", src)
+	}
+	if d < 0 {
+		return 0, 0, errors.NotFoundf("vertex %v", dst)
+	}
+	if s == d {
+		return 0, 0, nil
+	}
+
+	row := m.steps.row(uint32(s))
+	if row.steps[d] == unreachable {
+		return 0, 0, errors.NotFoundf("route %v->%v", src, dst)
+	}
+	var hops uint32
+	for current := uint32(s); current != uint32(d); hops++ {
+		current = m.steps.next(current, uint32(d))
+		if current == unreachable {
+			return 0, 0, errors.NotFoundf("route %v->%v", src, dst)
+		}
+	}
+	// The row of the source already holds the distances
+	return row.dist[d], hops, nil
+}
+
+// Neighborhood returns the vertices reachable from the center with at most
+// maxHops roads and with a path not longer than maxDistance, sorted by ID.
+// A zero limit is ignored, but at least one limit is required. The center
+// belongs to its neighborhood.
+func (m *Map) Neighborhood(center uint64, maxHops uint32, maxDistance uint64) ([]Neighbor, error) {
+	if maxHops == 0 && maxDistance == 0 {
+		return nil, errors.BadRequestf("no limit")
+	}
+	c := m.Cells.getIndex(center)
+	if c < 0 || m.steps == nil {
+		return nil, errors.NotFoundf("vertex %v", center)
+	}
+
+	hops := m.steps.hops(uint32(c), maxHops)
+	limit := uint64(math.MaxUint64)
+	if maxDistance > 0 {
+		limit = maxDistance
+	}
+	_, dist := m.steps.dijkstra(uint32(c), limit)
+
+	out := make([]Neighbor, 0)
+	for i := range m.Cells {
+		if hops[i] == unreachable || dist[i] == math.MaxUint64 {
+			continue
+		}
+		out = append(out, Neighbor{ID: m.Cells[i].ID, Hops: hops[i], Distance: dist[i]})
+	}
+	return out, nil
+}
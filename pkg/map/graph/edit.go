@@ -0,0 +1,161 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapgraph
+
+import (
+	"github.com/juju/errors"
+)
+
+// AddVertex inserts a new vertex in the Map, with the given roads that must
+// connect it to the rest of the graph. Only the paths that may use the new
+// vertex are recomputed.
+func (m *Map) AddVertex(v Vertex, roads ...Edge) error {
+	return m.edit(func(tmp *Map) error {
+		if v.ID == 0 {
+			return errors.BadRequestf("invalid vertex ID")
+		}
+		if tmp.Cells.Has(v.ID) {
+			return errors.AlreadyExistsf("vertex %v", v.ID)
+		}
+		tmp.Cells.Add(&v)
+		for _, r := range roads {
+			if r.S != v.ID && r.D != v.ID {
+				return errors.BadRequestf("road %v->%v not connected to the vertex %v", r.S, r.D, v.ID)
+			}
+			if err := tmp.addRoad(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(old *stepIndex, tmp *Map) *stepIndex {
+		return old.withVertex(tmp, uint32(tmp.Cells.getIndex(v.ID)))
+	})
+}
+
+// RemoveVertex deletes the vertex and all the roads that reach or leave it.
+// Only the paths that used the vertex are recomputed.
+func (m *Map) RemoveVertex(id uint64) error {
+	// The rank of the vertex in the Map, before the edit
+	rank := m.Cells.getIndex(id)
+	return m.edit(func(tmp *Map) error {
+		v := tmp.Cells.Get(id)
+		if v == nil {
+			return errors.NotFoundf("vertex %v", id)
+		}
+		tmp.Cells.Remove(v)
+		roads := make(SetOfEdges, 0, len(tmp.Roads))
+		for _, r := range tmp.Roads {
+			if r.S != id && r.D != id {
+				roads = append(roads, r)
+			}
+		}
+		tmp.Roads = roads
+		return nil
+	}, func(old *stepIndex, tmp *Map) *stepIndex {
+		return old.withoutVertex(tmp, uint32(rank))
+	})
+}
+
+// AddRoad inserts a new road between two existing vertices. Only the paths
+// that may use the road are recomputed.
+func (m *Map) AddRoad(r Edge) error {
+	return m.edit(func(tmp *Map) error { return tmp.addRoad(r) }, func(old *stepIndex, tmp *Map) *stepIndex {
+		return old.with(tmp, tmp.Roads.Get(r.S, r.D))
+	})
+}
+
+// RemoveRoad deletes the road between the two vertices. Only the paths that
+// used the road are recomputed.
+func (m *Map) RemoveRoad(src, dst uint64) error {
+	return m.edit(func(tmp *Map) error {
+		r := tmp.Roads.Get(src, dst)
+		if r == nil {
+			return errors.NotFoundf("road %v->%v", src, dst)
+		}
+		tmp.Roads.Remove(r)
+		return nil
+	}, func(old *stepIndex, tmp *Map) *stepIndex {
+		// The ranks of the vertices are unchanged
+		s, d := tmp.Cells.getIndex(src), tmp.Cells.getIndex(dst)
+		return old.without(tmp, uint32(s), uint32(d))
+	})
+}
+
+// RenameCity sets the name of the city carried by the vertex. An empty
+// name removes the city. The paths are not affected.
+func (m *Map) RenameCity(id uint64, name string) error {
+	return m.edit(func(tmp *Map) error {
+		v := tmp.Cells.Get(id)
+		if v == nil {
+			return errors.NotFoundf("vertex %v", id)
+		}
+		v.City = name
+		return nil
+	}, func(old *stepIndex, tmp *Map) *stepIndex {
+		return old
+	})
+}
+
+func (m *Map) addRoad(r Edge) error {
+	if !m.Cells.Has(r.S) || !m.Cells.Has(r.D) {
+		return errors.NotFoundf("road end %v->%v", r.S, r.D)
+	}
+	if m.Roads.Has(r.S, r.D) {
+		return errors.AlreadyExistsf("road %v->%v", r.S, r.D)
+	}
+	m.Roads.Add(&r)
+	return nil
+}
+
+// edit applies the alteration on a copy of the Map, then validates the copy.
+// Upon success, the Map takes the content of the copy and its version is
+// bumped. The Map is left untouched upon failure.
+// The reindex hook computes the index of the copy from the index of the
+// Map. When nil, a brand new index is built.
+func (m *Map) edit(alter func(tmp *Map) error, reindex func(old *stepIndex, tmp *Map) *stepIndex) error {
+	tmp := m.clone()
+	if err := alter(tmp); err != nil {
+		return err
+	}
+	tmp.canonize()
+	if reindex == nil || m.steps == nil {
+		tmp.rehash()
+	} else {
+		tmp.steps = reindex(m.steps, tmp)
+	}
+	if err := tmp.check(); err != nil {
+		return errors.Annotate(err, "invalid map")
+	}
+
+	m.Cells, m.Roads, m.steps = tmp.Cells, tmp.Roads, tmp.steps
+	m.hash = tmp.digest()
+	m.Version++
+	return nil
+}
+
+// clone returns a deep copy of the vertices and the roads of the Map,
+// without index.
+func (m *Map) clone() *Map {
+	out := NewMap()
+	out.ID = m.ID
+	out.Version = m.Version
+	for _, v := range m.Cells {
+		x := *v
+		if v.Bonus != nil {
+			b := Bonus{
+				Mult: append([]float64{}, v.Bonus.Mult...),
+				Plus: append([]int64{}, v.Bonus.Plus...),
+			}
+			x.Bonus = &b
+		}
+		out.Cells = append(out.Cells, &x)
+	}
+	for _, r := range m.Roads {
+		x := *r
+		out.Roads = append(out.Roads, &x)
+	}
+	return out
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapgraph
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+// checkFresh asserts that the next steps of an edited map are those of the
+// same map freshly loaded.
+func checkFresh(t *testing.T, m *Map) {
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := NewMap()
+	if err = ref.LoadJSON(string(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range m.Cells {
+		for _, d := range m.Cells {
+			if s.ID == d.ID {
+				continue
+			}
+			expected, err := ref.PathNextStep(s.ID, d.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next, err := m.PathNextStep(s.ID, d.ID); err != nil || next != expected {
+				t.Fatal("step mismatch", s.ID, d.ID, next, expected, err)
+			}
+		}
+	}
+}
+
+func loadGrid(t *testing.T, n int) *Map {
+	m := NewMap()
+	if err := m.LoadJSON(gridMap(n)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMapEditVertex(t *testing.T) {
+	m := loadGrid(t, 9)
+
+	// An isolated vertex breaks the connectivity
+	if err := m.AddVertex(Vertex{ID: 10, X: 30, Y: 0}); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if m.CellHas(10) || m.Version != 0 {
+		t.Fatal("map altered by a failed edit")
+	}
+	if err := m.AddVertex(Vertex{ID: 3, X: 30, Y: 0}, Edge{S: 3, D: 2}); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	err := m.AddVertex(Vertex{ID: 10, X: 30, Y: 0, City: "x"}, Edge{S: 3, D: 10}, Edge{S: 10, D: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.CellHas(10) || m.Version != 1 {
+		t.Fatal("map not altered")
+	}
+	testPath(t, m, 10, 2, 3, 2)
+	checkFresh(t, m)
+
+	if err = m.RemoveVertex(10); err != nil {
+		t.Fatal(err)
+	}
+	if m.CellHas(10) || m.RoadHas(3, 10) || m.RoadHas(10, 3) || m.Version != 2 {
+		t.Fatal("map not altered")
+	}
+	checkFresh(t, m)
+
+	if err = m.RemoveVertex(5); err != nil {
+		t.Fatal(err)
+	}
+	checkFresh(t, m)
+	if err = m.RemoveVertex(5); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestMapEditRoad(t *testing.T) {
+	m := loadGrid(t, 25)
+
+	// Warm the index up, so that the removal has rows to preserve
+	for _, s := range m.Cells[1:] {
+		if _, err := m.PathNextStep(s.ID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.RemoveRoad(1, 25); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if err := m.RemoveRoad(7, 8); err != nil {
+		t.Fatal(err)
+	}
+	if m.RoadHas(7, 8) || !m.RoadHas(8, 7) {
+		t.Fatal("road not removed")
+	}
+	checkFresh(t, m)
+
+	// A one-way road toward the corner disconnects it
+	if err := m.RemoveRoad(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveRoad(1, 6); err == nil {
+		t.Fatal("unexpected success")
+	}
+	checkFresh(t, m)
+
+	if err := m.AddRoad(Edge{S: 1, D: 2, W: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRoad(Edge{S: 1, D: 2}); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if err := m.AddRoad(Edge{S: 1, D: 99}); err == nil {
+		t.Fatal("unexpected success")
+	}
+	checkFresh(t, m)
+	if m.Version != 3 {
+		t.Fatal("unexpected version", m.Version)
+	}
+}
+
+// warm computes the rows of all the sources of the map
+func warm(t *testing.T, m *Map) {
+	for i, s := range m.Cells {
+		d := m.Cells[(i+1)%len(m.Cells)]
+		if _, err := m.PathNextStep(s.ID, d.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMapEditKeepRows(t *testing.T) {
+	m := loadGrid(t, 25)
+
+	// A long road is a detour for all the sources
+	warm(t, m)
+	if err := m.AddRoad(Edge{S: 1, D: 25, W: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.steps.lru.Len(); n != 25 {
+		t.Fatal(n, "rows kept")
+	}
+	checkFresh(t, m)
+
+	// A shortcut invalidates the rows that may use it
+	warm(t, m)
+	if err := m.AddRoad(Edge{S: 7, D: 13}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.steps.lru.Len(); n == 0 || n == 25 {
+		t.Fatal(n, "rows kept")
+	}
+	checkFresh(t, m)
+
+	// A dead end is never a first step toward the rest of the map
+	warm(t, m)
+	if err := m.AddVertex(Vertex{ID: 30, X: 100, Y: 100}, Edge{S: 25, D: 30}, Edge{S: 30, D: 25}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.steps.lru.Len(); n != 25 {
+		t.Fatal(n, "rows kept")
+	}
+	checkFresh(t, m)
+
+	warm(t, m)
+	if err := m.RemoveVertex(30); err != nil {
+		t.Fatal(err)
+	}
+	// Only the row of the vertex itself is dropped
+	if n := m.steps.lru.Len(); n != 25 {
+		t.Fatal(n, "rows kept")
+	}
+	checkFresh(t, m)
+
+	// From 1, the paths to the new vertex through 3 and through 6 are as
+	// short. The search follows the path through 6, explored first.
+	warm(t, m)
+	err := m.AddVertex(Vertex{ID: 31, X: 100, Y: 100},
+		Edge{S: 3, D: 31, W: 10}, Edge{S: 6, D: 31, W: 20}, Edge{S: 31, D: 1, W: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.steps.rows[0]; ok {
+		t.Fatal("ambiguous row kept")
+	}
+	testPath(t, m, 1, 31, 6, 31)
+	checkFresh(t, m)
+}
+
+// TestMapEditRandom compares the index maintained along random edits with
+// the index of the same map freshly loaded.
+func TestMapEditRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := loadGrid(t, 25)
+	next := uint64(100)
+	pick := func() uint64 { return m.Cells[rng.Intn(len(m.Cells))].ID }
+	weight := func() uint64 { return []uint64{0, 0, 5, 10, 50}[rng.Intn(5)] }
+
+	for i := 0; i < 300; i++ {
+		warm(t, m)
+		switch rng.Intn(4) {
+		case 0:
+			if s, d := pick(), pick(); s != d {
+				m.AddRoad(Edge{S: s, D: d, W: weight()})
+			}
+		case 1:
+			r := m.Roads[rng.Intn(len(m.Roads))]
+			m.RemoveRoad(r.S, r.D)
+		case 2:
+			a, b := pick(), pick()
+			v := Vertex{ID: next, X: uint64(rng.Intn(50)), Y: uint64(rng.Intn(50))}
+			next++
+			m.AddVertex(v, Edge{S: a, D: v.ID, W: weight()}, Edge{S: v.ID, D: b, W: weight()})
+		case 3:
+			if len(m.Cells) > 10 {
+				m.RemoveVertex(pick())
+			}
+		}
+		checkFresh(t, m)
+	}
+}
+
+func TestMapEditCity(t *testing.T) {
+	m := loadGrid(t, 4)
+	h0 := m.Hash()
+	if err := m.RenameCity(2, "Ys"); err != nil {
+		t.Fatal(err)
+	}
+	if m.CellGet(2).City != "Ys" {
+		t.Fatal("city not renamed")
+	}
+	if m.Hash() == h0 || m.Version != 1 {
+		t.Fatal("hash or version unchanged")
+	}
+	// The hash only depends on the content of the map
+	if err := m.RenameCity(2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if m.Hash() != h0 || m.Version != 2 {
+		t.Fatal("unexpected hash or version")
+	}
+	if err := m.RenameCity(5, "Ys"); err == nil {
+		t.Fatal("unexpected success")
+	}
+	checkFresh(t, m)
+}
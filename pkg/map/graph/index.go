@@ -0,0 +1,422 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapgraph
+
+import (
+	"container/heap"
+	"container/list"
+	"math"
+	"sync"
+)
+
+// defaultIndexCacheSize is the number of source vertices whose next steps
+// are kept in memory. Each source costs 12 bytes per vertex of the map.
+const defaultIndexCacheSize = 256
+
+// unreachable marks, in a row of the index, the destinations without route.
+const unreachable = math.MaxUint32
+
+// arc is a road as seen by the index, with both ends identified by the
+// rank of the vertices in the sorted set of Cells.
+type arc struct {
+	dst    uint32
+	weight uint64
+}
+
+// stepIndex computes the next steps on demand. The rows of the index
+// (i.e. the next steps from one source to all the destinations) are computed
+// with a Dijkstra search, then kept in a LRU cache.
+// The index is safe for concurrent use, so that it may be queried by
+// several readers of the Map.
+type stepIndex struct {
+	adj [][]arc
+	max int
+
+	lock sync.Mutex
+	lru  *list.List
+	rows map[uint32]*list.Element
+}
+
+type indexRow struct {
+	src   uint32
+	steps []uint32
+	// dist is the length of the shortest path to each destination. It tells
+	// which rows survive the edits of the Map.
+	dist []uint64
+}
+
+func newStepIndex(m *Map, max int) *stepIndex {
+	if max <= 0 {
+		max = defaultIndexCacheSize
+	}
+	idx := &stepIndex{
+		adj:  make([][]arc, len(m.Cells)),
+		max:  max,
+		lru:  list.New(),
+		rows: make(map[uint32]*list.Element),
+	}
+	for _, r := range m.Roads {
+		s, d := m.Cells.getIndex(r.S), m.Cells.getIndex(r.D)
+		if s < 0 || d < 0 {
+			// Dangling roads are reported by check()
+			continue
+		}
+		idx.adj[s] = append(idx.adj[s], arc{uint32(d), m.RoadWeight(r)})
+	}
+	return idx
+}
+
+// next returns the rank of the next step from src to dst, or unreachable.
+func (idx *stepIndex) next(src, dst uint32) uint32 {
+	return idx.row(src).steps[dst]
+}
+
+func (idx *stepIndex) row(src uint32) *indexRow {
+	idx.lock.Lock()
+	if e, ok := idx.rows[src]; ok {
+		idx.lru.MoveToFront(e)
+		idx.lock.Unlock()
+		return e.Value.(*indexRow)
+	}
+	idx.lock.Unlock()
+
+	// The search happens out of the lock. Concurrent misses on the same
+	// source compute the same row, the last one wins.
+	row := idx.search(src)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if e, ok := idx.rows[src]; ok {
+		idx.lru.MoveToFront(e)
+		return e.Value.(*indexRow)
+	}
+	idx.rows[src] = idx.lru.PushFront(row)
+	for idx.lru.Len() > idx.max {
+		e := idx.lru.Back()
+		idx.lru.Remove(e)
+		delete(idx.rows, e.Value.(*indexRow).src)
+	}
+	return row
+}
+
+// reindex returns an index of the Map m, that keeps the cached rows of the
+// current index that an edit of the Map preserves. The keep hook returns the
+// row as it is in the new index, or nil when it must be computed again.
+//
+// The rows rely on the determinism of the search: a Dijkstra search takes
+// the same decisions as long as the edit neither adds a path as short as a
+// known one, nor removes a road of the tree of the shortest paths. The ranks
+// of the vertices may shift, their order is preserved.
+func (idx *stepIndex) reindex(m *Map, keep func(row *indexRow) *indexRow) *stepIndex {
+	out := newStepIndex(m, idx.max)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for e := idx.lru.Back(); e != nil; e = e.Prev() {
+		if row := keep(e.Value.(*indexRow)); row != nil {
+			out.rows[row.src] = out.lru.PushFront(row)
+		}
+	}
+	return out
+}
+
+// without returns an index of the Map m, that is the current index after the
+// removal of the road from the vertex of rank s to the vertex of rank d.
+// The cached rows that cannot have used the road are kept. The road belongs
+// to a shortest path from a source only if the path to d passes through s,
+// in other words if s and d share the same first step.
+func (idx *stepIndex) without(m *Map, s, d uint32) *stepIndex {
+	return idx.reindex(m, func(row *indexRow) *indexRow {
+		if row.src == s {
+			if row.steps[d] == d {
+				return nil
+			}
+		} else if row.steps[d] == row.steps[s] {
+			return nil
+		}
+		return row
+	})
+}
+
+// with returns an index of the Map m, that is the current index after the
+// addition of the road r. The cached rows where the road is a detour are
+// kept.
+func (idx *stepIndex) with(m *Map, r *Edge) *stepIndex {
+	s := uint32(m.Cells.getIndex(r.S))
+	a := arc{uint32(m.Cells.getIndex(r.D)), m.RoadWeight(r)}
+	return idx.reindex(m, func(row *indexRow) *indexRow {
+		if !row.detour(s, a) {
+			return nil
+		}
+		return row
+	})
+}
+
+// withVertex returns an index of the Map m, that is the current index after
+// the addition of the vertex of rank v in m, with its roads. The cached rows
+// are kept when the shortest path to the new vertex is unique and when the
+// roads that leave the vertex are all detours.
+func (idx *stepIndex) withVertex(m *Map, v uint32) *stepIndex {
+	id := m.Cells[v].ID
+	// The arcs toward v are reversed, they hold their source
+	incoming, outgoing := make([]arc, 0), make([]arc, 0)
+	for _, r := range m.Roads {
+		switch {
+		case r.S == r.D:
+		case r.D == id:
+			incoming = append(incoming, arc{uint32(m.Cells.getIndex(r.S)), m.RoadWeight(r)})
+		case r.S == id:
+			outgoing = append(outgoing, arc{uint32(m.Cells.getIndex(r.D)), m.RoadWeight(r)})
+		}
+	}
+
+	return idx.reindex(m, func(row *indexRow) *indexRow {
+		row = row.inserted(v)
+		best, first, tie := uint64(math.MaxUint64), uint32(unreachable), false
+		for _, in := range incoming {
+			if row.dist[in.dst] == math.MaxUint64 {
+				continue
+			}
+			d, f := row.dist[in.dst]+in.weight, row.steps[in.dst]
+			if in.dst == row.src {
+				f = v
+			}
+			if d < best {
+				best, first, tie = d, f, false
+			} else if d == best {
+				tie = true
+			}
+		}
+		if tie {
+			return nil
+		}
+		row.dist[v], row.steps[v] = best, first
+		for _, a := range outgoing {
+			if !row.detour(v, a) {
+				return nil
+			}
+		}
+		return row
+	})
+}
+
+// withoutVertex returns an index of the Map m, that is the current index
+// after the removal of the vertex of rank v, with its roads. The cached rows
+// where the vertex is a leaf of the tree of the shortest paths are kept,
+// i.e. where all the roads that leave it are detours.
+func (idx *stepIndex) withoutVertex(m *Map, v uint32) *stepIndex {
+	return idx.reindex(m, func(row *indexRow) *indexRow {
+		if row.src == v {
+			return nil
+		}
+		for _, a := range idx.adj[v] {
+			if !row.detour(v, a) {
+				return nil
+			}
+		}
+		return row.removed(v)
+	})
+}
+
+// detour tells if the arc from the vertex of rank s is strictly longer than
+// the known path toward its destination, so that a search never follows it.
+func (row *indexRow) detour(s uint32, a arc) bool {
+	return row.dist[s] == math.MaxUint64 || row.dist[s]+a.weight > row.dist[a.dst]
+}
+
+// inserted returns a copy of the row with a column for a new vertex of rank
+// v, yet unreachable. The ranks from v are shifted.
+func (row *indexRow) inserted(v uint32) *indexRow {
+	shift := func(x uint32) uint32 {
+		if x != unreachable && x >= v {
+			return x + 1
+		}
+		return x
+	}
+	out := &indexRow{
+		src:   shift(row.src),
+		steps: make([]uint32, 0, len(row.steps)+1),
+		dist:  make([]uint64, 0, len(row.dist)+1),
+	}
+	for i, x := range row.steps {
+		if uint32(i) == v {
+			out.steps, out.dist = append(out.steps, unreachable), append(out.dist, math.MaxUint64)
+		}
+		out.steps, out.dist = append(out.steps, shift(x)), append(out.dist, row.dist[i])
+	}
+	if int(v) == len(row.steps) {
+		out.steps, out.dist = append(out.steps, unreachable), append(out.dist, math.MaxUint64)
+	}
+	return out
+}
+
+// removed returns a copy of the row without the column of the vertex of
+// rank v. The ranks after v are shifted.
+func (row *indexRow) removed(v uint32) *indexRow {
+	shift := func(x uint32) uint32 {
+		if x != unreachable && x > v {
+			return x - 1
+		}
+		return x
+	}
+	out := &indexRow{
+		src:   shift(row.src),
+		steps: make([]uint32, 0, len(row.steps)-1),
+		dist:  make([]uint64, 0, len(row.dist)-1),
+	}
+	for i, x := range row.steps {
+		if uint32(i) != v {
+			out.steps, out.dist = append(out.steps, shift(x)), append(out.dist, row.dist[i])
+		}
+	}
+	return out
+}
+
+// search runs a Dijkstra search from the source. Ties are broken on the
+// vertex rank (i.e. on the vertex ID), for the sake of a deterministic index.
+func (idx *stepIndex) search(src uint32) *indexRow {
+	steps, dist := idx.dijkstra(src, math.MaxUint64)
+	return &indexRow{src: src, steps: steps, dist: dist}
+}
+
+// dijkstra returns, for each vertex rank, the first step and the length of
+// the shortest path from the source. The search stops at the given length:
+// the vertices farther than the limit, like the unreachable vertices, have
+// no step and a math.MaxUint64 length.
+func (idx *stepIndex) dijkstra(src uint32, limit uint64) ([]uint32, []uint64) {
+	steps := make([]uint32, len(idx.adj))
+	best := make([]uint64, len(idx.adj))
+	done := make([]bool, len(idx.adj))
+	for i := range steps {
+		steps[i] = unreachable
+		best[i] = math.MaxUint64
+	}
+	done[src] = true
+	best[src] = 0
+
+	q := make(frontier, 0)
+	// Bootstrap the search with adjacent nodes, that are their own first step
+	for _, a := range idx.adj[src] {
+		if a.weight < best[a.dst] && a.weight <= limit {
+			best[a.dst] = a.weight
+			heap.Push(&q, track{a.dst, a.dst, a.weight})
+		}
+	}
+
+	for q.Len() > 0 {
+		t := heap.Pop(&q).(track)
+		if done[t.current] {
+			continue
+		}
+		done[t.current] = true
+		steps[t.current] = t.first
+		for _, a := range idx.adj[t.current] {
+			if done[a.dst] {
+				continue
+			}
+			if d := t.dist + a.weight; d < best[a.dst] && d <= limit {
+				best[a.dst] = d
+				heap.Push(&q, track{a.dst, t.first, d})
+			}
+		}
+	}
+	return steps, best
+}
+
+// hops returns, for each vertex rank, the smallest number of roads from the
+// source. The search stops at the given number of roads (0 for no limit):
+// the vertices beyond the limit, like the unreachable vertices, are at
+// unreachable hops.
+func (idx *stepIndex) hops(src uint32, limit uint32) []uint32 {
+	out := make([]uint32, len(idx.adj))
+	for i := range out {
+		out[i] = unreachable
+	}
+	out[src] = 0
+	q := []uint32{src}
+	for len(q) > 0 {
+		current := q[0]
+		q = q[1:]
+		if limit > 0 && out[current] >= limit {
+			continue
+		}
+		for _, a := range idx.adj[current] {
+			if out[a.dst] == unreachable {
+				out[a.dst] = out[current] + 1
+				q = append(q, a.dst)
+			}
+		}
+	}
+	return out
+}
+
+// reach returns the first vertex rank not reachable from the root, or
+// unreachable if all the vertices are reachable. The search follows the
+// roads backwards when reverse is set.
+func (idx *stepIndex) reach(root uint32, reverse bool) uint32 {
+	adj := idx.adj
+	if reverse {
+		adj = make([][]arc, len(idx.adj))
+		for s, arcs := range idx.adj {
+			for _, a := range arcs {
+				adj[a.dst] = append(adj[a.dst], arc{uint32(s), a.weight})
+			}
+		}
+	}
+
+	seen := make([]bool, len(adj))
+	seen[root] = true
+	q := []uint32{root}
+	for len(q) > 0 {
+		current := q[0]
+		q = q[1:]
+		for _, a := range adj[current] {
+			if !seen[a.dst] {
+				seen[a.dst] = true
+				q = append(q, a.dst)
+			}
+		}
+	}
+	for i, ok := range seen {
+		if !ok {
+			return uint32(i)
+		}
+	}
+	return unreachable
+}
+
+type track struct {
+	current uint32
+	first   uint32
+	dist    uint64
+}
+
+// frontier is a min-heap of the paths being explored, ordered by distance
+// then by vertex rank.
+type frontier []track
+
+func (f frontier) Len() int { return len(f) }
+
+func (f frontier) Less(i, j int) bool {
+	if f[i].dist != f[j].dist {
+		return f[i].dist < f[j].dist
+	}
+	if f[i].current != f[j].current {
+		return f[i].current < f[j].current
+	}
+	return f[i].first < f[j].first
+}
+
+func (f frontier) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+
+func (f *frontier) Push(x interface{}) { *f = append(*f, x.(track)) }
+
+func (f *frontier) Pop() interface{} {
+	old := *f
+	x := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return x
+}
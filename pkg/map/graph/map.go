@@ -6,9 +6,13 @@
 package mapgraph
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/juju/errors"
 	"io"
+	"math"
 	"sort"
 	"strings"
 )
@@ -20,6 +24,18 @@ type Edge struct {
 
 	// Unique identifier of the destination Cell
 	D uint64 `json:"dst"`
+
+	// Explicit length of the road. When zero, the length is derived
+	// from the locations of the source and destination Cells.
+	W uint64 `json:"weight,omitempty"`
+
+	// Terrain along the road. When empty, the terrain of the destination
+	// Cell applies.
+	Terrain Terrain `json:"terrain,omitempty"`
+
+	// Explicit multiplier of the length of the road. When zero, the
+	// multiplier is given by the terrain.
+	Cost float64 `json:"cost,omitempty"`
 }
 
 // Vertex is a vertex in the transportation directed graph
@@ -34,6 +50,12 @@ type Vertex struct {
 	// Should the current location carry a city when the region starts,
 	// and if yes, what should be the name of that city.
 	City string `json:"city,omitempty"`
+
+	// Terrain of the location, that slows down the roads reaching it
+	Terrain Terrain `json:"terrain,omitempty"`
+
+	// Alteration of the production of the city built on the location
+	Bonus *Bonus `json:"bonus,omitempty"`
 }
 
 // Map is a directed graph destined to be used as a transport network,
@@ -43,7 +65,12 @@ type Map struct {
 	ID    string        `json:"id"`
 	Cells SetOfVertices `json:"sites"`
 	Roads SetOfEdges    `json:"roads"`
-	steps map[vector]uint64
+
+	// Version is bumped at each alteration of the map
+	Version uint64 `json:"version,omitempty"`
+
+	steps *stepIndex
+	hash  string
 }
 
 //go:generate go run github.com/jfsmig/hegemonie/pkg/gen-set ./map_auto.go mapgraph:SetOfVertices:*Vertex ID:uint64
@@ -58,7 +85,6 @@ func EmptyMap() Map {
 		ID:    "",
 		Cells: make(SetOfVertices, 0),
 		Roads: make(SetOfEdges, 0),
-		steps: make(map[vector]uint64),
 	}
 }
 
@@ -78,7 +104,11 @@ func (m *Map) Load(in io.Reader) error {
 
 	m.canonize()
 	m.rehash()
-	return m.check()
+	if err := m.check(); err != nil {
+		return err
+	}
+	m.hash = m.digest()
+	return nil
 }
 
 // LoadJSON is for testing purpose.
@@ -109,11 +139,15 @@ func (m *Map) PathNextStep(src, dst uint64) (uint64, error) {
 		return 0, errors.BadRequestf("EINVAL")
 	}
 
-	next, ok := m.steps[vector{src, dst}]
-	if ok {
-		return next, nil
+	s, d := m.Cells.getIndex(src), m.Cells.getIndex(dst)
+	if s < 0 || d < 0 || m.steps == nil {
+		return 0, errors.New("no route")
 	}
-	return 0, errors.New("no route")
+	next := m.steps.next(uint32(s), uint32(d))
+	if next == unreachable {
+		return 0, errors.New("no route")
+	}
+	return m.Cells[next].ID, nil
 }
 
 // CellAdjacency returns the adjacency list of the cell with the given ID,
@@ -133,6 +167,58 @@ func (m *Map) CellAdjacency(id uint64) []uint64 {
 	return adj
 }
 
+// RoadWeight returns the length of the road, as used by the path computations.
+// The explicit weight of the road prevails, then the euclidean distance
+// between its ends. That length is then multiplied by the cost of the
+// terrain (see Edge.Multiplier). The weight is never less than 1, so that
+// a map without coordinates falls back to minimizing the hop count.
+func (m *Map) RoadWeight(r *Edge) uint64 {
+	src, dst := m.Cells.Get(r.S), m.Cells.Get(r.D)
+	length := float64(r.W)
+	if r.W == 0 && src != nil && dst != nil {
+		dx := float64(src.X) - float64(dst.X)
+		dy := float64(src.Y) - float64(dst.Y)
+		length = math.Hypot(dx, dy)
+	}
+	if w := uint64(math.Round(length * r.Multiplier(dst))); w > 0 {
+		return w
+	}
+	return 1
+}
+
+// Hash returns a digest of the content of the map, i.e. its vertices and its
+// roads. Unlike the Version, the Hash doesn't depend on the history of the map.
+func (m *Map) Hash() string {
+	return m.hash
+}
+
+func (m *Map) digest() string {
+	h := sha256.New()
+	// The attributes are only digested when set, so that the hash of a map
+	// without attributes is unchanged.
+	for _, v := range m.Cells {
+		fmt.Fprintf(h, "v %d %d %d %q", v.ID, v.X, v.Y, v.City)
+		if v.Terrain != "" {
+			fmt.Fprintf(h, " t %s", v.Terrain)
+		}
+		if v.Bonus != nil {
+			fmt.Fprintf(h, " b %v %v", v.Bonus.Mult, v.Bonus.Plus)
+		}
+		fmt.Fprintln(h)
+	}
+	for _, r := range m.Roads {
+		fmt.Fprintf(h, "r %d %d %d", r.S, r.D, r.W)
+		if r.Terrain != "" {
+			fmt.Fprintf(h, " t %s", r.Terrain)
+		}
+		if r.Cost != 0 {
+			fmt.Fprintf(h, " c %v", r.Cost)
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (m *Map) reset() *Map {
 	*m = EmptyMap()
 	return m
@@ -155,17 +241,28 @@ func (m *Map) check() error {
 		return errors.Annotate(err, "error of roads")
 	}
 
+	// The graph must be strongly connected: all the vertices are reachable
+	// from the first one, and the first one is reachable from all the others.
 	if m.Cells.Len() > 1 {
-		for _, s0 := range m.Cells {
-			for _, s1 := range m.Cells {
-				if _, ok := m.steps[vector{s0.ID, s1.ID}]; !ok {
-					return errors.NotValidf("unreachability [%v][%v]", s0.ID, s1.ID)
-				}
-			}
+		root := m.Cells[0].ID
+		if i := m.steps.reach(0, false); i != unreachable {
+			return errors.NotValidf("unreachability [%v][%v]", root, m.Cells[i].ID)
+		}
+		if i := m.steps.reach(0, true); i != unreachable {
+			return errors.NotValidf("unreachability [%v][%v]", m.Cells[i].ID, root)
+		}
+	}
+
+	for _, v := range m.Cells {
+		if err := v.checkAttributes(); err != nil {
+			return err
 		}
 	}
 
 	for idx, r := range m.Roads {
+		if err := r.checkAttributes(); err != nil {
+			return err
+		}
 		if r.S <= 0 {
 			return errors.NotValidf("bad source")
 		}
@@ -189,89 +286,12 @@ func (m *Map) check() error {
 }
 
 // Build a new "Next Step" index for the current Map, and replace the previous index.
+// The index itself is lazy, the next steps are computed upon the first path
+// request from each source.
 func (m *Map) rehash() {
-	next := make(map[vector]uint64)
-
-	// Fill with the immediate neighbors
-	for _, r := range m.Roads {
-		next[vector{r.S, r.D}] = r.D
-	}
-
-	add := func(src, dst, step uint64) {
-		_, found := next[vector{src, dst}]
-		if !found {
-			next[vector{src, dst}] = step
-		}
-	}
-
-	// Call one DFS per node and shortcut when possible
-	for _, cell := range m.Cells {
-		already := make(map[uint64]bool)
-		q := newQueue()
-
-		// Bootstrap the DFS with adjacent nodes
-		for _, next := range m.CellAdjacency(cell.ID) {
-			q.push(next, next)
-			already[next] = true
-			// No need to add this in the known routes, we already did it
-			// with an iteration on the roads (much faster)
-		}
-
-		for !q.empty() {
-			current, first := q.pop()
-			neighbors := m.CellAdjacency(current)
-			// TODO(jfs): shuffle the neighbors
-			for _, next := range neighbors {
-				if !already[next] {
-					// Avoid passing again in the neighbor
-					already[next] = true
-					// Tell to continue at that neighbor
-					q.push(next, first)
-					// We already learned the shortest path to that neighbor
-					add(cell.ID, next, first)
-				}
-			}
-		}
-	}
-
-	m.steps = next
+	m.steps = newStepIndex(m, defaultIndexCacheSize)
 }
 
 func (v Vertex) equals(other Vertex) bool { return v.ID == other.ID }
 
 func (e Edge) equals(other Edge) bool { return e.S == other.S && e.D == other.D }
-
-type vector struct {
-	src uint64
-	dst uint64
-}
-
-type dfsTrack struct {
-	current uint64
-	first   uint64
-}
-
-type queue struct {
-	tab   []dfsTrack
-	start int
-}
-
-func newQueue() queue {
-	var q queue
-	q.tab = make([]dfsTrack, 0)
-	return q
-}
-
-func (q *queue) push(node, first uint64) {
-	q.tab = append(q.tab, dfsTrack{node, first})
-}
-
-func (q *queue) pop() (uint64, uint64) {
-	v := q.tab[q.start]
-	q.start++
-	return v.current, v.first
-}
-
-func (q *queue) empty() bool {
-	return q.start == len(q.tab)
-}
@@ -6,8 +6,11 @@
 package mapgraph
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
+	"strconv"
 	"testing"
 )
 
@@ -75,3 +78,305 @@ func TestMapInitsuccess(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func testPath(t *testing.T, m *Map, src, dst uint64, expected ...uint64) {
+	path := make([]uint64, 0)
+	for src != dst {
+		next, err := m.PathNextStep(src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path = append(path, next)
+		if len(path) > len(m.Cells) {
+			t.Fatal("loop detected", path)
+		}
+		src = next
+	}
+	if fmt.Sprint(path) != fmt.Sprint(expected) {
+		t.Fatal("unexpected path", path, "expected", expected)
+	}
+}
+
+func TestMapPathByDistance(t *testing.T) {
+	// A short path with many hops (1-4-5-2) and a long path with few hops (1-3-2)
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1,"x":0,"y":0},{"id":2,"x":10,"y":0},{"id":3,"x":5,"y":100},{"id":4,"x":3,"y":0},{"id":5,"x":7,"y":0}],
+		"roads":[
+			{"src":1,"dst":3},{"src":3,"dst":1},{"src":3,"dst":2},{"src":2,"dst":3},
+			{"src":1,"dst":4},{"src":4,"dst":1},{"src":4,"dst":5},{"src":5,"dst":4},{"src":5,"dst":2},{"src":2,"dst":5}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPath(t, m, 1, 2, 4, 5, 2)
+	testPath(t, m, 2, 1, 5, 4, 1)
+	testPath(t, m, 3, 4, 1, 4)
+	testPath(t, m, 4, 3, 1, 3)
+}
+
+func TestMapPathByWeight(t *testing.T) {
+	// The direct road 1-2 is the shortest by coordinates, but its explicit weight makes it longer
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1,"x":0,"y":0},{"id":2,"x":10,"y":0},{"id":3,"x":5,"y":5}],
+		"roads":[
+			{"src":1,"dst":2,"weight":100},{"src":2,"dst":1},
+			{"src":1,"dst":3},{"src":3,"dst":1},{"src":3,"dst":2},{"src":2,"dst":3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPath(t, m, 1, 2, 3, 2)
+	testPath(t, m, 2, 1, 1)
+	if w := m.RoadWeight(m.Roads.Get(2, 1)); w != 10 {
+		t.Fatal("unexpected weight", w)
+	}
+}
+
+func TestMapPathByHops(t *testing.T) {
+	// Without coordinates, the path with the fewest hops wins
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1},{"id":2},{"id":3},{"id":4}],
+		"roads":[
+			{"src":1,"dst":2},{"src":2,"dst":3},{"src":3,"dst":4},{"src":4,"dst":1},{"src":1,"dst":3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPath(t, m, 1, 4, 3, 4)
+	testPath(t, m, 2, 1, 3, 4, 1)
+}
+
+func TestMapPathByTerrain(t *testing.T) {
+	// The direct road 1-2 crosses a mountain, the detour through 3 is faster
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1,"x":0,"y":0},{"id":2,"x":10,"y":0,"terrain":"mountain"},{"id":3,"x":5,"y":5}],
+		"roads":[
+			{"src":1,"dst":2},{"src":2,"dst":1},
+			{"src":1,"dst":3},{"src":3,"dst":1},{"src":3,"dst":2,"terrain":"plain"},{"src":2,"dst":3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPath(t, m, 1, 2, 3, 2)
+	testPath(t, m, 2, 1, 1)
+	if w := m.RoadWeight(m.Roads.Get(1, 2)); w != 30 {
+		t.Fatal("unexpected weight", w)
+	}
+
+	// An explicit cost prevails over the terrain
+	if err = m.RemoveRoad(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.AddRoad(Edge{S: 1, D: 2, Cost: 0.5, Terrain: TerrainForest}); err != nil {
+		t.Fatal(err)
+	}
+	testPath(t, m, 1, 2, 2)
+	if w := m.RoadWeight(m.Roads.Get(1, 2)); w != 5 {
+		t.Fatal("unexpected weight", w)
+	}
+}
+
+func TestMapAttributes(t *testing.T) {
+	const base = `{"id":"test","sites":[{"id":1,"x":0,"y":0},{"id":2,"x":10,"y":0}],"roads":[{"src":1,"dst":2},{"src":2,"dst":1}]}`
+	plain := NewMap()
+	if err := plain.LoadJSON(base); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1,"x":0,"y":0,"bonus":{"mult":[1.5],"plus":[0,2]}},{"id":2,"x":10,"y":0,"terrain":"forest"}],
+		"roads":[{"src":1,"dst":2,"terrain":"ford"},{"src":2,"dst":1}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Hash() == plain.Hash() {
+		t.Fatal("attributes not digested")
+	}
+	if v := m.CellGet(1); v.Bonus == nil || len(v.Bonus.Mult) != 1 || v.Bonus.Plus[1] != 2 {
+		t.Fatal("unexpected bonus", v.Bonus)
+	}
+
+	// The clone doesn't share the bonus
+	if c := m.clone(); c.CellGet(1).Bonus == m.CellGet(1).Bonus {
+		t.Fatal("bonus shared")
+	}
+
+	testFail := func(encoded string) {
+		if err := NewMap().LoadJSON(encoded); err == nil {
+			t.Fatal("Unexpected success with", encoded)
+		}
+	}
+	testFail(`{"id":"test","sites":[{"id":1,"terrain":"lava"},{"id":2}],"roads":[{"src":1,"dst":2},{"src":2,"dst":1}]}`)
+	testFail(`{"id":"test","sites":[{"id":1},{"id":2}],"roads":[{"src":1,"dst":2,"terrain":"lava"},{"src":2,"dst":1}]}`)
+	testFail(`{"id":"test","sites":[{"id":1},{"id":2}],"roads":[{"src":1,"dst":2,"cost":-1},{"src":2,"dst":1}]}`)
+	testFail(`{"id":"test","sites":[{"id":1,"bonus":{"mult":[-1]}},{"id":2}],"roads":[{"src":1,"dst":2},{"src":2,"dst":1}]}`)
+}
+
+func TestMapDistances(t *testing.T) {
+	m := NewMap()
+	err := m.LoadJSON(`{"id":"test",
+		"sites":[{"id":1,"x":0,"y":0},{"id":2,"x":10,"y":0},{"id":3,"x":5,"y":5}],
+		"roads":[
+			{"src":1,"dst":2,"weight":100},{"src":2,"dst":1},
+			{"src":1,"dst":3},{"src":3,"dst":1},{"src":3,"dst":2},{"src":2,"dst":3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dist, err := m.Distances(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dist) != "map[1:0 2:14 3:7]" {
+		t.Fatal("unexpected distances", dist)
+	}
+	if _, err = m.Distances(4); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestMapNeighborhood(t *testing.T) {
+	// A 3x3 grid, 10 units between the neighbors
+	m := NewMap()
+	if err := m.LoadJSON(gridMap(9)); err != nil {
+		t.Fatal(err)
+	}
+	ids := func(neighbors []Neighbor) []uint64 {
+		out := make([]uint64, 0)
+		for _, n := range neighbors {
+			out = append(out, n.ID)
+		}
+		return out
+	}
+
+	if _, err := m.Neighborhood(1, 0, 0); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if _, err := m.Neighborhood(42, 1, 0); err == nil {
+		t.Fatal("unexpected success")
+	}
+	n, err := m.Neighborhood(1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids(n)) != "[1 2 4]" {
+		t.Fatal("unexpected neighborhood", n)
+	}
+	n, err = m.Neighborhood(5, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids(n)) != "[2 4 5 6 8]" {
+		t.Fatal("unexpected neighborhood", n)
+	}
+	n, err = m.Neighborhood(1, 3, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids(n)) != "[1 2 3 4 5 7]" || n[4].Hops != 2 || n[4].Distance != 20 {
+		t.Fatal("unexpected neighborhood", n)
+	}
+
+	dist, hops, err := m.Distance(1, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dist != 40 || hops != 4 {
+		t.Fatal("unexpected distance", dist, hops)
+	}
+	if dist, hops, err = m.Distance(3, 3); err != nil || dist != 0 || hops != 0 {
+		t.Fatal("unexpected distance", dist, hops, err)
+	}
+}
+
+// gridMap encodes a strongly connected map of n vertices, laid out as a grid
+// with bidirectional roads between the horizontal and vertical neighbors.
+func gridMap(n int) string {
+	side := int(math.Ceil(math.Sqrt(float64(n))))
+	m := EmptyMap()
+	m.ID = "bench"
+	for i := 0; i < n; i++ {
+		m.Cells = append(m.Cells, &Vertex{ID: uint64(i + 1), X: uint64(10 * (i % side)), Y: uint64(10 * (i / side))})
+	}
+	road := func(a, b int) {
+		m.Roads = append(m.Roads, &Edge{S: uint64(a + 1), D: uint64(b + 1)}, &Edge{S: uint64(b + 1), D: uint64(a + 1)})
+	}
+	for i := 0; i < n; i++ {
+		if i%side != side-1 && i+1 < n {
+			road(i, i+1)
+		}
+		if i+side < n {
+			road(i, i+side)
+		}
+	}
+	encoded, err := json.Marshal(&m)
+	if err != nil {
+		panic(err)
+	}
+	return string(encoded)
+}
+
+var benchSizes = []int{1000, 5000, 20000}
+
+func BenchmarkMapLoad(b *testing.B) {
+	for _, n := range benchSizes {
+		encoded := gridMap(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := NewMap().LoadJSON(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMapPathNextStep(b *testing.B) {
+	for _, n := range benchSizes {
+		m := NewMap()
+		if err := m.LoadJSON(gridMap(n)); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				src, dst := uint64(1+rand.Intn(n)), uint64(1+rand.Intn(n))
+				if src == dst {
+					continue
+				}
+				if _, err := m.PathNextStep(src, dst); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMapIndexEviction(t *testing.T) {
+	encoded := gridMap(50)
+	ref, m := NewMap(), NewMap()
+	if err := ref.LoadJSON(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadJSON(encoded); err != nil {
+		t.Fatal(err)
+	}
+	m.steps = newStepIndex(m, 2)
+	for i := 0; i < 1000; i++ {
+		src, dst := uint64(1+rand.Intn(50)), uint64(1+rand.Intn(50))
+		if src == dst {
+			continue
+		}
+		expected, err := ref.PathNextStep(src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next, err := m.PathNextStep(src, dst); err != nil || next != expected {
+			t.Fatal("step mismatch", src, dst, next, expected, err)
+		}
+	}
+	if m.steps.lru.Len() > 2 {
+		t.Fatal("cache overflow", m.steps.lru.Len())
+	}
+}
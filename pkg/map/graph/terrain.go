@@ -0,0 +1,103 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mapgraph
+
+import (
+	"github.com/juju/errors"
+	"math"
+)
+
+// Terrain is the nature of the ground of a vertex or along a road. It slows
+// down the movements on the roads that cross it.
+type Terrain string
+
+// The known terrains. The empty Terrain is equivalent to a plain.
+const (
+	TerrainPlain    Terrain = "plain"
+	TerrainForest   Terrain = "forest"
+	TerrainMountain Terrain = "mountain"
+	TerrainFord     Terrain = "ford"
+)
+
+// terrainCosts are the multipliers applied to the length of the roads
+var terrainCosts = map[Terrain]float64{
+	"":              1,
+	TerrainPlain:    1,
+	TerrainForest:   2,
+	TerrainMountain: 3,
+	TerrainFord:     2,
+}
+
+// Valid tells if the terrain is known
+func (t Terrain) Valid() bool {
+	_, ok := terrainCosts[t]
+	return ok
+}
+
+// Cost returns the multiplier of the length of the roads through the terrain
+func (t Terrain) Cost() float64 {
+	if c, ok := terrainCosts[t]; ok {
+		return c
+	}
+	return 1
+}
+
+// Bonus alters the production of the city built on a vertex. Both arrays
+// are indexed like the resources of the regions, the missing entries have
+// no effect.
+type Bonus struct {
+	// Mult are the multipliers of the production, per resource
+	Mult []float64 `json:"mult,omitempty"`
+	// Plus are the increments of the production, per resource
+	Plus []int64 `json:"plus,omitempty"`
+}
+
+// Multiplier returns the factor applied to the length of the road. The
+// explicit cost of the road prevails, then the terrain of the road, then
+// the terrain of the destination of the road.
+func (r *Edge) Multiplier(dst *Vertex) float64 {
+	if r.Cost > 0 {
+		return r.Cost
+	}
+	if r.Terrain != "" {
+		return r.Terrain.Cost()
+	}
+	if dst != nil {
+		return dst.Terrain.Cost()
+	}
+	return 1
+}
+
+func (b *Bonus) check() error {
+	for i, m := range b.Mult {
+		if m < 0 || math.IsNaN(m) || math.IsInf(m, 0) {
+			return errors.NotValidf("bonus multiplier [%d]=%v", i, m)
+		}
+	}
+	return nil
+}
+
+func (v *Vertex) checkAttributes() error {
+	if !v.Terrain.Valid() {
+		return errors.NotValidf("terrain %q of vertex %v", v.Terrain, v.ID)
+	}
+	if v.Bonus != nil {
+		if err := v.Bonus.check(); err != nil {
+			return errors.Annotatef(err, "vertex %v", v.ID)
+		}
+	}
+	return nil
+}
+
+func (r *Edge) checkAttributes() error {
+	if !r.Terrain.Valid() {
+		return errors.NotValidf("terrain %q of road %v->%v", r.Terrain, r.S, r.D)
+	}
+	if r.Cost < 0 || math.IsNaN(r.Cost) || math.IsInf(r.Cost, 0) {
+		return errors.NotValidf("cost %v of road %v->%v", r.Cost, r.S, r.D)
+	}
+	return nil
+}
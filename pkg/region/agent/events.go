@@ -55,6 +55,8 @@ type EventArmy struct {
 	Dst uint64 `json:"Dst"`
 
 	Action string `json:"action"`
+
+	severity hegemonie_rpevent_proto.Severity
 }
 
 type EventKnowledge struct {
@@ -65,6 +67,23 @@ type EventUnits struct {
 	store *EventStore
 }
 
+// EventRollback informs the owner of a City that its region has been restored
+// as it was in a snapshot.
+type EventRollback struct {
+	store  *EventStore
+	charID string
+
+	CityID   uint64 `json:"CityId"`
+	CityName string `json:"City"`
+
+	Region     string `json:"Region"`
+	SnapshotID uint64 `json:"SnapshotId"`
+	Tick       uint64 `json:"Tick"`
+	When       int64  `json:"When"`
+
+	Action string `json:"action"`
+}
+
 func (es *EventStore) Army(log *region.City) region.EventArmy {
 	return &EventArmy{
 		store:        es,
@@ -82,6 +101,16 @@ func (es *EventStore) Units(log *region.City) region.EventUnits {
 	return &EventUnits{store: es}
 }
 
+func (es *EventStore) Rollback(log *region.City) region.EventRollback {
+	return &EventRollback{
+		store:    es,
+		charID:   log.Owner,
+		CityID:   log.ID,
+		CityName: log.Name,
+		Action:   "Rollback",
+	}
+}
+
 func (evt *EventArmy) Item(a *region.Army) region.EventArmy {
 	evt.ArmyID = a.ID
 	evt.ArmyName = a.Name
@@ -93,27 +122,58 @@ func (evt *EventArmy) Item(a *region.Army) region.EventArmy {
 func (evt *EventArmy) Move(src, dst uint64) region.EventArmy {
 	evt.Src, evt.Dst = src, dst
 	evt.Action = "Move"
+	evt.severity = hegemonie_rpevent_proto.Severity_INFO
 	return evt
 }
 
 func (evt *EventArmy) NoRoute(src, dst uint64) region.EventArmy {
 	evt.Src, evt.Dst = src, dst
 	evt.Action = "NoRoute"
+	evt.severity = hegemonie_rpevent_proto.Severity_WARNING
 	return evt
 }
 
 func (evt *EventArmy) Send() {
+	evt.store.push(evt.charID, evt, hegemonie_rpevent_proto.Category_MILITARY, evt.severity)
+}
+
+func (evt *EventRollback) Item(s *region.Snapshot) region.EventRollback {
+	evt.Region = s.Region
+	evt.SnapshotID = s.ID
+	evt.Tick = s.Tick
+	evt.When = s.When
+	return evt
+}
+
+func (evt *EventRollback) Send() {
+	// Nobody to notify for the cities not assigned to a character yet
+	if evt.charID == "" {
+		return
+	}
+	evt.store.push(evt.charID, evt, hegemonie_rpevent_proto.Category_SYSTEM, hegemonie_rpevent_proto.Severity_NOTICE)
+}
+
+// push sends the JSON form of the event to the events service
+func (es *EventStore) push(charID string, evt interface{}, cat hegemonie_rpevent_proto.Category, sev hegemonie_rpevent_proto.Severity) {
 	var buffer bytes.Buffer
 	enc := json.NewEncoder(&buffer)
 	enc.SetIndent("", "")
 	enc.Encode(evt)
 
-	client := hegemonie_rpevent_proto.NewProducerClient(evt.store.cnx)
-	client.Push1(context.Background(), &hegemonie_rpevent_proto.Push1Req{
-		CharId:  evt.charID,
-		EvtId:   uuid.New().String(),
-		Payload: buffer.Bytes(),
+	// The event ID is generated once so that the retries of the client
+	// interceptor are deduplicated by the event service.
+	id := uuid.New().String()
+	client := hegemonie_rpevent_proto.NewProducerClient(es.cnx)
+	_, err := client.Push1(context.Background(), &hegemonie_rpevent_proto.Push1Req{
+		CharId:   charID,
+		EvtId:    id,
+		Payload:  buffer.Bytes(),
+		Category: cat,
+		Severity: sev,
 	})
+	if err != nil {
+		utils.Logger.Warn().Err(err).Str("char", charID).Str("evt", id).Msg("push error")
+	}
 }
 
 func (evt *EventKnowledge) Item(c *region.City, kt *region.KnowledgeType) region.EventKnowledge {
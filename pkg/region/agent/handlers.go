@@ -22,15 +22,31 @@ import (
 type Config struct {
 	PathDefs string `yaml:"definitions" json:"definitions"`
 	PathLive string `yaml:"live" json:"live"`
+	// PathSnapshots is the directory of the snapshots of the regions. They
+	// are only kept in memory when empty.
+	PathSnapshots string `yaml:"snapshots" json:"snapshots"`
+	// KeepSnapshots is the number of snapshots kept per region
+	KeepSnapshots int `yaml:"keep_snapshots" json:"keep_snapshots"`
 }
 
+// defaultKeepSnapshots is the number of snapshots kept per region when the
+// configuration doesn't tell
+const defaultKeepSnapshots = 10
+
 type regionApp struct {
-	cfg Config
-	w   *region.World
+	cfg   Config
+	w     *region.World
+	maps  mapStatus
+	snaps *region.Snapshots
 }
 
 var none = &proto.None{}
 
+// pageSize bounds the number of items that a streaming handler snapshots
+// under a lock. The page is sent once the lock is released, so that a slow
+// client never blocks the writers.
+const pageSize = 100
+
 // Application implements the expectations of the application backend
 func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorable, error) {
 	w, err := region.NewWorld()
@@ -61,6 +77,17 @@ func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorabl
 		return nil, errors.Annotate(err, "inconsistent world")
 	}
 
+	if cfg.KeepSnapshots == 0 {
+		cfg.KeepSnapshots = defaultKeepSnapshots
+	}
+	if cfg.PathSnapshots == "" {
+		utils.Logger.Warn().Msg("no snapshots directory, the snapshots are kept in memory only")
+	}
+	snaps, err := region.NewSnapshots(cfg.PathSnapshots, cfg.KeepSnapshots)
+	if err != nil {
+		return nil, errors.Annotate(err, "snapshots")
+	}
+
 	var notifier region.Notifier
 	notifier, err = NewEventStoreClient(ctx)
 	if err != nil {
@@ -75,7 +102,9 @@ func (cfg Config) Application(ctx context.Context) (utils.RegisterableMonitorabl
 	}
 	w.SetMapClient(mc)
 
-	return &regionApp{w: w, cfg: cfg}, nil
+	app := &regionApp{w: w, cfg: cfg, snaps: snaps}
+	go app.runMapChecks(ctx)
+	return app, nil
 }
 
 // Register pugs the internal gRPC routes into the given server
@@ -89,16 +118,24 @@ func (app *regionApp) Register(grpcSrv *grpc.Server) error {
 	utils.Logger.Info().
 		Str("defs", app.cfg.PathDefs).
 		Str("live", app.cfg.PathLive).
+		Str("snapshots", app.cfg.PathSnapshots).
 		Msg("starting")
 
 	return nil
 }
 
 // Make the RegionApp monnitorable by the server stub
+// The divergence of a map only affects the regions on that map, it is
+// reported by ListRegions.
 func (app *regionApp) Check(ctx context.Context) grpc_health_v1.HealthCheckResponse_ServingStatus {
 	return grpc_health_v1.HealthCheckResponse_SERVING
 }
 
+// The locks are always acquired in the same order: the World, then the
+// Region, then the City. An exclusive lock on the World is only required to
+// alter the set of Regions. The rounds hold an exclusive lock on their Region
+// while the requests of the Characters share it and lock their City.
+
 func (app *regionApp) _worldLock(mode rune, action func() error) error {
 	switch mode {
 	case 'r':
@@ -114,46 +151,56 @@ func (app *regionApp) _worldLock(mode rune, action func() error) error {
 }
 
 func (app *regionApp) _regLock(mode rune, regID string, action func(*region.Region) error) error {
-	switch mode {
-	case 'r':
-		app.w.RLock()
-		defer app.w.RUnlock()
-	case 'w':
-		app.w.WLock()
-		defer app.w.WUnlock()
-	default:
-		return status.Error(codes.Internal, "invalid lock type")
-	}
-	r := app.w.Regions.Get(regID)
-	if r == nil {
-		return status.Error(codes.NotFound, "no such region")
-	}
-	return action(r)
-}
-
-func (app *regionApp) cityLock(mode rune, req *proto.CityId, action func(*region.Region, *region.City) error) error {
-	return app._regLock('r', req.Region, func(r *region.Region) error {
+	return app._worldLock('r', func() error {
+		r := app.w.Regions.Get(regID)
+		if r == nil {
+			return status.Error(codes.NotFound, "no such region")
+		}
 		switch mode {
 		case 'r':
-			// TODO(jfs) NYI
+			r.RLock()
+			defer r.RUnlock()
 		case 'w':
-			// TODO(jfs) NYI
+			r.WLock()
+			defer r.WUnlock()
 		default:
 			return status.Error(codes.Internal, "invalid lock type")
 		}
+		return action(r)
+	})
+}
 
+func (app *regionApp) cityLock(mode rune, req *proto.CityId, action func(*region.Region, *region.City) error) error {
+	return app._regLock('r', req.Region, func(r *region.Region) error {
 		c := r.CityGet(req.City)
 		if c == nil {
 			return status.Error(codes.NotFound, "no such city")
 		}
+		switch mode {
+		case 'r':
+			c.RLock()
+			defer c.RUnlock()
+		case 'w':
+			c.WLock()
+			defer c.WUnlock()
+		default:
+			return status.Error(codes.Internal, "invalid lock type")
+		}
 		if c.Deputy != req.Character && c.Owner != req.Character {
 			return status.Error(codes.PermissionDenied, "permission denied")
 		}
-
 		return action(r, c)
 	})
 }
 
+// cityPeek runs the action under a shared lock on the City, for the requests
+// that visit several Cities of a Region they share.
+func cityPeek(c *region.City, action func()) {
+	c.RLock()
+	defer c.RUnlock()
+	action()
+}
+
 func (app *regionApp) armyLock(mode rune, req *proto.ArmyId, action func(*region.Region, *region.City, *region.Army) error) error {
 	cID := proto.CityId{Region: req.Region, City: req.City, Character: req.Character}
 	return app.cityLock(mode, &cID, func(r *region.Region, c *region.City) error {
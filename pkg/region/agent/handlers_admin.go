@@ -27,20 +27,135 @@ type adminApp struct {
 	app *regionApp
 }
 
+// snapshotChunk is the size of the chunks of an exported snapshot
+const snapshotChunk = 64 * 1024
+
+// snapshot keeps the current state of the region. The caller must hold an
+// exclusive lock on the region.
+func (app *regionApp) snapshot(r *region.Region, round string) error {
+	s, err := r.TakeSnapshot(round)
+	if err == nil {
+		err = app.snaps.Keep(s)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "snapshot error: %v", err)
+	}
+	return nil
+}
+
 func (app *adminApp) Produce(ctx context.Context, req *proto.RegionId) (*proto.None, error) {
 	return none, app.app._regLock('w', req.Region, func(r *region.Region) error {
+		if err := app.app.snapshot(r, "produce"); err != nil {
+			return err
+		}
 		r.Produce(ctx)
 		return nil
 	})
 }
 
 func (app *adminApp) Move(ctx context.Context, req *proto.RegionId) (*proto.None, error) {
+	// The armies would move through vertices that don't exist anymore
+	if reason := app.app.maps.blocking(req.Region); reason != "" {
+		return none, status.Errorf(codes.FailedPrecondition, "map divergence: %s", reason)
+	}
 	return none, app.app._regLock('w', req.Region, func(r *region.Region) error {
+		if err := app.app.snapshot(r, "move"); err != nil {
+			return err
+		}
 		r.Move(ctx)
 		return nil
 	})
 }
 
+func (app *adminApp) ListSnapshots(req *proto.RegionId, stream proto.Admin_ListSnapshotsServer) error {
+	var tab []*region.Snapshot
+	err := app.app._regLock('r', req.Region, func(r *region.Region) error {
+		tab = app.app.snaps.List(r.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, s := range tab {
+		err = stream.Send(&proto.SnapshotView{
+			Region: s.Region,
+			Id:     s.ID,
+			Tick:   s.Tick,
+			When:   s.When,
+			Round:  s.Round,
+			Size:   uint64(s.Size()),
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *adminApp) Rollback(ctx context.Context, req *proto.SnapshotId) (*proto.None, error) {
+	var events []region.EventRollback
+	err := app.app._regLock('w', req.Region, func(r *region.Region) error {
+		s, err := app.app.snaps.Get(r.Name, req.Id)
+		if err != nil {
+			return status.Errorf(codes.NotFound, "%v", err)
+		}
+		plan, err := r.PrepareRollback(s)
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		// The rollback itself may be undone
+		if err = app.app.snapshot(r, "rollback"); err != nil {
+			return err
+		}
+		events = plan.Apply()
+		utils.Logger.Info().Str("region", r.Name).Uint64("snapshot", s.ID).Uint64("tick", s.Tick).Msg("rollback")
+		return nil
+	})
+	// The notifications don't need the Region anymore
+	for _, evt := range events {
+		evt.Send()
+	}
+	return none, err
+}
+
+func (app *adminApp) ExportSnapshot(req *proto.SnapshotId, stream proto.Admin_ExportSnapshotServer) error {
+	var s *region.Snapshot
+	err := app.app._regLock('r', req.Region, func(r *region.Region) error {
+		var err error
+		s, err = app.app.snaps.Get(r.Name, req.Id)
+		if err != nil {
+			return status.Errorf(codes.NotFound, "%v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded, err := s.Encode()
+	if err != nil {
+		return status.Errorf(codes.Internal, "snapshot encoding: %v", err)
+	}
+	for len(encoded) > 0 {
+		n := snapshotChunk
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		err = stream.Send(&proto.SnapshotChunk{Data: encoded[:n]})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
 func (app *adminApp) CreateRegion(ctx context.Context, req *proto.RegionCreateReq) (*proto.None, error) {
 	//  first, load the cities from the maps repository
 	endpoint, err := utils.DefaultDiscovery.Map()
@@ -50,6 +165,13 @@ func (app *adminApp) CreateRegion(ctx context.Context, req *proto.RegionCreateRe
 
 	out := make([]region.NamedCity, 0)
 
+	// The map is described before and after the listing of its cities, to
+	// detect an alteration of the map during the listing.
+	pre, err := mapInfo(ctx, req.MapName)
+	if err != nil {
+		return none, errors.Trace(err)
+	}
+
 	err = utils.Connect(ctx, endpoint, func(ctx context.Context, cli *grpc.ClientConn) error {
 		client := mproto.NewMapClient(cli)
 		marker := uint64(0)
@@ -70,7 +192,12 @@ func (app *adminApp) CreateRegion(ctx context.Context, req *proto.RegionCreateRe
 				return errors.Trace(err)
 			}
 			marker = x.GetId()
-			out = append(out, region.NamedCity{Name: x.GetName(), ID: x.GetId()})
+			nc := region.NamedCity{Name: x.GetName(), ID: x.GetId()}
+			if len(x.GetMult()) > 0 || len(x.GetPlus()) > 0 {
+				site := region.ResourceModifierOf(x.GetMult(), x.GetPlus())
+				nc.Site = &site
+			}
+			out = append(out, nc)
 		}
 		return nil
 	})
@@ -78,44 +205,117 @@ func (app *adminApp) CreateRegion(ctx context.Context, req *proto.RegionCreateRe
 		return none, errors.Trace(err)
 	}
 
+	post, err := mapInfo(ctx, req.MapName)
+	if err != nil {
+		return none, errors.Trace(err)
+	}
+	if pre.Hash != post.Hash {
+		return none, status.Errorf(codes.Aborted, "map %s altered during the creation", req.MapName)
+	}
+
 	return none, app.app._worldLock('w', func() error {
-		_, err := app.app.w.CreateRegion(req.Name, req.MapName, out)
-		return err
+		r, err := app.app.w.CreateRegion(req.Name, req.MapName, out)
+		if err != nil {
+			return err
+		}
+		r.MapVersion, r.MapHash = post.Version, post.Hash
+		return nil
+	})
+}
+
+func (app *adminApp) RepinMap(ctx context.Context, req *proto.RegionId) (*proto.None, error) {
+	app.app.maps.pinning.Lock()
+	defer app.app.maps.pinning.Unlock()
+
+	var mapName string
+	err := app.app._regLock('r', req.Region, func(r *region.Region) error {
+		mapName = r.MapName
+		return nil
+	})
+	if err != nil {
+		return none, err
+	}
+
+	// The map is described before and after the listing of its vertices, to
+	// detect an alteration of the map during the listing.
+	pre, err := mapInfo(ctx, mapName)
+	if err != nil {
+		return none, errors.Trace(err)
+	}
+	vertices, err := mapVertices(ctx, mapName)
+	if err != nil {
+		return none, errors.Trace(err)
+	}
+	post, err := mapInfo(ctx, mapName)
+	if err != nil {
+		return none, errors.Trace(err)
+	}
+	if pre.Hash != post.Hash {
+		return none, status.Errorf(codes.Aborted, "map %s altered during the check", mapName)
+	}
+
+	return none, app.app._regLock('w', req.Region, func(r *region.Region) error {
+		if missing := missingCells(r, vertices); len(missing) > 0 {
+			return status.Errorf(codes.FailedPrecondition, "cells missing on map %s: %v", mapName, missing)
+		}
+		utils.Logger.Info().Str("reg", r.Name).Str("map", mapName).
+			Uint64("from", r.MapVersion).Uint64("to", post.Version).Msg("map pinned")
+		r.MapVersion, r.MapHash = post.Version, post.Hash
+		app.app.maps.forget(r.Name)
+		return nil
 	})
 }
 
 func (app *adminApp) ListRegions(req *proto.RegionListReq, stream proto.Admin_ListRegionsServer) error {
-	return app.app._worldLock('r', func() error {
-		for marker := req.NameMarker; ; {
-			tab := app.app.w.Regions.Slice(marker, 100)
-			if len(tab) <= 0 {
-				return nil
-			}
-			for _, x := range tab {
-				marker = x.Name
-				summary := &proto.RegionSummary{
+	for marker := req.NameMarker; ; {
+		page := make([]*proto.RegionSummary, 0, pageSize)
+		err := app.app._worldLock('r', func() error {
+			for _, x := range app.app.w.Regions.Slice(marker, pageSize) {
+				x.RLock()
+				page = append(page, &proto.RegionSummary{
 					Name:        x.Name,
 					MapName:     x.MapName,
 					CountCities: uint32(len(x.Cities)),
 					CountFights: uint32(len(x.Fights)),
-				}
-				err := stream.Send(summary)
-				if err != nil {
-					if err == io.EOF {
-						return nil
-					}
-					return err
-				}
+					MapVersion:  x.MapVersion,
+					MapHash:     x.MapHash,
+				})
+				x.RUnlock()
 			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
 		}
-	})
+		for _, summary := range page {
+			marker = summary.Name
+			summary.MapDivergence = app.app.maps.divergence(summary.Name)
+			err = stream.Send(summary)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
 }
 
 func (app *adminApp) GetScores(req *proto.RegionId, stream proto.Admin_GetScoresServer) error {
-	return app.app._regLock('r', req.Region, func(r *region.Region) error {
-		for _, c := range r.Cities {
-			// FIXME(jfs): Calling Send() from a critical section is a bad idea
-			err := stream.Send(showCityPublic(app.app.w, c, true))
+	for last := uint64(0); ; {
+		page := make([]*proto.PublicCity, 0, pageSize)
+		err := app.app._regLock('r', req.Region, func(r *region.Region) error {
+			for _, c := range r.Cities.Slice(last, pageSize) {
+				cityPeek(c, func() { page = append(page, showCityPublic(app.app.w, c, true)) })
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
 			if err == io.EOF {
 				return nil
 			}
@@ -123,29 +323,51 @@ func (app *adminApp) GetScores(req *proto.RegionId, stream proto.Admin_GetScores
 				return err
 			}
 		}
-		return nil
-	})
+	}
+}
+
+// statsPages calls the action on the successive pages of the stats of the
+// cities of the region. The pages are snapshots, the action is called without
+// any lock held. The iteration stops at the first error of the action.
+func (app *adminApp) statsPages(regID string, action func(name string, page []*proto.CityStats) error) error {
+	for last := uint64(0); ; {
+		var name string
+		page := make([]*proto.CityStats, 0, pageSize)
+		err := app.app._regLock('r', regID, func(r *region.Region) error {
+			name = r.Name
+			for _, c := range r.Cities.Slice(last, pageSize) {
+				cityPeek(c, func() { page = append(page, showCityStats(r, c)) })
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		last = page[len(page)-1].Id
+		if err = action(name, page); err != nil {
+			return err
+		}
+	}
 }
 
 func s(u uint64) string { return strconv.FormatUint(u, 10) }
 
 func (app *adminApp) PushStats(ctx context.Context, req *proto.RegionId) (*proto.None, error) {
-	return none, app.app._regLock('r', req.Region, func(r *region.Region) error {
-		when := time.Now().Truncate(time.Minute)
-		client := influxdb2.NewClientWithOptions(
-			"http://localhost:8086",
-			"1987b7a4-2bd4-4fdd-a701-5bc9ced89c94",
-			influxdb2.DefaultOptions().SetPrecision(time.Second).SetBatchSize(100))
-		defer client.Close()
-		writeAPI := client.WriteAPI(
-			"hegemonie",
-			"hege_region_0")
-		defer writeAPI.Flush()
-		for _, c := range r.Cities {
-			stats := showCityStats(r, c)
+	when := time.Now().Truncate(time.Minute)
+	client := influxdb2.NewClientWithOptions(
+		"http://localhost:8086",
+		"1987b7a4-2bd4-4fdd-a701-5bc9ced89c94",
+		influxdb2.DefaultOptions().SetPrecision(time.Second).SetBatchSize(100))
+	defer client.Close()
+	writeAPI := client.WriteAPI(
+		"hegemonie",
+		"hege_region_0")
+	defer writeAPI.Flush()
+	return none, app.statsPages(req.Region, func(name string, page []*proto.CityStats) error {
+		for _, stats := range page {
 			p := influxdb2.NewPointWithMeasurement("stat").
-				AddTag("region", r.Name).
-				AddTag("city", strconv.FormatUint(c.ID, 10)).
+				AddTag("region", name).
+				AddTag("city", strconv.FormatUint(stats.Id, 10)).
 				AddField("r_sent_0", s(stats.ResourceSent.R0)).
 				AddField("r_sent_1", s(stats.ResourceSent.R1)).
 				AddField("r_sent_2", s(stats.ResourceSent.R2)).
@@ -206,17 +428,16 @@ func (app *adminApp) PushStats(ctx context.Context, req *proto.RegionId) (*proto
 }
 
 func (app *adminApp) GetStats(req *proto.RegionId, stream proto.Admin_GetStatsServer) error {
-	return app.app._regLock('r', req.Region, func(r *region.Region) error {
-		for _, c := range r.Cities {
-			// FIXME(jfs): Calling Send() from a critical section is a bad idea
-			err := stream.Send(showCityStats(r, c))
-			if err == io.EOF {
-				return nil
-			}
-			if err != nil {
+	err := app.statsPages(req.Region, func(_ string, page []*proto.CityStats) error {
+		for _, stats := range page {
+			if err := stream.Send(stats); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err == io.EOF {
+		return nil
+	}
+	return err
 }
@@ -23,50 +23,62 @@ type cityApp struct {
 }
 
 func (s *cityApp) List(req *proto.CitiesByCharReq, stream proto.City_ListServer) error {
-	return s.app._regLock('r', req.Region, func(r *region.Region) error {
-		last := req.Marker
-		for {
-			tab := r.Cities.Slice(last, 100)
-			if len(tab) <= 0 {
-				return nil
-			}
+	for last := req.Marker; ; {
+		// The page may be empty before the end of the region, when no city
+		// of the page belongs to the character.
+		var done bool
+		page := make([]*proto.PublicCity, 0)
+		err := s.app._regLock('r', req.Region, func(r *region.Region) error {
+			tab := r.Cities.Slice(last, pageSize)
+			done = len(tab) <= 0
 			for _, c := range tab {
 				last = c.ID
-				if c.Owner != req.Character && c.Deputy != req.Character {
-					continue
-				}
-				err := stream.Send(showCityPublic(s.app.w, c, false))
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+				cityPeek(c, func() {
+					if c.Owner == req.Character || c.Deputy == req.Character {
+						page = append(page, showCityPublic(s.app.w, c, false))
+					}
+				})
 			}
+			return nil
+		})
+		if err != nil || done {
+			return err
 		}
-	})
+		for _, v := range page {
+			err = stream.Send(v)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
 }
 
 func (s *cityApp) AllCities(req *proto.PaginatedQuery, stream proto.City_AllCitiesServer) error {
-	return s.app._regLock('r', req.Region, func(r *region.Region) error {
-		last := req.Marker
-		for {
-			tab := r.Cities.Slice(last, 100)
-			if len(tab) <= 0 {
+	for last := req.Marker; ; {
+		page := make([]*proto.PublicCity, 0, pageSize)
+		err := s.app._regLock('r', req.Region, func(r *region.Region) error {
+			for _, c := range r.Cities.Slice(last, pageSize) {
+				cityPeek(c, func() { page = append(page, showCityPublic(s.app.w, c, false)) })
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
+			if err == io.EOF {
 				return nil
 			}
-			for _, c := range tab {
-				last = c.ID
-				err := stream.Send(showCityPublic(s.app.w, c, false))
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+			if err != nil {
+				return err
 			}
 		}
-	})
+	}
 }
 
 func (s *cityApp) Show(ctx context.Context, req *proto.CityId) (reply *proto.CityView, err error) {
@@ -108,25 +120,28 @@ func (s *cityApp) Train(ctx context.Context, req *proto.TrainReq) (*proto.None,
 }
 
 func (s *cityApp) ListArmies(req *proto.CityId, stream proto.City_ListArmiesServer) error {
-	return s.app.cityLock('r', req, func(r *region.Region, c *region.City) error {
-		var last string
-		for {
-			tab := c.Armies.Slice(last, 100)
-			if len(tab) <= 0 {
+	for last := ""; ; {
+		page := make([]*proto.ArmyName, 0, pageSize)
+		err := s.app.cityLock('r', req, func(r *region.Region, c *region.City) error {
+			for _, a := range c.Armies.Slice(last, pageSize) {
+				page = append(page, &proto.ArmyName{Id: a.ID, Name: a.Name})
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
+			if err == io.EOF {
 				return nil
 			}
-			for _, a := range c.Armies {
-				last = a.ID
-				err := stream.Send(&proto.ArmyName{Id: a.ID, Name: a.Name})
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+			if err != nil {
+				return err
 			}
 		}
-	})
+	}
 }
 
 // Create an army made of only Units (no Resources carried)
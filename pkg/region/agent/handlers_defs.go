@@ -17,68 +17,79 @@ type defsApp struct {
 }
 
 func (app *defsApp) ListUnits(req *proto.PaginatedQuery, stream proto.Definitions_ListUnitsServer) error {
-	return app.app._worldLock('r', func() error {
-		last := req.GetMarker()
-		for {
-			tab := app.app.w.Definitions.Units.Slice(last, 100)
-			if len(tab) <= 0 {
+	for last := req.GetMarker(); ; {
+		page := make([]*proto.UnitTypeView, 0, pageSize)
+		err := app.app._worldLock('r', func() error {
+			for _, i := range app.app.w.Definitions.Units.Slice(last, pageSize) {
+				page = append(page, &proto.UnitTypeView{
+					Id: i.ID, Name: i.Name, Ticks: i.Ticks, Health: i.Health})
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
+			if err == io.EOF {
 				return nil
 			}
-			for _, i := range tab {
-				last = i.ID
-				err := stream.Send(&proto.UnitTypeView{
-					Id: i.ID, Name: i.Name, Ticks: i.Ticks, Health: i.Health})
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+			if err != nil {
+				return err
 			}
 		}
-	})
+	}
 }
 
 func (app *defsApp) ListBuildings(req *proto.PaginatedQuery, stream proto.Definitions_ListBuildingsServer) error {
-	return app.app._worldLock('r', func() error {
-		for last := req.GetMarker(); ; {
-			tab := app.app.w.Definitions.Buildings.Slice(last, 100)
-			if len(tab) <= 0 {
+	for last := req.GetMarker(); ; {
+		page := make([]*proto.BuildingTypeView, 0, pageSize)
+		err := app.app._worldLock('r', func() error {
+			for _, i := range app.app.w.Definitions.Buildings.Slice(last, pageSize) {
+				page = append(page, &proto.BuildingTypeView{
+					Id: i.ID, Name: i.Name, Ticks: i.Ticks})
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
+			if err == io.EOF {
 				return nil
 			}
-			for _, i := range tab {
-				last = i.ID
-				err := stream.Send(&proto.BuildingTypeView{
-					Id: i.ID, Name: i.Name, Ticks: i.Ticks})
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+			if err != nil {
+				return err
 			}
 		}
-	})
+	}
 }
 
 func (app *defsApp) ListKnowledges(req *proto.PaginatedQuery, stream proto.Definitions_ListKnowledgesServer) error {
-	return app.app._worldLock('r', func() error {
-		for last := req.GetMarker(); ; {
-			tab := app.app.w.Definitions.Knowledges.Slice(last, 100)
-			if len(tab) <= 0 {
+	for last := req.GetMarker(); ; {
+		page := make([]*proto.KnowledgeTypeView, 0, pageSize)
+		err := app.app._worldLock('r', func() error {
+			for _, i := range app.app.w.Definitions.Knowledges.Slice(last, pageSize) {
+				page = append(page, &proto.KnowledgeTypeView{
+					Id: i.ID, Name: i.Name, Ticks: i.Ticks})
+			}
+			return nil
+		})
+		if err != nil || len(page) <= 0 {
+			return err
+		}
+		for _, v := range page {
+			last = v.Id
+			err = stream.Send(v)
+			if err == io.EOF {
 				return nil
 			}
-			for _, i := range tab {
-				last = i.ID
-				err := stream.Send(&proto.KnowledgeTypeView{
-					Id: i.ID, Name: i.Name, Ticks: i.Ticks})
-				if err == io.EOF {
-					return nil
-				}
-				if err != nil {
-					return err
-				}
+			if err != nil {
+				return err
 			}
 		}
-	})
+	}
 }
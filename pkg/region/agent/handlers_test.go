@@ -0,0 +1,371 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package regagent
+
+import (
+	"context"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/region/model"
+	"github.com/jfsmig/hegemonie/pkg/region/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testRegion = "reg"
+	testCities = 8
+)
+
+type fullMeshMap struct{}
+
+func (m *fullMeshMap) Step(ctx context.Context, mapName string, src, dst uint64) (uint64, error) {
+	return dst, nil
+}
+
+// cityStream collects the cities sent by the streaming RPCs. The
+// grpc.ServerStream is only embedded to satisfy the interfaces.
+type cityStream struct {
+	grpc.ServerStream
+	cities []*proto.PublicCity
+}
+
+func (s *cityStream) Send(c *proto.PublicCity) error {
+	s.cities = append(s.cities, c)
+	return nil
+}
+
+// slowConsumer blocks in Send until it is released. It stands for a client
+// that doesn't read its stream.
+type slowConsumer struct {
+	grpc.ServerStream
+	started chan struct{}
+	release chan struct{}
+	sent    int
+}
+
+func newSlowConsumer() *slowConsumer {
+	return &slowConsumer{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *slowConsumer) consume() error {
+	if s.sent == 0 {
+		close(s.started)
+	}
+	s.sent++
+	<-s.release
+	return nil
+}
+
+type snapshotViews struct {
+	grpc.ServerStream
+	views []*proto.SnapshotView
+}
+
+func (s *snapshotViews) Send(v *proto.SnapshotView) error {
+	s.views = append(s.views, v)
+	return nil
+}
+
+type snapshotChunks struct {
+	grpc.ServerStream
+	data []byte
+}
+
+func (s *snapshotChunks) Send(c *proto.SnapshotChunk) error {
+	s.data = append(s.data, c.Data...)
+	return nil
+}
+
+type slowCities struct{ *slowConsumer }
+type slowStats struct{ *slowConsumer }
+type slowRegions struct{ *slowConsumer }
+type slowUnits struct{ *slowConsumer }
+type slowBuildings struct{ *slowConsumer }
+type slowKnowledges struct{ *slowConsumer }
+
+func (s slowCities) Send(*proto.PublicCity) error            { return s.consume() }
+func (s slowStats) Send(*proto.CityStats) error              { return s.consume() }
+func (s slowRegions) Send(*proto.RegionSummary) error        { return s.consume() }
+func (s slowUnits) Send(*proto.UnitTypeView) error           { return s.consume() }
+func (s slowBuildings) Send(*proto.BuildingTypeView) error   { return s.consume() }
+func (s slowKnowledges) Send(*proto.KnowledgeTypeView) error { return s.consume() }
+
+func owner(id uint64) string { return fmt.Sprintf("char-%d", id) }
+
+func fixtureApp(t *testing.T, nbCities int) *regionApp {
+	w, err := region.NewWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetMapClient(&fullMeshMap{})
+	w.Definitions.Knowledges.Add(&region.KnowledgeType{
+		ID: 1, Name: "k", Ticks: 1,
+		Prod:  region.ResourceModifierNoop(),
+		Stock: region.ResourceModifierNoop(),
+	})
+	w.Definitions.Buildings.Add(&region.BuildingType{
+		ID: 1, Name: "b", Ticks: 1,
+		Prod:     region.ResourceModifierUniform(1.0, 1.0),
+		Stock:    region.ResourceModifierNoop(),
+		Requires: []uint64{1},
+	})
+	w.Definitions.Units.Add(&region.UnitType{
+		ID: 1, Name: "u", Ticks: 1,
+		Prod:             region.ResourceModifierNoop(),
+		Health:           100,
+		HealthFactor:     1.0,
+		RequiredBuilding: 1,
+	})
+
+	cities := make([]region.NamedCity, 0, nbCities)
+	for i := uint64(1); i <= uint64(nbCities); i++ {
+		cities = append(cities, region.NamedCity{Name: fmt.Sprintf("city-%d", i), ID: i})
+	}
+	r, err := w.CreateRegion(testRegion, "map", cities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range r.Cities {
+		c.Owner = owner(c.ID)
+		c.Stock = region.ResourcesUniform(1000)
+		c.StockCapacity = region.ResourcesUniform(1000000)
+	}
+	snaps, err := region.NewSnapshots("", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &regionApp{w: w, snaps: snaps}
+}
+
+// TestCityLockStress hammers the City RPCs of several characters at once,
+// along with the production rounds and the listings of the whole region.
+// Run it with -race.
+func TestCityLockStress(t *testing.T) {
+	app := fixtureApp(t, testCities)
+	cities := &cityApp{app: app}
+	admin := &adminApp{app: app}
+	ctx := context.Background()
+
+	const rounds = 50
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 1024)
+
+	for id := uint64(1); id <= testCities; id++ {
+		cid := &proto.CityId{Region: testRegion, City: id, Character: owner(id)}
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(cid *proto.CityId) {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					// The model refuses some actions, e.g. a second study of a
+					// knowledge: only the answers of the locking layer matter.
+					cities.Study(ctx, &proto.StudyReq{City: cid, KnowledgeType: 1})
+					cities.Build(ctx, &proto.BuildReq{City: cid, BuildingType: 1})
+					cities.Train(ctx, &proto.TrainReq{City: cid, UnitType: 1})
+					cities.CreateTransport(ctx, &proto.CreateTransportReq{City: cid, Stock: &proto.ResourcesAbs{R1: 1}})
+					if _, err := cities.Show(ctx, cid); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(cid)
+		}
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := admin.Produce(ctx, &proto.RegionId{Region: testRegion}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			stream := &cityStream{}
+			if err := cities.AllCities(&proto.PaginatedQuery{Region: testRegion}, stream); err != nil {
+				errs <- err
+				return
+			}
+			if len(stream.cities) != testCities {
+				errs <- fmt.Errorf("listed %d cities", len(stream.cities))
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	r := app.w.Regions.Get(testRegion)
+	for _, c := range r.Cities {
+		// Concurrent studies of the same knowledge would have both succeeded
+		if len(c.Knowledges) != 1 {
+			t.Fatalf("city %d: %d knowledges", c.ID, len(c.Knowledges))
+		}
+		if len(c.Armies) != 4*rounds {
+			t.Fatalf("city %d: %d transports", c.ID, len(c.Armies))
+		}
+	}
+}
+
+func TestCityLockPermission(t *testing.T) {
+	app := fixtureApp(t, testCities)
+	cities := &cityApp{app: app}
+	ctx := context.Background()
+
+	_, err := cities.Show(ctx, &proto.CityId{Region: testRegion, City: 1, Character: owner(2)})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatal(err)
+	}
+	_, err = cities.Show(ctx, &proto.CityId{Region: testRegion, City: testCities + 1, Character: owner(1)})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+	_, err = cities.Show(ctx, &proto.CityId{Region: "nope", City: 1, Character: owner(1)})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	app := fixtureApp(t, testCities)
+	admin := &adminApp{app: app}
+	ctx := context.Background()
+	reg := &proto.RegionId{Region: testRegion}
+	r := app.w.Regions.Get(testRegion)
+
+	for i := 0; i < 6; i++ {
+		if _, err := admin.Produce(ctx, reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	views := &snapshotViews{}
+	if err := admin.ListSnapshots(reg, views); err != nil {
+		t.Fatal(err)
+	}
+	// Only the last snapshots are kept, each taken before its round
+	if len(views.views) != 4 || views.views[0].Id != 3 || views.views[0].Tick != 2 {
+		t.Fatal("unexpected snapshots", views.views)
+	}
+
+	r.Cities[0].Stock = region.ResourcesUniform(1)
+	if _, err := admin.Rollback(ctx, &proto.SnapshotId{Region: testRegion, Id: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Tick != 2 || !r.Cities[0].Stock.Equals(region.ResourcesUniform(1000)) {
+		t.Fatal("not rolled back", r.Tick, r.Cities[0].Stock)
+	}
+	_, err := admin.Rollback(ctx, &proto.SnapshotId{Region: testRegion, Id: 1})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+
+	// The rollback itself is undoable
+	views = &snapshotViews{}
+	if err = admin.ListSnapshots(reg, views); err != nil {
+		t.Fatal(err)
+	}
+	last := views.views[len(views.views)-1]
+	if last.Round != "rollback" || last.Tick != 6 {
+		t.Fatal("unexpected snapshot", last)
+	}
+
+	chunks := &snapshotChunks{}
+	if err = admin.ExportSnapshot(&proto.SnapshotId{Region: testRegion, Id: last.Id}, chunks); err != nil {
+		t.Fatal(err)
+	}
+	s, err := region.DecodeSnapshot(chunks.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != last.Id || s.Tick != 6 || uint64(s.Size()) != last.Size {
+		t.Fatal("unexpected export", s.ID, s.Tick)
+	}
+
+	// A snapshot that doesn't match the region is refused before any
+	// rollback snapshot is taken.
+	r.MapHash = "edited"
+	_, err = admin.Rollback(ctx, &proto.SnapshotId{Region: testRegion, Id: last.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatal(err)
+	}
+	after := &snapshotViews{}
+	if err = admin.ListSnapshots(reg, after); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(after.views); n != len(views.views) || after.views[n-1].Id != last.Id {
+		t.Fatal("unexpected snapshots", after.views)
+	}
+}
+
+// TestStreamSlowConsumer checks that a client that doesn't consume its stream
+// never prevents a writer from locking the world.
+func TestStreamSlowConsumer(t *testing.T) {
+	const nbCities = 2*pageSize + 10
+	app := fixtureApp(t, nbCities)
+	cities := &cityApp{app: app}
+	admin := &adminApp{app: app}
+	defs := &defsApp{app: app}
+	reg := &proto.RegionId{Region: testRegion}
+	all := &proto.PaginatedQuery{Region: testRegion}
+
+	for _, tc := range []struct {
+		name     string
+		expected int
+		list     func(s *slowConsumer) error
+	}{
+		{"AllCities", nbCities, func(s *slowConsumer) error { return cities.AllCities(all, slowCities{s}) }},
+		{"List", 1, func(s *slowConsumer) error {
+			req := &proto.CitiesByCharReq{Region: testRegion, Character: owner(nbCities)}
+			return cities.List(req, slowCities{s})
+		}},
+		{"GetScores", nbCities, func(s *slowConsumer) error { return admin.GetScores(reg, slowCities{s}) }},
+		{"GetStats", nbCities, func(s *slowConsumer) error { return admin.GetStats(reg, slowStats{s}) }},
+		{"ListRegions", 1, func(s *slowConsumer) error { return admin.ListRegions(&proto.RegionListReq{}, slowRegions{s}) }},
+		{"ListUnits", 1, func(s *slowConsumer) error { return defs.ListUnits(all, slowUnits{s}) }},
+		{"ListBuildings", 1, func(s *slowConsumer) error { return defs.ListBuildings(all, slowBuildings{s}) }},
+		{"ListKnowledges", 1, func(s *slowConsumer) error { return defs.ListKnowledges(all, slowKnowledges{s}) }},
+	} {
+		s := newSlowConsumer()
+		done := make(chan error, 1)
+		go func() { done <- tc.list(s) }()
+		select {
+		case <-s.started:
+		case err := <-done:
+			t.Fatalf("%s: nothing sent: %v", tc.name, err)
+		}
+
+		locked := make(chan struct{})
+		go func() {
+			app._worldLock('w', func() error { return nil })
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: writer blocked by a slow consumer", tc.name)
+		}
+
+		close(s.release)
+		if err := <-done; err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if s.sent != tc.expected {
+			t.Fatalf("%s: %d items sent, expected %d", tc.name, s.sent, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package regagent
+
+import (
+	"context"
+	"fmt"
+	mproto "github.com/jfsmig/hegemonie/pkg/map/proto"
+	"github.com/jfsmig/hegemonie/pkg/region/model"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"google.golang.org/grpc"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mapCheckPeriod is the delay between two comparisons of the maps pinned by
+// the regions with the maps served by the map service.
+const mapCheckPeriod = time.Minute
+
+// mapStatus tracks the regions whose pinned map diverges from the map
+// currently served by the map service. A divergence only blocks the regions
+// that use cells missing on the served map.
+type mapStatus struct {
+	lock     sync.RWMutex
+	diverged map[string]mapDivergence
+
+	// pinning serializes the checks and the pins of the maps, so that a check
+	// never restores the divergence of a map pinned meanwhile.
+	pinning sync.Mutex
+}
+
+type mapDivergence struct {
+	reason   string
+	blocking bool
+}
+
+// divergence returns the reason why the map of the region diverges, or an
+// empty string if the map is consistent or not checked yet.
+func (ms *mapStatus) divergence(regName string) string {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	return ms.diverged[regName].reason
+}
+
+// blocking returns the reason why the map of the region cannot serve its
+// armies anymore, or an empty string if the cells of the region still exist.
+func (ms *mapStatus) blocking(regName string) string {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	if d := ms.diverged[regName]; d.blocking {
+		return d.reason
+	}
+	return ""
+}
+
+// forget clears the divergence of the region, e.g. after a new pin
+func (ms *mapStatus) forget(regName string) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.diverged, regName)
+}
+
+// mapInfo fetches the description of the map from the map service.
+func mapInfo(ctx context.Context, name string) (*mproto.MapName, error) {
+	endpoint, err := utils.DefaultDiscovery.Map()
+	if err != nil {
+		return nil, errors.Annotate(err, "map service not located")
+	}
+
+	var out *mproto.MapName
+	err = utils.Connect(ctx, endpoint, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := mproto.NewMapClient(cnx).Maps(ctx, &mproto.ListMapsReq{Name: name})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		out, err = rep.Recv()
+		if err == io.EOF {
+			return errors.NotFoundf("map %s", name)
+		}
+		return errors.Trace(err)
+	})
+	return out, err
+}
+
+// mapVertices fetches the IDs of all the vertices of the map from the map
+// service.
+func mapVertices(ctx context.Context, name string) (map[uint64]bool, error) {
+	endpoint, err := utils.DefaultDiscovery.Map()
+	if err != nil {
+		return nil, errors.Annotate(err, "map service not located")
+	}
+
+	out := make(map[uint64]bool)
+	err = utils.Connect(ctx, endpoint, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := mproto.NewMapClient(cnx).Vertices(ctx, &mproto.ListVerticesReq{MapName: name})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for {
+			v, err := rep.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+			out[v.Id] = true
+		}
+	})
+	return out, err
+}
+
+// missingCells returns the sorted IDs of the cells used by the cities and the
+// armies of the region that the map doesn't have.
+// The caller must hold a lock on the region.
+func missingCells(r *region.Region, vertices map[uint64]bool) []uint64 {
+	missing := make(map[uint64]bool)
+	for _, c := range r.Cities {
+		cityPeek(c, func() {
+			for _, id := range c.Cells() {
+				if !vertices[id] {
+					missing[id] = true
+				}
+			}
+		})
+	}
+	out := make([]uint64, 0, len(missing))
+	for id := range missing {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// mapPin is the map pinned by a region at its creation
+type mapPin struct {
+	region  string
+	name    string
+	version uint64
+	hash    string
+}
+
+func pinOf(r *region.Region) mapPin {
+	return mapPin{region: r.Name, name: r.MapName, version: r.MapVersion, hash: r.MapHash}
+}
+
+// compare explains how the map described by the map service diverges from
+// the pinned map. An empty string means no divergence. The divergence blocks
+// the region when some of its cells are missing on the served map.
+func (p mapPin) compare(m *mproto.MapName, missing []uint64) mapDivergence {
+	if p.hash == m.Hash {
+		return mapDivergence{}
+	}
+	d := mapDivergence{reason: fmt.Sprintf("map %s pinned at version %d (%s) but served at version %d (%s)",
+		p.name, p.version, p.hash, m.Version, m.Hash)}
+	if len(missing) > 0 {
+		d.reason += fmt.Sprintf(", cells missing %v", missing)
+		d.blocking = true
+	}
+	return d
+}
+
+// checkMaps compares the maps pinned by the regions with the maps served by
+// the map service. The regions without a pinned map are ignored.
+// Upon an error with the map service, the status of the region is unchanged.
+func (app *regionApp) checkMaps(ctx context.Context) {
+	app.maps.pinning.Lock()
+	defer app.maps.pinning.Unlock()
+
+	pins := make([]mapPin, 0)
+	app._worldLock('r', func() error {
+		for _, r := range app.w.Regions {
+			if r.MapHash != "" {
+				pins = append(pins, pinOf(r))
+			}
+		}
+		return nil
+	})
+
+	diverged := make(map[string]mapDivergence)
+	vertices := make(map[string]map[uint64]bool)
+	for _, p := range pins {
+		m, err := mapInfo(ctx, p.name)
+		if err == nil && m.Hash != p.hash && vertices[p.name] == nil {
+			vertices[p.name], err = mapVertices(ctx, p.name)
+		}
+		if errors.IsNotFound(err) {
+			diverged[p.region] = mapDivergence{reason: fmt.Sprintf("map %s not served", p.name), blocking: true}
+		} else if err != nil {
+			utils.Logger.Warn().Err(err).Str("reg", p.region).Str("map", p.name).Msg("map check error")
+			app.maps.lock.RLock()
+			if d, ok := app.maps.diverged[p.region]; ok {
+				diverged[p.region] = d
+			}
+			app.maps.lock.RUnlock()
+			continue
+		} else {
+			var missing []uint64
+			if m.Hash != p.hash {
+				app._regLock('r', p.region, func(r *region.Region) error {
+					missing = missingCells(r, vertices[p.name])
+					return nil
+				})
+			}
+			if d := p.compare(m, missing); d.reason != "" {
+				diverged[p.region] = d
+			}
+		}
+		if d, ok := diverged[p.region]; ok {
+			utils.Logger.Error().Str("reg", p.region).Bool("blocking", d.blocking).Msg(d.reason)
+		}
+	}
+
+	app.maps.lock.Lock()
+	defer app.maps.lock.Unlock()
+	app.maps.diverged = diverged
+}
+
+// runMapChecks periodically compares the pinned maps until the context is
+// done. The first comparison happens as soon as possible.
+func (app *regionApp) runMapChecks(ctx context.Context) {
+	ticker := time.NewTicker(mapCheckPeriod)
+	defer ticker.Stop()
+	for {
+		app.checkMaps(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
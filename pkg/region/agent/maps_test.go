@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package regagent
+
+import (
+	"context"
+	mproto "github.com/jfsmig/hegemonie/pkg/map/proto"
+	"github.com/jfsmig/hegemonie/pkg/region/model"
+	"github.com/jfsmig/hegemonie/pkg/region/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"testing"
+)
+
+func TestMapMissingCells(t *testing.T) {
+	app := fixtureApp(t, testCities)
+	r := app.w.Regions.Get(testRegion)
+	c := r.Cities.Get(1)
+	a, err := c.CreateTransport(r, region.ResourcesUniform(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.DeferMove(r, 42, region.ActionArgMove{}); err != nil {
+		t.Fatal(err)
+	}
+
+	vertices := make(map[uint64]bool)
+	for i := uint64(1); i <= testCities; i++ {
+		vertices[i] = true
+	}
+	if missing := missingCells(r, vertices); len(missing) != 1 || missing[0] != 42 {
+		t.Fatal("unexpected missing cells", missing)
+	}
+	vertices[42] = true
+	delete(vertices, 3)
+	if missing := missingCells(r, vertices); !reflect.DeepEqual(missing, []uint64{3}) {
+		t.Fatal("unexpected missing cells", missing)
+	}
+}
+
+func TestMapDivergence(t *testing.T) {
+	pin := mapPin{region: testRegion, name: "map", version: 1, hash: "h1"}
+
+	if d := pin.compare(&mproto.MapName{Name: "map", Version: 1, Hash: "h1"}, nil); d.reason != "" || d.blocking {
+		t.Fatal("unexpected divergence", d)
+	}
+	// An edit that keeps the cells of the region only diverges
+	d := pin.compare(&mproto.MapName{Name: "map", Version: 2, Hash: "h2"}, nil)
+	if d.reason == "" || d.blocking {
+		t.Fatal("unexpected divergence", d)
+	}
+	if d = pin.compare(&mproto.MapName{Name: "map", Version: 2, Hash: "h2"}, []uint64{3}); !d.blocking {
+		t.Fatal("unexpected divergence", d)
+	}
+}
+
+// TestMapDivergenceMove checks that only a blocking divergence prevents the
+// movements of the region, and that the service keeps serving.
+func TestMapDivergenceMove(t *testing.T) {
+	app := fixtureApp(t, testCities)
+	admin := &adminApp{app: app}
+	ctx := context.Background()
+	reg := &proto.RegionId{Region: testRegion}
+
+	app.maps.diverged = map[string]mapDivergence{testRegion: {reason: "edited"}}
+	if _, err := admin.Move(ctx, reg); err != nil {
+		t.Fatal(err)
+	}
+	if app.Check(ctx) != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatal("not serving")
+	}
+
+	app.maps.diverged[testRegion] = mapDivergence{reason: "cells missing", blocking: true}
+	if _, err := admin.Move(ctx, reg); status.Code(err) != codes.FailedPrecondition {
+		t.Fatal(err)
+	}
+	if app.Check(ctx) != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatal("not serving")
+	}
+
+	app.maps.forget(testRegion)
+	if _, err := admin.Move(ctx, reg); err != nil {
+		t.Fatal(err)
+	}
+}
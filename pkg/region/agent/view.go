@@ -96,6 +96,7 @@ func showProduction(w *region.World, c *region.City) *proto.ProductionView {
 	v.Base = resAbsM2P(prod.Base)
 	v.Buildings = resModM2P(prod.Buildings)
 	v.Knowledge = resModM2P(prod.Knowledge)
+	v.Site = resModM2P(prod.Site)
 	v.Actual = resAbsM2P(prod.Actual)
 	return v
 }
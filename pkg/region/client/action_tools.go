@@ -0,0 +1,250 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package regclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/client"
+	"github.com/jfsmig/hegemonie/pkg/region/model"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RenderArgs tells how to draw the state of a region on top of its map
+type RenderArgs struct {
+	mapclient.SvgArgs
+	// PathMap is the path to the raw map of the region
+	PathMap string
+	// ByOverlord colors the cities after their overlord instead of their owner
+	ByOverlord bool
+}
+
+// renderPalette are the colors of the factions, picked in the order of the
+// names of the factions.
+var renderPalette = []string{
+	"#e6194b", "#3cb44b", "#ffe119", "#4363d8", "#f58231", "#911eb4",
+	"#46f0f0", "#f032e6", "#bcf60c", "#fabebe", "#008080", "#e6beff",
+}
+
+// ToolRender consumes the live JSON of a region on os.Stdin, loads the raw map
+// of the region, then dumps to os.Stdout a SVG representation of the map with
+// the cities colored by faction, the armies heading to their targets and the
+// fights.
+func ToolRender(args RenderArgs) error {
+	raw, err := mapclient.LoadRawMap(args.PathMap)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var reg region.Region
+	if err = json.NewDecoder(os.Stdin).Decode(&reg); err != nil {
+		return errors.NewNotValid(err, "invalid region")
+	}
+	if err = reg.PostLoad(); err != nil {
+		return errors.Trace(err)
+	}
+
+	return mapclient.RenderSvg(os.Stdout, raw, args.SvgArgs, decorate(&reg, args.ByOverlord))
+}
+
+// DiffArgs tells which versions of a map to compare, and the region whose
+// assets might be affected by the changes.
+type DiffArgs struct {
+	PathOld, PathNew string
+	// PathRegion is the path to the live JSON of a region using the old map
+	PathRegion string
+}
+
+// AffectedCity is a city on a site removed from the map
+type AffectedCity struct {
+	ID    uint64 `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+// AffectedArmy is an army on a site removed from the map
+type AffectedArmy struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// City is the ID of the city in charge of the army
+	City uint64 `json:"city"`
+	Cell uint64 `json:"cell"`
+}
+
+// RegionDiff is the difference between two versions of a map, along with
+// the assets of a region that sit on the removed sites.
+type RegionDiff struct {
+	mapclient.MapDiff
+	Region string         `json:"region"`
+	Cities []AffectedCity `json:"cities,omitempty"`
+	Armies []AffectedArmy `json:"armies,omitempty"`
+}
+
+// ToolDiff compares the two versions of a map, then dumps to os.Stdout the
+// differences and the cities and the armies of the region that sit on the
+// sites removed from the map.
+func ToolDiff(args DiffArgs) error {
+	prev, err := mapclient.LoadRawMap(args.PathOld)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	next, err := mapclient.LoadRawMap(args.PathNew)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var reg region.Region
+	f, err := os.Open(args.PathRegion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = json.NewDecoder(f).Decode(&reg)
+	f.Close()
+	if err != nil {
+		return errors.NewNotValid(err, "invalid region")
+	}
+	if err = reg.PostLoad(); err != nil {
+		return errors.Trace(err)
+	}
+	if reg.MapName != prev.ID {
+		utils.Logger.Warn().Str("region", reg.Name).Str("map", reg.MapName).Str("old", prev.ID).Msg("map mismatch")
+	}
+
+	out := diffRegion(&reg, prev, next)
+	if len(out.Cities) > 0 || len(out.Armies) > 0 {
+		utils.Logger.Warn().Int("cities", len(out.Cities)).Int("armies", len(out.Armies)).Msg("region affected")
+	}
+	return utils.DumpJSON(out)
+}
+
+// diffRegion compares the two versions of the map of the region, and lists
+// the cities and the armies on the removed sites.
+func diffRegion(reg *region.Region, prev, next mapclient.MapRaw) RegionDiff {
+	out := RegionDiff{MapDiff: mapclient.DiffMaps(prev, next), Region: reg.Name}
+	removed := make(map[uint64]bool)
+	for _, id := range out.RemovedSites {
+		removed[id] = true
+	}
+	for _, c := range reg.Cities {
+		if removed[c.ID] {
+			out.Cities = append(out.Cities, AffectedCity{ID: c.ID, Name: c.Name, Owner: c.Owner})
+		}
+		for _, a := range c.Armies {
+			if removed[a.Cell] {
+				out.Armies = append(out.Armies, AffectedArmy{ID: a.ID, Name: a.Name, City: c.ID, Cell: a.Cell})
+			}
+		}
+	}
+	sort.Slice(out.Armies, func(i, j int) bool { return out.Armies[i].ID < out.Armies[j].ID })
+	return out
+}
+
+// faction tells the name of the faction of the city, either its owner or
+// the name of its overlord.
+func faction(reg *region.Region, c *region.City, byOverlord bool) string {
+	if !byOverlord {
+		return c.Owner
+	}
+	if c.Overlord != 0 {
+		if o := reg.CityGet(c.Overlord); o != nil {
+			c = o
+		}
+	}
+	return fmt.Sprintf("%s (%d)", c.Name, c.ID)
+}
+
+func decorate(reg *region.Region, byOverlord bool) mapclient.SvgDecoration {
+	deco := mapclient.SvgDecoration{
+		Fill:  make(map[uint64]string),
+		Class: make(map[uint64]string),
+	}
+
+	// Each faction gets its color
+	names := make([]string, 0)
+	colors := make(map[string]string)
+	for _, c := range reg.Cities {
+		if name := faction(reg, c, byOverlord); name != "" {
+			if _, ok := colors[name]; !ok {
+				colors[name] = ""
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		colors[name] = renderPalette[i%len(renderPalette)]
+	}
+
+	armyColor := make(map[string]string)
+	for _, c := range reg.Cities {
+		color := colors[faction(reg, c, byOverlord)]
+		if color != "" {
+			deco.Fill[c.ID] = color
+		}
+		deco.Class[c.ID] = "region-city"
+		for _, a := range c.Armies {
+			armyColor[a.ID] = color
+		}
+	}
+	for _, f := range reg.Fights {
+		deco.Class[f.Cell] = strings.TrimSpace(deco.Class[f.Cell] + " region-fight")
+	}
+
+	deco.Draw = func(w io.Writer, pos func(id uint64) (x, y int64, ok bool)) {
+		fmt.Fprintln(w, `<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M 0 0 L 10 5 L 0 10 z"/></marker></defs>`)
+
+		fmt.Fprintln(w, `<g class="region-fights">`)
+		for _, f := range reg.Fights {
+			if x, y, ok := pos(f.Cell); ok {
+				fmt.Fprintf(w, `<circle id="fight-%s" cx="%d" cy="%d" r="16" fill="none" stroke="red" stroke-width="3" stroke-dasharray="4 2"/>
+`, html.EscapeString(f.ID), x, y)
+			}
+		}
+		fmt.Fprintln(w, `</g>`)
+
+		fmt.Fprintln(w, `<g class="region-armies">`)
+		for _, c := range reg.Cities {
+			for _, a := range c.Armies {
+				x, y, ok := pos(a.Cell)
+				if !ok {
+					continue
+				}
+				color := armyColor[a.ID]
+				if color == "" {
+					color = "black"
+				}
+				if len(a.Targets) > 0 {
+					points := []string{fmt.Sprintf("%d,%d", x, y)}
+					for _, t := range a.Targets {
+						if tx, ty, ok := pos(t.Cell); ok {
+							points = append(points, fmt.Sprintf("%d,%d", tx, ty))
+						}
+					}
+					fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2" marker-end="url(#arrow)"/>
+`, strings.Join(points, " "), color)
+				}
+				fmt.Fprintf(w, `<rect id="army-%s" x="%d" y="%d" width="8" height="8" fill="%s" stroke="black"><title>%s</title></rect>
+`, html.EscapeString(a.ID), x-4, y-4, color, html.EscapeString(a.Name))
+			}
+		}
+		fmt.Fprintln(w, `</g>`)
+
+		// The legend maps the colors to the factions
+		fmt.Fprintln(w, `<g class="region-legend" font-family="sans-serif" font-size="12">`)
+		for i, name := range names {
+			fmt.Fprintf(w, `<rect x="4" y="%d" width="10" height="10" fill="%s" stroke="black"/><text x="18" y="%d">%s</text>
+`, 4+i*14, colors[name], 13+i*14, html.EscapeString(name))
+		}
+		fmt.Fprintln(w, `</g>`)
+	}
+	return deco
+}
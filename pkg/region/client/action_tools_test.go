@@ -0,0 +1,168 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package regclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/map/client"
+	"github.com/jfsmig/hegemonie/pkg/region/model"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// decodeRegion loads the live JSON of a region, as the tools do
+func decodeRegion(t *testing.T, encoded string) *region.Region {
+	t.Helper()
+	var reg region.Region
+	if err := json.NewDecoder(strings.NewReader(encoded)).Decode(&reg); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.PostLoad(); err != nil {
+		t.Fatal(err)
+	}
+	return &reg
+}
+
+func TestDiffRegion(t *testing.T) {
+	prev := mapclient.MapRaw{
+		ID: "m",
+		Sites: []mapclient.SiteRaw{
+			{ID: 1, X: 0, Y: 0, City: "Aster"},
+			{ID: 2, X: 10, Y: 0},
+			{ID: 3, X: 20, Y: 0, City: "Cumin"},
+		},
+		Roads: []mapclient.RoadRaw{{Src: 1, Dst: 2}, {Src: 2, Dst: 3}, {Src: 3, Dst: 1}},
+	}
+	next := mapclient.MapRaw{
+		ID:    "m",
+		Sites: []mapclient.SiteRaw{{ID: 1, X: 0, Y: 0, City: "Aster"}},
+		Roads: []mapclient.RoadRaw{},
+	}
+	reg := decodeRegion(t, `{"Name":"r", "MapName":"m", "Cities":[
+		{"Id":1, "Name":"Aster", "Owner":"alice", "Armies":[
+			{"Id":"a2", "Name":"moving", "Cell":2},
+			{"Id":"a1", "Name":"home", "Cell":1}]},
+		{"Id":3, "Name":"Cumin", "Owner":"bob", "Armies":[
+			{"Id":"b1", "Name":"besieged", "Cell":3}]}]}`)
+
+	out := diffRegion(reg, prev, next)
+	if out.Region != "r" || !reflect.DeepEqual(out.RemovedSites, []uint64{2, 3}) {
+		t.Fatalf("unexpected diff %+v", out)
+	}
+	if !reflect.DeepEqual(out.Cities, []AffectedCity{{ID: 3, Name: "Cumin", Owner: "bob"}}) {
+		t.Fatal("cities", out.Cities)
+	}
+	expected := []AffectedArmy{
+		{ID: "a2", Name: "moving", City: 1, Cell: 2},
+		{ID: "b1", Name: "besieged", City: 3, Cell: 3},
+	}
+	if !reflect.DeepEqual(out.Armies, expected) {
+		t.Fatal("armies", out.Armies)
+	}
+
+	// Nothing is affected when no site is removed
+	if out = diffRegion(reg, prev, prev); len(out.Cities) != 0 || len(out.Armies) != 0 {
+		t.Fatalf("unexpected diff %+v", out)
+	}
+}
+
+// renderRaw is a square of 4 sites with roads in both directions
+var renderRaw = mapclient.MapRaw{
+	ID: "m",
+	Sites: []mapclient.SiteRaw{
+		{ID: 1, X: 0, Y: 0, City: "Aster"},
+		{ID: 2, X: 100, Y: 0, City: "Borage"},
+		{ID: 3, X: 100, Y: 100, City: "Cumin"},
+		{ID: 4, X: 0, Y: 100},
+	},
+	Roads: []mapclient.RoadRaw{
+		{Src: 1, Dst: 2}, {Src: 2, Dst: 1},
+		{Src: 2, Dst: 3}, {Src: 3, Dst: 2},
+		{Src: 3, Dst: 4}, {Src: 4, Dst: 3},
+		{Src: 4, Dst: 1}, {Src: 1, Dst: 4},
+	},
+}
+
+// renderRegion has two owners, alice owning 2 cities of which one is the
+// overlord of the city of bob. An army of alice heads to bob through the
+// crossroad, another army besieges the city of bob.
+const renderRegion = `{"Name":"r", "MapName":"m", "Cities":[
+	{"Id":1, "Name":"Aster", "Owner":"alice", "Armies":[
+		{"Id":"a1", "Name":"march", "Cell":1, "Targets":[{"Cell":4}, {"Cell":2}]}]},
+	{"Id":2, "Name":"Borage", "Owner":"bob", "Overlord":1},
+	{"Id":3, "Name":"Cumin", "Owner":"alice"}],
+	"Fights":[{"Id":"f1", "Cell":2, "Attack":[{"Id":"a2", "Name":"siege", "Cell":2}], "Defense":[]}]}`
+
+func renderRegionSvg(t *testing.T) string {
+	t.Helper()
+	var out bytes.Buffer
+	args := mapclient.SvgArgs{CanvasArgs: mapclient.CanvasArgs{Width: 300, Height: 200, Padding: 20}}
+	deco := decorate(decodeRegion(t, renderRegion), false)
+	if err := mapclient.RenderSvg(&out, renderRaw, args, deco); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRenderDecorate(t *testing.T) {
+	reg := decodeRegion(t, renderRegion)
+
+	// Each owner gets its color, in the order of the names
+	deco := decorate(reg, false)
+	alice, bob := renderPalette[0], renderPalette[1]
+	if !reflect.DeepEqual(deco.Fill, map[uint64]string{1: alice, 2: bob, 3: alice}) {
+		t.Fatal("fill", deco.Fill)
+	}
+	// The liege shares the color of its overlord
+	deco = decorate(reg, true)
+	aster, cumin := renderPalette[0], renderPalette[1]
+	if !reflect.DeepEqual(deco.Fill, map[uint64]string{1: aster, 2: aster, 3: cumin}) {
+		t.Fatal("fill", deco.Fill)
+	}
+
+	if deco.Class[2] != "region-city region-fight" || deco.Class[1] != "region-city" {
+		t.Fatal("class", deco.Class)
+	}
+}
+
+func TestRenderSvg(t *testing.T) {
+	out := renderRegionSvg(t)
+
+	// The arrow of the army goes through the positions of its targets, as
+	// remapped on the canvas.
+	pos := make(map[string]string)
+	for _, m := range regexp.MustCompile(`<circle id="(\d+)" [^>]* cx="(\d+)" cy="(\d+)"`).FindAllStringSubmatch(out, -1) {
+		pos[m[1]] = m[2] + "," + m[3]
+	}
+	if len(pos) != 4 {
+		t.Fatal("sites", pos)
+	}
+	arrow := fmt.Sprintf(`<polyline points="%s %s %s" fill="none" stroke="%s" stroke-width="2" marker-end="url(#arrow)"/>`,
+		pos["1"], pos["4"], pos["2"], renderPalette[0])
+	if !strings.Contains(out, arrow) {
+		t.Fatal("no arrow", arrow, "in", out)
+	}
+	if !strings.Contains(out, `class="clickable region-city region-fight"`) || !strings.Contains(out, `id="fight-f1"`) {
+		t.Fatal("no fight in", out)
+	}
+
+	// The whole drawing, including the legend, is stable between runs
+	legend := regexp.MustCompile(`(?s)<g class="region-legend".*?</g>`)
+	first := legend.FindString(out)
+	if !strings.Contains(first, ">alice</text>") || !strings.Contains(first, ">bob</text>") {
+		t.Fatal("legend", first)
+	}
+	for i := 0; i < 5; i++ {
+		again := renderRegionSvg(t)
+		if legend.FindString(again) != first || again != out {
+			t.Fatal("unstable rendering")
+		}
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/jfsmig/hegemonie/pkg/utils"
 	"github.com/juju/errors"
 	"google.golang.org/grpc"
+	"io"
+	"os"
+	"strconv"
 )
 
 // ClientCLI gathers the actions destined to be exposed at the CLI, to manage a region service.
@@ -72,6 +75,71 @@ func (cli *ClientCLI) DoRegionPushStats(ctx context.Context, reg string) error {
 	})
 }
 
+// DoListSnapshots dumps to os.Stdout a JSON stream of the snapshots kept for
+// the region, from the oldest
+func (cli *ClientCLI) DoListSnapshots(ctx context.Context, reg string) error {
+	return cli.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := proto.NewAdminClient(cnx).ListSnapshots(ctx, &proto.RegionId{Region: reg})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.EncodeStream(func() (interface{}, error) { return rep.Recv() })
+	})
+}
+
+// DoRollback restores the region as it was in the snapshot with the given ID
+func (cli *ClientCLI) DoRollback(ctx context.Context, reg, snapID string) error {
+	id, err := strconv.ParseUint(snapID, 10, 64)
+	if err != nil {
+		return errors.NewNotValid(err, "snapshot ID")
+	}
+	return cli.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		_, err := proto.NewAdminClient(cnx).Rollback(ctx, &proto.SnapshotId{Region: reg, Id: id})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.StatusJSON(200, reg, "Rolled back to "+snapID)
+	})
+}
+
+// DoRepinMap pins the region on the version of its map currently served
+func (cli *ClientCLI) DoRepinMap(ctx context.Context, reg string) error {
+	return cli.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		_, err := proto.NewAdminClient(cnx).RepinMap(ctx, &proto.RegionId{Region: reg})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return utils.StatusJSON(200, reg, "Map pinned")
+	})
+}
+
+// DoExportSnapshot dumps to os.Stdout the JSON form of the snapshot with the
+// given ID
+func (cli *ClientCLI) DoExportSnapshot(ctx context.Context, reg, snapID string) error {
+	id, err := strconv.ParseUint(snapID, 10, 64)
+	if err != nil {
+		return errors.NewNotValid(err, "snapshot ID")
+	}
+	return cli.connect(ctx, func(ctx context.Context, cnx *grpc.ClientConn) error {
+		rep, err := proto.NewAdminClient(cnx).ExportSnapshot(ctx, &proto.SnapshotId{Region: reg, Id: id})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for {
+			chunk, err := rep.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if _, err = os.Stdout.Write(chunk.Data); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	})
+}
+
 type _resourcesAbs struct {
 	R0 uint64 `json:"r0"`
 	R1 uint64 `json:"r1"`
@@ -24,7 +24,16 @@ func (a *Army) ApplyAgressivity(w *Region) {
 	// FIXME(jfs): NYI
 }
 
+// Move plays the movement round of the current Army alone
 func (a *Army) Move(ctx context.Context, r *Region) {
+	a.move(r, func(src, dst uint64) (uint64, error) {
+		return r.world.mapView.Step(ctx, r.MapName, src, dst)
+	})
+}
+
+// move plays the movement round of the Army, with the next step of its path
+// given by step()
+func (a *Army) move(r *Region, step func(src, dst uint64) (uint64, error)) {
 	w := r.world
 
 	if a.Fight != "" {
@@ -40,7 +49,7 @@ func (a *Army) Move(ctx context.Context, r *Region) {
 
 		pLocalCity := r.CityGet(a.Cell)
 
-		nxt, err := w.mapView.Step(ctx, r.MapName, src, dst)
+		nxt, err := step(src, dst)
 		if err != nil || nxt == 0 {
 			if err != nil {
 				utils.Logger.Warn().Err(err).Uint64("src", src).Uint64("dst", dst).Send()
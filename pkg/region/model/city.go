@@ -33,6 +33,36 @@ func CopyCity(original *City) *City {
 	return c
 }
 
+// WLock acquires an exclusive ("writer") lock on the current City.
+// The caller must already hold a shared lock on the Region.
+func (c *City) WLock() { c.rw.Lock() }
+
+// WUnlock releases an exclusive ("writer") lock on the current City
+func (c *City) WUnlock() { c.rw.Unlock() }
+
+// RLock acquires a shared ("reader") lock on the current City.
+// The caller must already hold a shared lock on the Region.
+func (c *City) RLock() { c.rw.RLock() }
+
+// RUnlock releases a shared ("reader") lock on the current City
+func (c *City) RUnlock() { c.rw.RUnlock() }
+
+// Cells returns the IDs of the map cells the City depends on: its own
+// location, then the location and the targets of each of its armies.
+// The caller must hold a lock on the City.
+func (c *City) Cells() []uint64 {
+	out := []uint64{c.ID}
+	for _, a := range c.Armies {
+		if a.Cell != 0 {
+			out = append(out, a.Cell)
+		}
+		for _, t := range a.Targets {
+			out = append(out, t.Cell)
+		}
+	}
+	return out
+}
+
 // Return a Unit owned by the current City, given the Unit ID
 func (c *City) Unit(id string) *Unit {
 	return c.Units.Get(id)
@@ -86,6 +116,10 @@ func (c *City) GetProduction(w *World) *CityProduction {
 	p := &CityProduction{
 		Buildings: ResourceModifierNoop(),
 		Knowledge: ResourceModifierNoop(),
+		Site:      ResourceModifierNoop(),
+	}
+	if c.SiteBonus != nil {
+		p.Site = *c.SiteBonus
 	}
 
 	for _, b := range c.Buildings {
@@ -99,7 +133,7 @@ func (c *City) GetProduction(w *World) *CityProduction {
 
 	p.Base = c.Production.Copy()
 	p.Actual = c.Production.Copy()
-	p.Actual.Apply(p.Buildings, p.Knowledge)
+	p.Actual.Apply(p.Buildings, p.Knowledge, p.Site)
 	return p
 }
 
@@ -206,7 +240,38 @@ func (c *City) ProduceLocally(w *Region, p *CityProduction) Resources {
 	return prod
 }
 
-func (c *City) Produce(_ context.Context, w *Region) {
+// Produce plays a production round of the current City alone, the tax due to
+// its Overlord is paid immediately.
+func (c *City) Produce(ctx context.Context, w *Region) {
+	tax := c.produce(ctx, w)
+	c.payTax(w, tax)
+}
+
+// payTax transfers to the Overlord of the current City the tax already
+// removed from the stock of the City by produce()
+func (c *City) payTax(w *Region, tax Resources) {
+	if c.Overlord == 0 || c.pOverlord == nil {
+		return
+	}
+
+	// TODO(jfs): check for potential shortage
+	//  shortage := c.Tax.GreaterThan(tax)
+
+	if w.world.Config.InstantTransfers {
+		c.pOverlord.Stock.Add(tax)
+	} else {
+		c.SendResourcesTo(w, c.pOverlord, tax)
+	}
+
+	// FIXME(jfs): notify overlord
+	// FIXME(jfs): notify c
+}
+
+// produce plays the part of the production round that only involves the
+// current City, and returns the tax due to its Overlord. The tax is removed
+// from the stock but not transferred yet. produce() may run concurrently for
+// distinct cities of the same Region.
+func (c *City) produce(_ context.Context, w *Region) (tax Resources) {
 	// Pre-compute the modified values of Stock and Production.
 	// We just reuse a function that already does it (despite it does more)
 	prod0 := c.GetProduction(w.world)
@@ -218,25 +283,13 @@ func (c *City) Produce(_ context.Context, w *Region) {
 
 	if c.Overlord != 0 && c.pOverlord != nil {
 		// Compute the expected Tax based on the local production
-		var tax Resources = prod
+		tax = prod
 		tax.Multiply(c.TaxRate)
 		// Ensure the tax isn't superior to the actual production (to cope with
 		// invalid tax rates)
 		tax.TrimTo(c.Stock)
 		// Then preempt the tax from the stock
 		c.Stock.Remove(tax)
-
-		// TODO(jfs): check for potential shortage
-		//  shortage := c.Tax.GreaterThan(tax)
-
-		if w.world.Config.InstantTransfers {
-			c.pOverlord.Stock.Add(tax)
-		} else {
-			c.SendResourcesTo(w, c.pOverlord, tax)
-		}
-
-		// FIXME(jfs): notify overlord
-		// FIXME(jfs): notify c
 	}
 
 	// ATM the stock maybe still stores resources. We use them to make the assets evolve.
@@ -287,6 +340,7 @@ func (c *City) Produce(_ context.Context, w *Region) {
 	// At the end of the turn, ensure we do not hold more resources than the actual
 	// stock capacity (with the effect of all the multipliers)
 	c.Stock.TrimTo(stock.Actual)
+	return tax
 }
 
 // Set a tax rate on the current City, with the same ratio on every Resource.
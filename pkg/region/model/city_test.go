@@ -45,6 +45,25 @@ func TestCityProductionNoModifier(t *testing.T) {
 	})
 }
 
+func TestCityProductionSiteBonus(t *testing.T) {
+	fixtureWorld(t, func(ctx context.Context, t *testing.T, w *World) {
+		site := ResourceModifierOf([]float64{3}, []int64{0, 1})
+		r, err := w.CreateRegion("region", "map", []NamedCity{{Name: "city", ID: 1, Site: &site}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		city := r.Cities[0]
+		city.Production.SetValue(2)
+
+		expectedProd := ResourcesUniform(2)
+		expectedProd[0], expectedProd[1] = 6, 3
+		prod := city.GetProduction(w)
+		if !prod.Actual.Equals(expectedProd) {
+			t.Fatal("unexpected production, got", utils.JSON2Str(prod), "expected", utils.JSON2Str(expectedProd))
+		}
+	})
+}
+
 func TestCityProductionWithModifier(t *testing.T) {
 	fixtureRegion(t, func(ctx context.Context, t *testing.T, r *Region) {
 		city := r.Cities[0]
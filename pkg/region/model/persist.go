@@ -169,11 +169,8 @@ func (reg *Region) PostLoad() error {
 		} else {
 			sort.Sort(&c.Armies)
 		}
-		if c.lieges == nil {
-			c.lieges = make(SetOfCities, 0)
-		} else {
-			sort.Sort(&c.lieges)
-		}
+		// Rebuilt below from the Overlord of each City
+		c.lieges = make(SetOfCities, 0)
 
 		for _, a := range c.Armies {
 			// Link Armies to their City
@@ -182,6 +179,18 @@ func (reg *Region) PostLoad() error {
 		}
 	}
 
+	// Link the cities to their Overlord
+	for _, c := range reg.Cities {
+		c.pOverlord = nil
+		if c.Overlord == 0 {
+			continue
+		}
+		if o := reg.Cities.Get(c.Overlord); o != nil {
+			c.pOverlord = o
+			o.lieges.Add(c)
+		}
+	}
+
 	return nil
 }
 
@@ -216,6 +225,7 @@ func (w *World) LoadRegions(basedir string) error {
 		if err != nil {
 			return errors.Annotatef(err, "region decoding error [%s]", path)
 		}
+		reg.world = w
 		w.Regions.Add(reg)
 		return nil
 	})
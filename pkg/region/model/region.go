@@ -8,26 +8,180 @@ package region
 import (
 	"context"
 	"github.com/juju/errors"
+	"runtime"
+	"sync"
 )
 
+// WLock acquires an exclusive ("writer") lock on the current region.
+// The caller must already hold a shared lock on the World.
+func (reg *Region) WLock() { reg.rw.Lock() }
+
+// WUnlock releases an exclusive ("writer") lock on the current region
+func (reg *Region) WUnlock() { reg.rw.Unlock() }
+
+// RLock acquires a shared ("reader") lock on the current region.
+// The caller must already hold a shared lock on the World.
+func (reg *Region) RLock() { reg.rw.RLock() }
+
+// RUnlock releases a shared ("reader") lock on the current region
+func (reg *Region) RUnlock() { reg.rw.RUnlock() }
+
+// roundWorkers is the number of goroutines sharing the production of a round
+var roundWorkers = runtime.GOMAXPROCS(0)
+
+// routeWorkers is the number of concurrent path resolutions of a movement
+// round. They mostly wait for the map service.
+const routeWorkers = 32
+
 // Produce performs a production round that involves all the cities on the map of the region.
 // The round action might take long. But there is no notion of a transaction.
 // As a consequence, the action will ignore the cancellation signal brought by the context.Context.
 func (reg *Region) Produce(ctx context.Context) {
-	for _, c := range reg.Cities {
-		c.Produce(ctx, reg)
-	}
+	reg.Tick++
+	reg.produce(ctx, roundWorkers)
 }
 
 // Move performs a movement round that involves all the armies of all the cities on the map of the region.
 // The round action might take long. But there is no notion of a transaction.
 // As a consequence, the action will ignore the cancellation signal brought by the context.Context.
 func (reg *Region) Move(ctx context.Context) {
+	reg.Tick++
+	reg.move(ctx, routeWorkers)
+}
+
+// produce lets the cities produce concurrently, with the outcome of the
+// sequential loop over the cities in their order. The only effect of a City
+// on another City is the tax paid to its Overlord: in that loop, an Overlord
+// sorting after the payer has received the tax when its turn comes, while an
+// Overlord sorting before only receives it once its own turn is over.
+// So the cities produce by waves, a City in a later wave than the cities
+// sorting before it that pay it a tax, and the taxes toward an Overlord
+// sorting before the payer are applied at the end of the round.
+func (reg *Region) produce(ctx context.Context, workers int) {
+	index := make(map[*City]int, len(reg.Cities))
+	for i, c := range reg.Cities {
+		index[c] = i
+	}
+	// forward tells if the Overlord of the i-th City sorts after it
+	forward := func(i int) bool {
+		o, ok := index[reg.Cities[i].pOverlord]
+		return ok && o > i
+	}
+
+	wave := make([]int, len(reg.Cities))
+	waves := make([][]int, 0)
+	for i, c := range reg.Cities {
+		if forward(i) {
+			if o := index[c.pOverlord]; wave[o] <= wave[i] {
+				wave[o] = wave[i] + 1
+			}
+		}
+		for len(waves) <= wave[i] {
+			waves = append(waves, make([]int, 0))
+		}
+		waves[wave[i]] = append(waves[wave[i]], i)
+	}
+
+	taxes := make([]Resources, len(reg.Cities))
+	for _, members := range waves {
+		parallelize(len(members), workers, func(k int) {
+			i := members[k]
+			taxes[i] = reg.Cities[i].produce(ctx, reg)
+		})
+		for _, i := range members {
+			if forward(i) {
+				reg.Cities[i].payTax(reg, taxes[i])
+			}
+		}
+	}
+	for i, c := range reg.Cities {
+		if !forward(i) {
+			c.payTax(reg, taxes[i])
+		}
+	}
+}
+
+type routeKey struct {
+	src, dst uint64
+}
+
+type routeStep struct {
+	nxt uint64
+	err error
+}
+
+// move resolves concurrently the next step of all the armies on the move,
+// then applies the movements in the order of the cities and their armies.
+// The armies interact (e.g. fights) so the movements themselves remain
+// sequential. A path that changed during the round is resolved on demand.
+func (reg *Region) move(ctx context.Context, workers int) {
+	keys := make([]routeKey, 0)
+	known := make(map[routeKey]bool)
+	for _, c := range reg.Cities {
+		for _, a := range c.Armies {
+			if a.Fight != "" || len(a.Targets) <= 0 {
+				continue
+			}
+			k := routeKey{a.Cell, a.Targets[0].Cell}
+			if !known[k] {
+				known[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	steps := make([]routeStep, len(keys))
+	parallelize(len(keys), workers, func(i int) {
+		nxt, err := reg.world.mapView.Step(ctx, reg.MapName, keys[i].src, keys[i].dst)
+		steps[i] = routeStep{nxt, err}
+	})
+	routes := make(map[routeKey]routeStep, len(keys))
+	for i, k := range keys {
+		routes[k] = steps[i]
+	}
+
+	step := func(src, dst uint64) (uint64, error) {
+		if s, ok := routes[routeKey{src, dst}]; ok {
+			return s.nxt, s.err
+		}
+		return reg.world.mapView.Step(ctx, reg.MapName, src, dst)
+	}
 	for _, c := range reg.Cities {
 		for _, a := range c.Armies {
-			a.Move(ctx, reg)
+			a.move(reg, step)
+		}
+	}
+}
+
+// parallelize calls action once for each index in [0,n), from at most
+// workers goroutines that each handle a contiguous range of indices.
+func parallelize(n, workers int, action func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			action(i)
+		}
+		return
+	}
+
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for first := 0; first < n; first += chunk {
+		last := first + chunk
+		if last > n {
+			last = n
 		}
+		wg.Add(1)
+		go func(first, last int) {
+			defer wg.Done()
+			for i := first; i < last; i++ {
+				action(i)
+			}
+		}(first, last)
 	}
+	wg.Wait()
 }
 
 func (reg *Region) CityGet(id uint64) *City {
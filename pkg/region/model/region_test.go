@@ -0,0 +1,241 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package region
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"testing"
+	"time"
+)
+
+// lineMap is a map where the location N is only connected to N-1 and N+1
+type lineMap struct {
+	// latency simulates the round-trip to a remote map service
+	latency time.Duration
+}
+
+func (m *lineMap) Step(ctx context.Context, mapName string, src, dst uint64) (uint64, error) {
+	if m.latency > 0 {
+		time.Sleep(m.latency)
+	}
+	switch {
+	case src < dst:
+		return src + 1, nil
+	case src > dst:
+		return src - 1, nil
+	default:
+		return dst, nil
+	}
+}
+
+// largeRegion deterministically builds a Region of n cities, with chains of
+// overlords and armies on the move.
+func largeRegion(tb testing.TB, n int, m MapClient) *Region {
+	w, err := NewWorld()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w.SetMapClient(m)
+	// Silent, the rounds generate lots of events
+	w.notifier = &noEvt{}
+	w.Definitions = definitionSandbox()
+	w.Config.InstantTransfers = true
+	w.Config.RateOverlord = 0.25
+	w.Config.MassacreImpact = 0.5
+
+	cities := make([]NamedCity, 0, n)
+	for i := 1; i <= n; i++ {
+		cities = append(cities, NamedCity{Name: fmt.Sprintf("city-%d", i), ID: uint64(i)})
+	}
+	r, err := w.CreateRegion("region", "map", cities)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	bt := w.Definitions.Buildings[0]
+	for i, c := range r.Cities {
+		c.Production.SetValue(uint64(1 + i%7))
+		c.StockCapacity.SetValue(100)
+		c.Stock.SetValue(uint64(i % 50))
+		c.TicksMassacres = uint32(i % 3)
+		c.StartBuilding(bt).Ticks = uint32(i % 4)
+		if i > 0 && i%3 != 0 {
+			r.Cities[i/2].ConquerCity(w, c)
+		}
+		if i%5 == 0 {
+			c.Units.Add(&Unit{ID: uuid.New().String(), Type: 1, Health: 100})
+			a, err := c.CreateArmyFromIds(r, c.Units[0].ID)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			a.DeferMove(r, uint64(1+(i*7)%n), ActionArgMove{})
+			a.DeferDisband(r, uint64(1+(i*13)%n))
+		}
+	}
+	return r
+}
+
+// forwardLords gives to a part of the cities an Overlord that sorts after
+// them, in chains, so that the production must respect their order.
+func forwardLords(r *Region) {
+	n := len(r.Cities)
+	for i, c := range r.Cities {
+		var lord int
+		switch i % 3 {
+		case 0:
+			lord = i + 1
+		case 1:
+			lord = 2*i + 1
+		default:
+			continue
+		}
+		if c.pOverlord != nil {
+			c.GainFreedom(r.world)
+		}
+		if i > 0 && lord < n {
+			r.Cities[lord].ConquerCity(r.world, c)
+		}
+	}
+}
+
+// produceReference plays a production round as the sequential loop over the
+// cities did before the rounds ran in parallel.
+func produceReference(ctx context.Context, r *Region) {
+	for _, c := range r.Cities {
+		c.Produce(ctx, r)
+	}
+}
+
+func TestRegionProduceParallel(t *testing.T) {
+	ctx := context.Background()
+	for _, fixture := range []struct {
+		name  string
+		setup func(r *Region)
+	}{
+		{"lords first", func(r *Region) {}},
+		{"lords after", forwardLords},
+	} {
+		regions := make([]*Region, 3)
+		for i := range regions {
+			regions[i] = largeRegion(t, 500, &lineMap{})
+			fixture.setup(regions[i])
+		}
+		ref, seq, par := regions[0], regions[1], regions[2]
+		// Enough rounds for the stocks to reach their capacity
+		for round := 0; round < 30; round++ {
+			produceReference(ctx, ref)
+			seq.produce(ctx, 1)
+			par.produce(ctx, 8)
+			for i, c := range ref.Cities {
+				for _, other := range []*City{seq.Cities[i], par.Cities[i]} {
+					if !c.Stock.Equals(other.Stock) || c.TicksMassacres != other.TicksMassacres {
+						t.Fatal(fixture.name, "round", round, "city", c.ID, "stock", other.Stock, "expected", c.Stock)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRegionProduceTax(t *testing.T) {
+	ctx := context.Background()
+	r := largeRegion(t, 2, &lineMap{})
+	lord, liege := r.Cities[0], r.Cities[1]
+	lord.Stock.Zero()
+	lord.Production.Zero()
+	liege.Stock.Zero()
+	liege.Production.SetValue(8)
+	liege.TicksMassacres = 0
+	if liege.pOverlord != lord {
+		t.Fatal("unexpected overlord")
+	}
+
+	// The fixture building adds 1 to the production: the liege produces 9
+	// and pays 2, the lord produces 1 and receives 2.
+	r.produce(ctx, 4)
+	if !lord.Stock.Equals(ResourcesUniform(3)) || !liege.Stock.Equals(ResourcesUniform(7)) {
+		t.Fatal("unexpected stocks", lord.Stock, liege.Stock)
+	}
+
+	// A lord sorting after its liege receives the tax before its turn, so
+	// that the tax is trimmed to the capacity of the lord.
+	lord.GainFreedom(r.world)
+	liege, lord = lord, liege
+	lord.ConquerCity(r.world, liege)
+	liege.Stock.Zero()
+	liege.Production.SetValue(8)
+	liege.TicksMassacres = 0
+	lord.Stock.SetValue(100)
+	lord.Production.Zero()
+	r.produce(ctx, 4)
+	if !lord.Stock.Equals(ResourcesUniform(100)) || !liege.Stock.Equals(ResourcesUniform(7)) {
+		t.Fatal("unexpected stocks", lord.Stock, liege.Stock)
+	}
+}
+
+func TestRegionMoveParallel(t *testing.T) {
+	ctx := context.Background()
+	seq := largeRegion(t, 300, &lineMap{})
+	par := largeRegion(t, 300, &lineMap{})
+	for round := 0; round < 400; round++ {
+		seq.move(ctx, 1)
+		par.move(ctx, 8)
+	}
+	// The IDs of the armies are random, they are compared by content
+	signature := func(c *City) string {
+		out := make([]string, 0, len(c.Armies))
+		for _, a := range c.Armies {
+			out = append(out, fmt.Sprintf("%d/%d/%d", a.Cell, len(a.Targets), len(a.Units)))
+		}
+		sort.Strings(out)
+		return fmt.Sprintf("%d %v", len(c.Units), out)
+	}
+	for i, c := range seq.Cities {
+		if s0, s1 := signature(c), signature(par.Cities[i]); s0 != s1 {
+			t.Fatal("city", c.ID, "got", s1, "expected", s0)
+		}
+	}
+
+	// All the armies eventually disbanded
+	for _, c := range seq.Cities {
+		for _, a := range c.Armies {
+			if len(a.Targets) > 0 || len(a.Units) > 0 {
+				t.Fatal("city", c.ID, "army still busy at", a.Cell)
+			}
+		}
+	}
+}
+
+func benchmarkRound(b *testing.B, m MapClient, workers []int, round func(r *Region, workers int)) {
+	for _, n := range []int{1000, 10000} {
+		for _, workers := range workers {
+			r := largeRegion(b, n, m)
+			b.Run(fmt.Sprintf("%d/workers=%d", n, workers), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					round(r, workers)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRegionProduce(b *testing.B) {
+	ctx := context.Background()
+	benchmarkRound(b, &lineMap{}, []int{1, 4, 16}, func(r *Region, workers int) {
+		r.produce(ctx, workers)
+	})
+}
+
+func BenchmarkRegionMove(b *testing.B) {
+	ctx := context.Background()
+	benchmarkRound(b, &lineMap{latency: 100 * time.Microsecond}, []int{1, routeWorkers}, func(r *Region, workers int) {
+		r.move(ctx, workers)
+	})
+}
@@ -147,6 +147,19 @@ func ResourceModifierNoop() ResourceModifiers {
 	return ResourceModifierUniform(1.0, 0.0)
 }
 
+// ResourceModifierOf builds modifiers from arrays indexed like the resources.
+// The missing entries have no effect, the extra entries are ignored.
+func ResourceModifierOf(mult []float64, plus []int64) ResourceModifiers {
+	rm := ResourceModifierNoop()
+	for i := 0; i < ResourceMax && i < len(mult); i++ {
+		rm.Mult[i] = mult[i]
+	}
+	for i := 0; i < ResourceMax && i < len(plus); i++ {
+		rm.Plus[i] = plus[i]
+	}
+	return rm
+}
+
 func (o0 ResourceModifiers) Equals(o1 ResourceModifiers) bool {
 	for i := 0; i < ResourceMax; i++ {
 		if o0.Plus[i] != o1.Plus[i] || o0.Mult[i] != o1.Mult[i] {
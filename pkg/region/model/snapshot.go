@@ -0,0 +1,379 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package region
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jfsmig/hegemonie/pkg/utils"
+	"github.com/juju/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is the image of a Region taken before one of its rounds. It holds
+// enough to restore the cities of the Region, with their armies, and its
+// fights.
+type Snapshot struct {
+	// ID is unique among the snapshots of the Region and grows with time.
+	// It is set when the Snapshot is kept.
+	ID uint64 `json:"id"`
+
+	// Name of the Region
+	Region string `json:"region"`
+
+	// Tick of the Region when the Snapshot was taken
+	Tick uint64 `json:"tick"`
+
+	// UNIX timestamp of the Snapshot
+	When int64 `json:"when"`
+
+	// Round is the action that required the Snapshot, e.g. "produce"
+	Round string `json:"round"`
+
+	// JSON encoding of the Region
+	state json.RawMessage
+
+	// The City of each Army engaged in a Fight, that isn't serialized with
+	// the Army itself.
+	armyCities map[string]uint64
+}
+
+// snapshotFile is the form of a Snapshot on disk and in the exports
+type snapshotFile struct {
+	Snapshot
+	State      json.RawMessage   `json:"state"`
+	ArmyCities map[string]uint64 `json:"armyCities,omitempty"`
+}
+
+// TakeSnapshot encodes the current state of the Region. The caller must hold
+// a lock on the Region.
+func (reg *Region) TakeSnapshot(round string) (*Snapshot, error) {
+	state, err := json.Marshal(reg)
+	if err != nil {
+		return nil, errors.Annotate(err, "region encoding")
+	}
+
+	s := &Snapshot{
+		Region:     reg.Name,
+		Tick:       reg.Tick,
+		When:       time.Now().Unix(),
+		Round:      round,
+		state:      state,
+		armyCities: make(map[string]uint64),
+	}
+	collect := func(f *Fight) {
+		for _, side := range []SetOfArmies{f.Attack, f.Defense} {
+			for _, a := range side {
+				if a.City != nil {
+					s.armyCities[a.ID] = a.City.ID
+				}
+			}
+		}
+	}
+	for _, f := range reg.Fights {
+		collect(f)
+	}
+	for _, c := range reg.Cities {
+		if c.Assault != nil {
+			collect(c.Assault)
+		}
+	}
+	return s, nil
+}
+
+// Size returns the size of the encoded state of the Region
+func (s *Snapshot) Size() int { return len(s.state) }
+
+// Encode returns the JSON form of the Snapshot, as stored on disk
+func (s *Snapshot) Encode() ([]byte, error) {
+	return json.Marshal(&snapshotFile{Snapshot: *s, State: s.state, ArmyCities: s.armyCities})
+}
+
+// DecodeSnapshot parses the JSON form of a Snapshot produced by Encode
+func DecodeSnapshot(encoded []byte) (*Snapshot, error) {
+	var f snapshotFile
+	if err := json.Unmarshal(encoded, &f); err != nil {
+		return nil, errors.NewNotValid(err, "invalid snapshot")
+	}
+	if f.Region == "" || len(f.State) <= 0 {
+		return nil, errors.NotValidf("incomplete snapshot")
+	}
+	s := f.Snapshot
+	s.state, s.armyCities = f.State, f.ArmyCities
+	return &s, nil
+}
+
+// RollbackPlan is a Snapshot decoded and validated against its Region,
+// ready to be applied.
+type RollbackPlan struct {
+	region   *Region
+	snapshot *Snapshot
+	restored *Region
+}
+
+// PrepareRollback decodes the Snapshot and checks it matches the Region,
+// without altering the Region. The caller must hold a lock on the Region.
+func (reg *Region) PrepareRollback(s *Snapshot) (*RollbackPlan, error) {
+	if s.Region != reg.Name {
+		return nil, errors.NotValidf("snapshot of region %s", s.Region)
+	}
+	restored := &Region{world: reg.world}
+	if err := json.Unmarshal(s.state, restored); err != nil {
+		return nil, errors.NewNotValid(err, "invalid snapshot state")
+	}
+	// The Region is only consistent with its map
+	if restored.MapName != reg.MapName || restored.MapHash != reg.MapHash {
+		return nil, errors.NotValidf("snapshot on map %s@%s, region on map %s@%s",
+			restored.MapName, restored.MapHash, reg.MapName, reg.MapHash)
+	}
+	if err := restored.PostLoad(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	restored.linkFights(s.armyCities)
+	return &RollbackPlan{region: reg, snapshot: s, restored: restored}, nil
+}
+
+// Apply restores the cities, with their armies, and the fights of the
+// Region as they were in the Snapshot. The caller must hold an exclusive
+// lock on the Region.
+// Apply returns the notifications for the owners of the cities that changed,
+// appeared or vanished, at most one per owner. They are built but not sent,
+// so that the caller may send them once the lock released.
+func (p *RollbackPlan) Apply() []EventRollback {
+	reg := p.region
+	previous := make(map[uint64][]byte, len(reg.Cities))
+	for _, c := range reg.Cities {
+		// A City that cannot be encoded is considered as changed
+		previous[c.ID], _ = json.Marshal(c)
+	}
+	vanished := make([]*City, 0)
+	for _, c := range reg.Cities {
+		if !p.restored.Cities.Has(c.ID) {
+			vanished = append(vanished, c)
+		}
+	}
+
+	reg.Cities, reg.Fights, reg.Tick = p.restored.Cities, p.restored.Fights, p.restored.Tick
+
+	events := make([]EventRollback, 0)
+	owners := make(map[string]bool)
+	notify := func(c *City) {
+		if owners[c.Owner] {
+			return
+		}
+		owners[c.Owner] = true
+		events = append(events, reg.world.notifier.Rollback(c).Item(p.snapshot))
+	}
+	for _, c := range reg.Cities {
+		before, ok := previous[c.ID]
+		if after, err := json.Marshal(c); !ok || err != nil || !bytes.Equal(before, after) {
+			notify(c)
+		}
+	}
+	for _, c := range vanished {
+		notify(c)
+	}
+	return events
+}
+
+// linkFights makes the armies of the fights point to their City, and
+// shares the armies and the fights that appear several times in the
+// decoded Region.
+func (reg *Region) linkFights(armyCities map[string]uint64) {
+	armies := make(map[string]*Army)
+	for _, c := range reg.Cities {
+		for _, a := range c.Armies {
+			armies[a.ID] = a
+		}
+	}
+	link := func(side SetOfArmies) {
+		for i, a := range side {
+			if known, ok := armies[a.ID]; ok {
+				side[i] = known
+				continue
+			}
+			a.City = reg.Cities.Get(armyCities[a.ID])
+			armies[a.ID] = a
+		}
+	}
+
+	fights := make(map[string]*Fight)
+	for _, f := range reg.Fights {
+		link(f.Attack)
+		link(f.Defense)
+		fights[f.ID] = f
+	}
+	for _, c := range reg.Cities {
+		if c.Assault == nil {
+			continue
+		}
+		if f, ok := fights[c.Assault.ID]; ok {
+			c.Assault = f
+		} else {
+			link(c.Assault.Attack)
+			link(c.Assault.Defense)
+			fights[c.Assault.ID] = c.Assault
+		}
+	}
+}
+
+// Snapshots keeps the last snapshots of each Region, in memory and, when a
+// directory is configured, on disk. Each Region has its own subdirectory
+// with one JSON file per Snapshot.
+type Snapshots struct {
+	dir      string
+	keep     int
+	lock     sync.Mutex
+	byRegion map[string][]*Snapshot
+}
+
+// NewSnapshots instantiates a Snapshots that keeps the last snapshots of each
+// Region, and loads the snapshots already present in the directory.
+// An empty directory keeps the snapshots in memory only.
+func NewSnapshots(dir string, keep int) (*Snapshots, error) {
+	if keep <= 0 {
+		return nil, errors.NotValidf("snapshots count %d", keep)
+	}
+	ss := &Snapshots{dir: dir, keep: keep, byRegion: make(map[string][]*Snapshot)}
+	if dir == "" {
+		return ss, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Annotate(err, "snapshots directory")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Annotate(err, "snapshots directory")
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if err = ss.loadRegion(e.Name()); err != nil {
+			return nil, errors.Annotatef(err, "snapshots of %s", e.Name())
+		}
+	}
+	return ss, nil
+}
+
+func (ss *Snapshots) loadRegion(escaped string) error {
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(ss.dir, escaped))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tab := make([]*Snapshot, 0)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") || !f.Mode().IsRegular() {
+			continue
+		}
+		encoded, err := ioutil.ReadFile(filepath.Join(ss.dir, escaped, f.Name()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s, err := DecodeSnapshot(encoded)
+		if err != nil {
+			return errors.Annotate(err, f.Name())
+		}
+		if s.Region != name || f.Name() != snapshotFileName(s.ID) {
+			return errors.NotValidf("misplaced snapshot %s", f.Name())
+		}
+		tab = append(tab, s)
+	}
+	sort.Slice(tab, func(i, j int) bool { return tab[i].ID < tab[j].ID })
+	ss.byRegion[name] = tab
+	ss.evict(name)
+	return nil
+}
+
+func snapshotFileName(id uint64) string { return strconv.FormatUint(id, 10) + ".json" }
+
+func (ss *Snapshots) path(region string, id uint64) string {
+	return filepath.Join(ss.dir, url.PathEscape(region), snapshotFileName(id))
+}
+
+// evict forgets the oldest snapshots of the Region beyond the limit.
+// The caller must hold the lock.
+func (ss *Snapshots) evict(region string) {
+	tab := ss.byRegion[region]
+	for len(tab) > ss.keep {
+		if ss.dir != "" {
+			if err := os.Remove(ss.path(region, tab[0].ID)); err != nil && !os.IsNotExist(err) {
+				utils.Logger.Warn().Err(err).Str("region", region).Uint64("snapshot", tab[0].ID).Msg("eviction")
+			}
+		}
+		tab = tab[1:]
+	}
+	ss.byRegion[region] = tab
+}
+
+// Keep sets the ID of the Snapshot and then retains it, in place of the
+// oldest Snapshot of the Region when the limit is reached.
+func (ss *Snapshots) Keep(s *Snapshot) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	tab := ss.byRegion[s.Region]
+	s.ID = 1
+	if len(tab) > 0 {
+		s.ID = tab[len(tab)-1].ID + 1
+	}
+
+	if ss.dir != "" {
+		encoded, err := s.Encode()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		path := ss.path(s.Region, s.ID)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return errors.Trace(err)
+		}
+		// Written aside then renamed, to never expose a partial Snapshot
+		tmp := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+		if err = ioutil.WriteFile(tmp, encoded, 0644); err != nil {
+			return errors.Trace(err)
+		}
+		if err = os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return errors.Trace(err)
+		}
+	}
+
+	ss.byRegion[s.Region] = append(tab, s)
+	ss.evict(s.Region)
+	return nil
+}
+
+// List returns the snapshots kept for the Region, from the oldest
+func (ss *Snapshots) List(region string) []*Snapshot {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return append([]*Snapshot{}, ss.byRegion[region]...)
+}
+
+// Get returns the Snapshot of the Region with the given ID
+func (ss *Snapshots) Get(region string, id uint64) (*Snapshot, error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	for _, s := range ss.byRegion[region] {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, errors.NotFoundf("snapshot %d of region %s", id, region)
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2018-2021 Contributors as noted in the AUTHORS file
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package region
+
+import (
+	"context"
+	"fmt"
+	"github.com/juju/errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// rollbackCounter counts the rollback notifications per owner
+type rollbackCounter struct {
+	noEvt
+	owners map[string]int
+}
+
+type rollbackCount struct {
+	counter *rollbackCounter
+	city    *City
+}
+
+func (n *rollbackCounter) Rollback(to *City) EventRollback {
+	return &rollbackCount{counter: n, city: to}
+}
+
+func (evt *rollbackCount) Item(s *Snapshot) EventRollback { return evt }
+func (evt *rollbackCount) Send()                          { evt.counter.owners[evt.city.Owner]++ }
+
+// regionState summarizes what a rollback must restore
+func regionState(r *Region) string {
+	out := fmt.Sprintf("tick=%d fights=%d", r.Tick, len(r.Fights))
+	for _, c := range r.Cities {
+		out += fmt.Sprintf("|%d %v %d", c.ID, c.Stock, len(c.Units))
+		for _, a := range c.Armies {
+			out += fmt.Sprintf(" %s@%d/%d/%d", a.ID, a.Cell, len(a.Targets), len(a.Units))
+		}
+	}
+	return out
+}
+
+// rollbackTo restores the Region as it was in the Snapshot then sends the
+// notifications, as the Admin service does once the Region unlocked.
+func rollbackTo(r *Region, s *Snapshot) error {
+	p, err := r.PrepareRollback(s)
+	if err != nil {
+		return err
+	}
+	for _, evt := range p.Apply() {
+		evt.Send()
+	}
+	return nil
+}
+
+// fightRegion adds a Fight to a Region built by largeRegion
+func fightRegion(t *testing.T) *Region {
+	r := largeRegion(t, 20, &lineMap{})
+	attacker, defender := r.Cities[5], r.Cities[10]
+	a := attacker.Armies[0]
+	f := &Fight{ID: "fight", Cell: defender.ID, Attack: SetOfArmies{a}, Defense: SetOfArmies{}}
+	a.Fight = f.ID
+	defender.Assault = f
+	r.Fights = append(r.Fights, f)
+	return r
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	ctx := context.Background()
+	r := fightRegion(t)
+	expected := regionState(r)
+
+	s, err := r.TakeSnapshot("produce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		r.Produce(ctx)
+		r.Move(ctx)
+	}
+	if regionState(r) == expected {
+		t.Fatal("the rounds had no effect")
+	}
+
+	counter := &rollbackCounter{owners: make(map[string]int)}
+	r.world.notifier = counter
+	if err = rollbackTo(r, s); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(r); got != expected {
+		t.Fatal("got", got, "expected", expected)
+	}
+	if len(counter.owners) == 0 {
+		t.Fatal("no notification")
+	}
+	for owner, n := range counter.owners {
+		if n != 1 {
+			t.Fatal("owner", owner, "notified", n, "times")
+		}
+	}
+
+	// The links that aren't serialized are restored
+	attacker, defender := r.Cities[5], r.Cities[10]
+	if defender.Assault != r.Fights[0] {
+		t.Fatal("fight not shared")
+	}
+	if a := r.Fights[0].Attack[0]; a != attacker.Armies[0] || a.City != attacker {
+		t.Fatal("army not linked")
+	}
+	for _, c := range r.Cities {
+		if c.Overlord != 0 && (c.pOverlord == nil || !c.pOverlord.lieges.Has(c.ID) || r.Cities.Get(c.Overlord) != c.pOverlord) {
+			t.Fatal("city", c.ID, "overlord not linked")
+		}
+	}
+}
+
+// TestSnapshotRollbackNotify checks that only the owners of the cities that
+// changed, appeared or vanished are notified, once per owner.
+func TestSnapshotRollbackNotify(t *testing.T) {
+	r := largeRegion(t, 10, &lineMap{})
+	for _, c := range r.Cities {
+		c.Owner = fmt.Sprintf("char-%d", c.ID%3)
+	}
+	s, err := r.TakeSnapshot("produce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollback := func() map[string]int {
+		t.Helper()
+		counter := &rollbackCounter{owners: make(map[string]int)}
+		r.world.notifier = counter
+		p, err := r.PrepareRollback(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := p.Apply()
+		if len(counter.owners) > 0 {
+			t.Fatal("notifications sent by Apply")
+		}
+		for _, evt := range events {
+			evt.Send()
+		}
+		return counter.owners
+	}
+
+	if got := rollback(); len(got) != 0 {
+		t.Fatal("unexpected notifications", got)
+	}
+
+	// Two cities of the same owner
+	r.Cities[1].Stock.SetValue(1234)
+	r.Cities[4].TicksMassacres++
+	if got := rollback(); !reflect.DeepEqual(got, map[string]int{"char-2": 1}) {
+		t.Fatal("unexpected notifications", got)
+	}
+
+	// A City that appeared after the snapshot vanishes
+	c, err := r.CityCreateModel(42, r.Cities[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Owner = "newcomer"
+	if got := rollback(); !reflect.DeepEqual(got, map[string]int{"newcomer": 1}) {
+		t.Fatal("unexpected notifications", got)
+	}
+	if r.Cities.Has(42) {
+		t.Fatal("city not removed")
+	}
+}
+
+func TestSnapshotRollbackMismatch(t *testing.T) {
+	r := largeRegion(t, 5, &lineMap{})
+	s, err := r.TakeSnapshot("move")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := largeRegion(t, 5, &lineMap{})
+	other.Name = "other"
+	if _, err = other.PrepareRollback(s); !errors.IsNotValid(err) {
+		t.Fatal(err)
+	}
+
+	before := regionState(r)
+	r.Cities[0].Stock.SetValue(1234)
+	r.MapHash = "changed"
+	if err = rollbackTo(r, s); !errors.IsNotValid(err) {
+		t.Fatal(err)
+	}
+	// The region is left untouched
+	if regionState(r) == before || !r.Cities[0].Stock.Equals(ResourcesUniform(1234)) {
+		t.Fatal("unexpected rollback")
+	}
+}
+
+func TestSnapshotsKeep(t *testing.T) {
+	dir := t.TempDir()
+	r := fightRegion(t)
+	ss, err := NewSnapshots(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[uint64]string)
+	for i := 0; i < 5; i++ {
+		s, err := r.TakeSnapshot("produce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ss.Keep(s); err != nil {
+			t.Fatal(err)
+		}
+		states[s.ID] = regionState(r)
+		r.Produce(context.Background())
+	}
+
+	check := func(ss *Snapshots, ids ...uint64) {
+		t.Helper()
+		tab := ss.List(r.Name)
+		if len(tab) != len(ids) {
+			t.Fatal("kept", len(tab), "snapshots, expected", len(ids))
+		}
+		for i, s := range tab {
+			if s.ID != ids[i] || s.Tick != ids[i]-1 {
+				t.Fatal("snapshot", s.ID, "tick", s.Tick, "expected", ids[i])
+			}
+		}
+		files, err := filepath.Glob(filepath.Join(dir, r.Name, "*.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(ids) {
+			t.Fatal(len(files), "files, expected", len(ids))
+		}
+	}
+	check(ss, 3, 4, 5)
+	if _, err = ss.Get(r.Name, 1); !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+
+	// The snapshots survive a restart, with a smaller limit
+	ss, err = NewSnapshots(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(ss, 4, 5)
+
+	// A reloaded snapshot is as good as the original
+	s, err := ss.Get(r.Name, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rollbackTo(r, s); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(r); got != states[4] {
+		t.Fatal("got", got, "expected", states[4])
+	}
+	if r.Cities[10].Assault != r.Fights[0] || r.Fights[0].Attack[0].City != r.Cities[5] {
+		t.Fatal("fight not linked")
+	}
+}
+
+func TestSnapshotsCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	ss, err := NewSnapshots(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := largeRegion(t, 5, &lineMap{}).TakeSnapshot("move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.Keep(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, s.Region, "2.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSnapshots(dir, 3); !errors.IsNotValid(errors.Cause(err)) {
+		t.Fatal(err)
+	}
+
+	encoded, err := s.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, s.Region, "2.json"), encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSnapshots(dir, 3); !errors.IsNotValid(errors.Cause(err)) {
+		t.Fatal(err)
+	}
+
+	if _, err = NewSnapshots(dir, 0); !errors.IsNotValid(err) {
+		t.Fatal(err)
+	}
+}
@@ -110,6 +110,14 @@ type Region struct {
 	// Identifier of the map in use for the current Region
 	MapName string
 
+	// Version and content hash of the map when the Region has been created.
+	// The Region is only consistent with that exact content of the map.
+	MapVersion uint64
+	MapHash    string
+
+	// Number of rounds (production, movement) played on the Region
+	Tick uint64 `json:",omitempty"`
+
 	// All the cities present on the Region
 	Cities SetOfCities
 
@@ -119,6 +127,11 @@ type Region struct {
 
 	// Back-pointer to the World the current Region belongs to.
 	world *World
+
+	// Protects the Region, its set of Cities and its Fights. A round
+	// (production, movement) takes it exclusively, a request that works on
+	// a single City only shares it (then locks the City itself).
+	rw sync.RWMutex
 }
 
 type Resources [ResourceMax]uint64
@@ -146,6 +159,7 @@ type CityProduction struct {
 	Base      Resources
 	Knowledge ResourceModifiers
 	Buildings ResourceModifiers
+	Site      ResourceModifiers
 	Actual    Resources
 }
 
@@ -365,6 +379,11 @@ type City struct {
 	// Production Boosts ans Production Multipliers
 	Production Resources
 
+	// Alteration of the production due to the location of the City on the
+	// map, e.g. a fertile plain or a mine in the mountains.
+	// Nil when the location brings no bonus.
+	SiteBonus *ResourceModifiers `json:",omitempty"`
+
 	// Number of massacres the current City undergo.
 	// It takes one production turn to recover one Massacre.
 	TicksMassacres uint32 `json:",omitempty"`
@@ -398,6 +417,11 @@ type City struct {
 	// PRIVATE
 	// Pointer to cities we currently are the overlord of
 	lieges SetOfCities
+
+	// PRIVATE
+	// Protects the content of the City against the concurrent requests of
+	// the Characters. Only valid under a shared lock on the Region.
+	rw sync.RWMutex
 }
 
 // UnitType gathers the core statistics of a kind of Unit. It actually dictates the
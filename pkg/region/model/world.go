@@ -56,6 +56,8 @@ func (w *World) SetMapClient(c MapClient) {
 type NamedCity struct {
 	Name string
 	ID   uint64
+	// Site is the alteration of the production due to the location, if any
+	Site *ResourceModifiers
 }
 
 // CreateRegion instantiates and registers a Region into the current World.
@@ -78,6 +80,10 @@ func (w *World) CreateRegion(name, mapName string, cities []NamedCity) (*Region,
 			return nil, err
 		}
 		city.Name = x.Name
+		if x.Site != nil {
+			bonus := *x.Site
+			city.SiteBonus = &bonus
+		}
 	}
 	w.Regions.Add(r)
 	return r, nil
@@ -17,6 +17,8 @@ type Notifier interface {
 	Knowledge(log *City) EventKnowledge
 	// Prepare a notification context to inform :to: of someone hiring troops
 	Units(log *City) EventUnits
+	// Prepare a notification context to inform :to: of the rollback of its Region
+	Rollback(log *City) EventRollback
 }
 
 type EventArmy interface {
@@ -46,14 +48,26 @@ type EventUnits interface {
 	Send()
 }
 
+// EventRollback defines the builder of an event that informs a City that its
+// Region has been restored as it was in a Snapshot
+type EventRollback interface {
+	// Item collects the Snapshot restored
+	Item(s *Snapshot) EventRollback
+
+	// Send emits the event to the collector.
+	Send()
+}
+
 type noEvt struct{}
 type noEvtArmy struct{}
 type noEvtKnowledge struct{}
 type noEvtUnits struct{}
+type noEvtRollback struct{}
 
 func (n *noEvt) Army(to *City) EventArmy           { return &noEvtArmy{} }
 func (n *noEvt) Knowledge(to *City) EventKnowledge { return &noEvtKnowledge{} }
 func (n *noEvt) Units(to *City) EventUnits         { return &noEvtUnits{} }
+func (n *noEvt) Rollback(to *City) EventRollback   { return &noEvtRollback{} }
 
 func (ctx *noEvtArmy) Item(a *Army) EventArmy            { return ctx }
 func (ctx *noEvtArmy) Move(src, dst uint64) EventArmy    { return ctx }
@@ -68,6 +82,9 @@ func (ctx *noEvtUnits) Item(c *City, k *UnitType) EventUnits { return ctx }
 func (ctx *noEvtUnits) Step(current, max uint64) EventUnits  { return ctx }
 func (ctx *noEvtUnits) Send()                                {}
 
+func (ctx *noEvtRollback) Item(s *Snapshot) EventRollback { return ctx }
+func (ctx *noEvtRollback) Send()                          {}
+
 func LogEvent(n Notifier) Notifier {
 	return &eventLogger{sub: n}
 }
@@ -91,6 +108,11 @@ type logEvtUnits struct {
 	sub EventUnits
 }
 
+type logEvtRollback struct {
+	log *zerolog.Event
+	sub EventRollback
+}
+
 func logger(to *City) *zerolog.Event {
 	return utils.Logger.Info().
 		Str("logChar", to.Owner).
@@ -109,6 +131,10 @@ func (n *eventLogger) Units(to *City) EventUnits {
 	return &logEvtUnits{log: logger(to), sub: n.sub.Units(to)}
 }
 
+func (n *eventLogger) Rollback(to *City) EventRollback {
+	return &logEvtRollback{log: logger(to), sub: n.sub.Rollback(to)}
+}
+
 func (evt *logEvtArmy) Item(a *Army) EventArmy {
 	evt.sub.Item(a)
 	evt.log.Str("army", a.ID)
@@ -165,3 +191,14 @@ func (evt *logEvtUnits) Send() {
 	evt.sub.Send()
 	evt.log.Send()
 }
+
+func (evt *logEvtRollback) Item(s *Snapshot) EventRollback {
+	evt.sub.Item(s)
+	evt.log.Str("region", s.Region).Uint64("snapshot", s.ID).Uint64("tick", s.Tick)
+	return evt
+}
+
+func (evt *logEvtRollback) Send() {
+	evt.sub.Send()
+	evt.log.Send()
+}
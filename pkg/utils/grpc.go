@@ -119,3 +119,9 @@ type RegisterableMonitorable interface {
 	// client for health-check purposes.
 	Check(ctx context.Context) grpc_health_v1.HealthCheckResponse_ServingStatus
 }
+
+// Reloadable is implemented by the application backends able to reload their
+// data without a restart, e.g. upon a SIGHUP.
+type Reloadable interface {
+	Reload(ctx context.Context) error
+}